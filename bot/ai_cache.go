@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// AI応答のキャッシュ
+// =================================================================================
+
+const aiResponseCacheFile = "ai_response_cache.json"
+const defaultAICacheTTLHours = 24 * 30 // AI_CACHE_TTL_HOURSが未設定の場合の保持期間
+
+var aiResponseCache *receipt.ResponseCache
+
+// aiCacheTTL は環境変数AI_CACHE_TTL_HOURSからキャッシュの保持期間を決める（0以下で無期限）
+func aiCacheTTL() time.Duration {
+	hours := defaultAICacheTTLHours
+	if value := os.Getenv("AI_CACHE_TTL_HOURS"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			hours = parsed
+		} else {
+			log.Printf("AI_CACHE_TTL_HOURSが不正なため既定値を使用します: %s", value)
+		}
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// loadAIResponseCache は起動時にAI応答のキャッシュを読み込む
+func loadAIResponseCache() error {
+	aiResponseCache = receipt.NewResponseCache(aiResponseCacheFile, aiCacheTTL())
+	if err := aiResponseCache.Load(); err != nil {
+		return NewBotError(ErrorTypeFileIO, "AI応答キャッシュの読み込みに失敗", err).
+			WithContext("file_path", aiResponseCacheFile)
+	}
+	log.Printf("-> AI応答キャッシュを%d件読み込みました。", aiResponseCache.Len())
+	return nil
+}
+
+// receiptCacheKey は解析する画像と描画したプロンプトからキャッシュのキーを作る
+// キーは通常使うモデルのものなので、代替モデルの応答は保存しない（storeAIResponse）
+func receiptCacheKey(images []PreparedImage, promptVersion, prompt string) string {
+	data := make([][]byte, 0, len(images))
+	for _, img := range images {
+		data = append(data, img.Data)
+	}
+	return receipt.CacheKey(geminiClient.PrimaryModel(), promptVersion, prompt, data...)
+}
+
+// lookupAIResponse はキャッシュ済みのAI応答を返す
+func lookupAIResponse(key string) (string, bool) {
+	if aiResponseCache == nil {
+		return "", false
+	}
+	response, ok := aiResponseCache.Get(key, time.Now())
+	if ok {
+		log.Printf("AI応答キャッシュを使用: key=%s", key[:12])
+	}
+	return response, ok
+}
+
+// storeAIResponse はAI応答をキャッシュに保存する（modelは実際に応答したモデル）
+// 代替モデルの応答は通常のモデルのキーで保持期間いっぱい使われないよう保存しない
+func storeAIResponse(key, model, promptVersion, response string) {
+	if aiResponseCache == nil {
+		return
+	}
+	if model != geminiClient.PrimaryModel() {
+		log.Printf("代替モデル %s の応答のためキャッシュしません", model)
+		return
+	}
+	err := aiResponseCache.Put(key, receipt.CacheEntry{
+		Response:      response,
+		Model:         model,
+		PromptVersion: promptVersion,
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		HandleError(NewBotError(ErrorTypeFileIO, "AI応答キャッシュの保存に失敗", err).
+			WithContext("file_path", aiResponseCacheFile), nil)
+	}
+}
+
+// handleCache は /cache コマンドの処理
+func handleCache(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	content := "❌ 不明なサブコマンドです。"
+	if options := i.ApplicationCommandData().Options; len(options) > 0 && options[0].Name == "clear" {
+		count, err := 0, error(nil)
+		if aiResponseCache != nil {
+			count, err = aiResponseCache.Clear()
+		}
+		if err != nil {
+			HandleError(NewBotError(ErrorTypeFileIO, "AI応答キャッシュの削除に失敗", err).
+				WithContext("file_path", aiResponseCacheFile), nil)
+			content = "❌ キャッシュの削除に失敗しました。"
+		} else {
+			log.Printf("AI応答キャッシュを削除しました: %d件", count)
+			content = fmt.Sprintf("🗑️ AI応答キャッシュを%d件削除しました。", count)
+		}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("キャッシュ削除応答エラー: %v", err)
+	}
+}
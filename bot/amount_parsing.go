@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// 金額の解析
+// =================================================================================
+
+// toHalfWidth は全角英数字・記号を半角にする
+func toHalfWidth(text string) string {
+	return receipt.ToHalfWidth(text)
+}
+
+// parseAmount は「1,280円」「￥１２８０」「1280.0」「1.2万」「▲500」などの表記を円単位の整数にする
+// 負の値は返金・値引きとして扱い、小数は1円未満を四捨五入する
+func parseAmount(input string) (int, error) {
+	amount, err := receipt.ParseAmount(input)
+	if err != nil {
+		return 0, validationBotError(err)
+	}
+	return amount, nil
+}
+
+// validationBotError はreceiptパッケージの検証エラーをBotErrorに変換する
+func validationBotError(err error) error {
+	var validationErr *receipt.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	return NewBotError(ErrorTypeValidation, validationErr.Message, validationErr.Err).
+		WithContext("input", validationErr.Input)
+}
+
+// describeAmountError は金額の検証エラーをユーザー向けの文章にする
+func describeAmountError(input string, err error) string {
+	reason := "金額を解釈できません"
+	if botErr, ok := err.(*BotError); ok {
+		reason = botErr.Message
+	}
+	return fmt.Sprintf("❌ %s: 「%s」\n例: 1280、1,280円、￥１２８０、1.2万、-500（返金）", reason, input)
+}
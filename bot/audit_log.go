@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 監査ログ（支出・マスターデータの変更履歴）
+// =================================================================================
+
+const auditLogFile = "audit_log.jsonl"            // 家計のデータディレクトリに1行1件で追記する（書き換え・削除はしない）
+const defaultAuditLimit = 20                      // /auditで表示する件数の既定値
+const maxAuditLimit = 50                          // /auditで表示する件数の上限
+const maxAuditMessageLength = 1900                // Discordのメッセージ上限（2000文字）に収めるための上限
+const masterSnapshotFile = "master_snapshot.json" // 前回読み込んだマスターデータ（同期による変更を比較するため）
+
+// 監査ログの操作の種類
+const (
+	auditActionEntryEdit   = "entry.edit"   // 確認画面での項目の修正
+	auditActionEntryCancel = "entry.cancel" // 確認画面のキャンセル・残額のスキップ
+	auditActionExpenseAdd  = "expense.add"  // キューへの支出の追加
+	auditActionMasterAdd   = "master.add"   // マスターデータの追加申請
+	auditActionMasterSync  = "master.sync"  // 同期でマスターデータが変わったことを読み込み時に検出
+	auditActionExpenseUndo = "expense.undo" // キューへの操作の取り消し
+)
+
+var auditActionLabels = map[string]string{
+	auditActionEntryEdit:   "確認画面を修正",
+	auditActionEntryCancel: "確認画面をキャンセル",
+	auditActionExpenseAdd:  "支出を追加",
+	auditActionMasterAdd:   "マスターデータを追加",
+	auditActionMasterSync:  "マスターデータを同期",
+	auditActionExpenseUndo: "操作を取り消し",
+}
+
+// auditFieldLabels は変更された項目の表示名
+var auditFieldLabels = map[string]string{
+	"date": "日付", "amount": "金額", "payment_method": "支払い方法", "category": "カテゴリー",
+	"group": "グループ", "user": "支払者", "detail": "詳細", "name": "名前", "type_name": "支払い種別",
+}
+
+// auditFieldOrder は変更内容を記録・表示する順番
+var auditFieldOrder = []string{"date", "amount", "payment_method", "category", "group", "user", "detail", "name", "type_name"}
+
+// masterSyncTypes は同期による変更を記録するマスターデータの種別（/add_masterの種別とテーブル名）
+var masterSyncTypes = []string{"category", "group", "user", "payment_type", "source_list", "type_kind", "type_list"}
+
+// MasterSnapshot はマスターデータの種別ごとの「ID -> 記録対象の項目」
+type MasterSnapshot map[string]map[string]map[string]string
+
+// AuditChange は1項目分の変更前後の値
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditEntry は監査ログ1件
+type AuditEntry struct {
+	Time       time.Time     `json:"time"`
+	ActorID    string        `json:"actor_id"` // 操作したユーザーのDiscord ID
+	Action     string        `json:"action"`
+	EntityType string        `json:"entity_type"`          // entry（確認画面）, expense, master:<種別>
+	EntityID   string        `json:"entity_id"`            // 確認画面のメッセージID・支出ID・マスターデータキューのID
+	RelatedID  string        `json:"related_id,omitempty"` // 支出を追加した確認画面のメッセージIDなど
+	Changes    []AuditChange `json:"changes,omitempty"`
+}
+
+// auditActorID はインタラクションを実行したユーザーのIDを返す
+func auditActorID(i *discordgo.InteractionCreate) string {
+	if user := interactionUser(i); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// recordAudit は監査ログに1件追記する（書き込みに失敗しても操作自体は続ける）
+func (h *Household) recordAudit(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		HandleError(NewBotError(ErrorTypeFileIO, "監査ログのJSON生成エラー", err).
+			WithContext("action", entry.Action), nil)
+		return
+	}
+
+	h.auditMutex.Lock()
+	defer h.auditMutex.Unlock()
+	path := h.dataPath(auditLogFile)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		HandleError(NewBotError(ErrorTypeFileIO, "監査ログを開けません", err).
+			WithContext("file_path", path), nil)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		HandleError(NewBotError(ErrorTypeFileIO, "監査ログの書き込みエラー", err).
+			WithContext("file_path", path), nil)
+	}
+}
+
+// confirmationAuditValues は確認画面の記録対象の項目を表示用の値にする
+func (h *Household) confirmationAuditValues(data *ConfirmationData) map[string]string {
+	return map[string]string{
+		"date":           data.Date,
+		"amount":         fmt.Sprintf("%d", data.Amount),
+		"payment_method": data.PaymentMethod,
+		"category":       fmt.Sprintf("%s (%d)", h.categoryNameByID(data.CategoryID), data.CategoryID),
+		"group":          h.groupNameByID(data.GroupID),
+		"user":           fmt.Sprintf("%s (%d)", h.userNameByID(data.UserID), data.UserID),
+		"detail":         data.Detail,
+	}
+}
+
+// expenseAuditValues はExpenseの記録対象の項目を表示用の値にする
+func (h *Household) expenseAuditValues(expense Expense) map[string]string {
+	return map[string]string{
+		"date":     expense.Date,
+		"amount":   fmt.Sprintf("%d", expense.Price),
+		"category": fmt.Sprintf("%s (%d)", h.categoryNameByID(expense.CategoryID), expense.CategoryID),
+		"group":    h.groupNameByID(expense.GroupID),
+		"user":     fmt.Sprintf("%s (%d)", h.userNameByID(expense.UserID), expense.UserID),
+		"detail":   expense.Detail,
+	}
+}
+
+// diffAuditValues は変更のあった項目だけを返す
+func diffAuditValues(before, after map[string]string) []AuditChange {
+	var changes []AuditChange
+	for _, field := range auditFieldOrder {
+		if before[field] != after[field] {
+			changes = append(changes, AuditChange{Field: field, Before: before[field], After: after[field]})
+		}
+	}
+	return changes
+}
+
+// editConfirmationData は確認データを更新し、変更された項目を監査ログに記録する
+func editConfirmationData(i *discordgo.InteractionCreate, messageID string, updateFunc func(*ConfirmationData)) {
+	var h *Household
+	var changes []AuditChange
+	updateConfirmationData(messageID, func(data *ConfirmationData) {
+		h = data.Household
+		before := h.confirmationAuditValues(data)
+		updateFunc(data)
+		after := h.confirmationAuditValues(data)
+		changes = diffAuditValues(before, after)
+
+		// 重複の判定に使う項目が変わった場合は、別の支出と重複していないか改めて確認してもらう
+		if before["date"] != after["date"] || before["amount"] != after["amount"] || before["detail"] != after["detail"] {
+			data.ForceAdd = false
+		}
+	})
+	if h == nil || len(changes) == 0 {
+		return
+	}
+	h.recordAudit(AuditEntry{
+		ActorID:    auditActorID(i),
+		Action:     auditActionEntryEdit,
+		EntityType: "entry",
+		EntityID:   messageID,
+		Changes:    changes,
+	})
+}
+
+// recordExpenseAdded は確認画面からキューに追加した支出を監査ログに記録する
+func (h *Household) recordExpenseAdded(i *discordgo.InteractionCreate, messageID string, expense Expense) {
+	h.recordAudit(AuditEntry{
+		ActorID:    auditActorID(i),
+		Action:     auditActionExpenseAdd,
+		EntityType: "expense",
+		EntityID:   expense.ID,
+		RelatedID:  messageID,
+		Changes:    diffAuditValues(map[string]string{}, h.expenseAuditValues(expense)),
+	})
+}
+
+// recordEntryCancelled は確認画面のキャンセルを監査ログに記録する
+func (h *Household) recordEntryCancelled(i *discordgo.InteractionCreate, messageID string) {
+	h.recordAudit(AuditEntry{
+		ActorID:    auditActorID(i),
+		Action:     auditActionEntryCancel,
+		EntityType: "entry",
+		EntityID:   messageID,
+	})
+}
+
+// masterSnapshot は読み込み済みのマスターデータを比較用の形にする
+func (h *Household) masterSnapshot() MasterSnapshot {
+	snapshot := make(MasterSnapshot)
+	for _, masterType := range masterSyncTypes {
+		snapshot[masterType] = make(map[string]map[string]string)
+	}
+	for _, category := range h.masterCategories {
+		snapshot["category"][strconv.Itoa(category.ID)] = map[string]string{"name": category.Name}
+	}
+	for _, group := range h.masterGroups {
+		snapshot["group"][strconv.Itoa(group.ID)] = map[string]string{"name": group.Name}
+	}
+	for _, user := range h.masterUsers {
+		snapshot["user"][strconv.Itoa(user.ID)] = map[string]string{"name": user.Name}
+	}
+	for _, payment := range h.masterPaymentTypes {
+		snapshot["payment_type"][strconv.Itoa(payment.PayID)] = map[string]string{"name": payment.PayKind, "type_name": h.typeListMap[payment.TypeID]}
+	}
+	for _, source := range h.masterSourceList {
+		snapshot["source_list"][strconv.Itoa(source.ID)] = map[string]string{"name": source.SourceName, "type_name": h.typeKindMap[source.TypeID]}
+	}
+	for _, kind := range h.masterTypeKind {
+		snapshot["type_kind"][strconv.Itoa(kind.ID)] = map[string]string{"name": kind.TypeName}
+	}
+	for _, item := range h.masterTypeList {
+		snapshot["type_list"][item.ID] = map[string]string{"name": item.TypeName}
+	}
+	return snapshot
+}
+
+// diffMasterSnapshots は前回と今回のマスターデータを比較し、追加・変更・削除された項目を監査ログの形にする
+func diffMasterSnapshots(previous, current MasterSnapshot, actorID string, now time.Time) []AuditEntry {
+	var entries []AuditEntry
+	for _, masterType := range masterSyncTypes {
+		ids := slices.Collect(maps.Keys(previous[masterType]))
+		for id := range current[masterType] {
+			if _, exists := previous[masterType][id]; !exists {
+				ids = append(ids, id)
+			}
+		}
+		slices.Sort(ids)
+		for _, id := range ids {
+			changes := diffAuditValues(previous[masterType][id], current[masterType][id])
+			if len(changes) == 0 {
+				continue
+			}
+			entries = append(entries, AuditEntry{
+				Time:       now,
+				ActorID:    actorID,
+				Action:     auditActionMasterSync,
+				EntityType: "master:" + masterType,
+				EntityID:   id,
+				Changes:    changes,
+			})
+		}
+	}
+	return entries
+}
+
+// recordMasterSync はマスターデータを読み込んだ後に前回読み込んだ内容と比較し、
+// 同期で追加・変更・削除された項目を監査ログに記録する（actorIDは/reloadを実行したユーザー、起動時は空）
+func (h *Household) recordMasterSync(actorID string) {
+	path := h.dataPath(masterSnapshotFile)
+	current := h.masterSnapshot()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		// 初回は比較対象がないため、今回の内容を保存するだけにする
+	case err != nil:
+		HandleError(NewBotError(ErrorTypeFileIO, "マスターデータのスナップショットの読み込みエラー", err).
+			WithContext("file_path", path), nil)
+		return
+	default:
+		var previous MasterSnapshot
+		if err := json.Unmarshal(data, &previous); err != nil {
+			HandleError(NewBotError(ErrorTypeFileIO, "マスターデータのスナップショットのJSONパースエラー", err).
+				WithContext("file_path", path), nil)
+			break
+		}
+		entries := diffMasterSnapshots(previous, current, actorID, time.Now())
+		for _, entry := range entries {
+			h.recordAudit(entry)
+		}
+		if len(entries) > 0 {
+			log.Printf("同期によるマスターデータの変更を監査ログに記録しました: household=%s, %d件", h.ID, len(entries))
+		}
+	}
+
+	data, err = json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		HandleError(NewBotError(ErrorTypeFileIO, "マスターデータのスナップショットのJSON生成エラー", err), nil)
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		HandleError(NewBotError(ErrorTypeFileIO, "マスターデータのスナップショットの書き込みエラー", err).
+			WithContext("file_path", path), nil)
+	}
+}
+
+// loadAuditEntries は監査ログを古い順にすべて読み込む（壊れた行は読み飛ばす）
+func (h *Household) loadAuditEntries() ([]AuditEntry, error) {
+	h.auditMutex.Lock()
+	defer h.auditMutex.Unlock()
+
+	path := h.dataPath(auditLogFile)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, NewBotError(ErrorTypeFileIO, "監査ログの読み込みエラー", err).
+			WithContext("file_path", path)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("監査ログの不正な行を読み飛ばします: %v", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, NewBotError(ErrorTypeFileIO, "監査ログの読み込みエラー", err).
+			WithContext("file_path", path)
+	}
+	return entries, nil
+}
+
+// handleAudit は /audit コマンドの処理（対象のIDまたはユーザーで絞り込み、新しい順に表示する）
+func handleAudit(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	var entityID, actorID string
+	limit := defaultAuditLimit
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "entity":
+			entityID = strings.TrimSpace(option.StringValue())
+		case "user":
+			actorID = option.UserValue(nil).ID
+		case "limit":
+			limit = min(max(int(option.IntValue()), 1), maxAuditLimit)
+		}
+	}
+
+	content := h.describeAuditEntries(entityID, actorID, limit)
+	if runes := []rune(content); len(runes) > maxAuditMessageLength {
+		content = string(runes[:maxAuditMessageLength]) + "\n…（省略されました）"
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.Printf("監査ログ表示エラー: %v", err)
+	}
+}
+
+// describeAuditEntries は条件に合う監査ログを新しい順に整形する
+func (h *Household) describeAuditEntries(entityID, actorID string, limit int) string {
+	entries, err := h.loadAuditEntries()
+	if err != nil {
+		HandleError(err, nil)
+		return "❌ 監査ログを読み込めませんでした。"
+	}
+
+	var builder strings.Builder
+	builder.WriteString("📜 変更履歴")
+	if entityID != "" {
+		fmt.Fprintf(&builder, "（対象: `%s`）", entityID)
+	}
+	if actorID != "" {
+		fmt.Fprintf(&builder, "（ユーザー: <@%s>）", actorID)
+	}
+	builder.WriteString("\n")
+
+	shown := 0
+	for idx := len(entries) - 1; idx >= 0 && shown < limit; idx-- {
+		entry := entries[idx]
+		if entityID != "" && entry.EntityID != entityID && entry.RelatedID != entityID {
+			continue
+		}
+		if actorID != "" && entry.ActorID != actorID {
+			continue
+		}
+		shown++
+
+		action := auditActionLabels[entry.Action]
+		if action == "" {
+			action = entry.Action
+		}
+		actor := "システム"
+		if entry.ActorID != "" {
+			actor = fmt.Sprintf("<@%s>", entry.ActorID)
+		}
+		fmt.Fprintf(&builder, "・%s %s %s `%s`", entry.Time.In(tokyoLocation).Format("2006-01-02 15:04"), actor, action, entry.EntityID)
+		for _, change := range entry.Changes {
+			label := auditFieldLabels[change.Field]
+			if label == "" {
+				label = change.Field
+			}
+			switch {
+			case change.Before == "":
+				fmt.Fprintf(&builder, "\n　%s: %s", label, change.After)
+			case change.After == "":
+				fmt.Fprintf(&builder, "\n　%s: %s →（削除）", label, change.Before)
+			default:
+				fmt.Fprintf(&builder, "\n　%s: %s → %s", label, change.Before, change.After)
+			}
+		}
+		builder.WriteString("\n")
+	}
+	if shown == 0 {
+		builder.WriteString("該当する履歴はありません。")
+	}
+	return builder.String()
+}
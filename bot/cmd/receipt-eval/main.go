@@ -0,0 +1,511 @@
+// receipt-eval はラベル付きのレシート画像でAIの読み取り精度を測る評価ツール
+//
+// 評価データのディレクトリには、画像（.jpg/.jpeg/.png/.webp/.heic/.pdf）と同じ名前の正解JSONを置く。
+// 画像はBotと同じ変換・縮小（receipt.PrepareImages）を通してからAIに送る。
+//
+//	20250831_110948.jpg
+//	20250831_110948.json           正解（date・total・payment_method・store、省略した項目は採点しない）
+//	20250831_110948.response.txt   記録したAIの応答（-mode record で作成、replayで使用）
+//
+// 使い方:
+//
+//	go run ./cmd/receipt-eval -dir img                                  # 記録済みの応答で評価（オフライン、CI向け）
+//	go run ./cmd/receipt-eval -dir img -mode live -master dump.sql      # Gemini APIを呼び出して評価
+//	go run ./cmd/receipt-eval -dir img -mode record -master dump.sql    # Gemini APIを呼び出し、応答を記録する
+//
+// プロンプトに渡すカテゴリーは -master で指定したマスターデータのダンプから、Botと同じ順序で読み込む。
+// 画像を追加した場合やプロンプト・モデルを変えた場合は -mode record で応答を記録し直し、
+// *.response.txt をコミットする。replayは応答が記録されていない画像が1件でもあると終了コード1で終了する。
+//
+// -cache にBotのAI応答キャッシュ（ai_response_cache.json）を指定すると、画像・モデル・プロンプトの
+// テンプレート・カテゴリーがすべてBotと同じ場合に限り、Botが解析済みの応答を再利用できる。
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// 評価データ
+// =================================================================================
+
+const responseSuffix = ".response.txt" // 記録したAI応答のファイル名の末尾
+const minContainedRunes = 2            // 読み取った値に含まれていれば正解とする候補の最小文字数
+
+// imageExtensions は評価対象とする画像の拡張子
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true, ".heic": true, ".heif": true, ".pdf": true}
+
+// evalFields は採点する項目（表示順）
+var evalFields = []string{receipt.FieldDate, receipt.FieldAmount, receipt.FieldPayment, receipt.FieldStore}
+
+// Expected はレシート1枚分の正解
+type Expected struct {
+	Date          string         `json:"date,omitempty"`           // ISO形式（YYYY-MM-DD）
+	Total         *int           `json:"total,omitempty"`          // 合計金額
+	PaymentMethod acceptedValues `json:"payment_method,omitempty"` // 正解とみなす表記（読み取った値に含まれていれば正解、複数可）
+	Store         acceptedValues `json:"store,omitempty"`          // 正解とみなす店舗名（読み取った値に含まれていれば正解、複数可）
+}
+
+// acceptedValues は文字列または文字列の配列で書ける正解の候補
+type acceptedValues []string
+
+func (v *acceptedValues) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*v = acceptedValues{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("文字列または文字列の配列で指定してください: %w", err)
+	}
+	*v = multiple
+	return nil
+}
+
+// evalCase は評価データ1件
+type evalCase struct {
+	Name         string
+	ImagePath    string
+	ResponsePath string
+	Expected     Expected
+}
+
+// fieldResult は1項目の採点結果
+type fieldResult struct {
+	Scored  bool
+	Correct bool
+	Got     string
+	Want    string
+}
+
+// caseResult は評価データ1件の結果
+type caseResult struct {
+	Name    string
+	Latency time.Duration
+	Fields  map[string]fieldResult
+	Err     error
+	Skipped bool
+}
+
+// loadCases は評価データのディレクトリから、正解JSONがある画像を名前順に読み込む
+func loadCases(dir string) ([]evalCase, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("評価データのディレクトリを読み込めません: %w", err)
+	}
+
+	var cases []evalCase
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || !imageExtensions[ext] {
+			continue
+		}
+		base := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		expectedPath := filepath.Join(dir, base+".json")
+		data, err := os.ReadFile(expectedPath)
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("正解JSONがないためスキップします: %s", entry.Name())
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("正解JSONを読み込めません: %w", err)
+		}
+
+		var expected Expected
+		if err := json.Unmarshal(data, &expected); err != nil {
+			return nil, fmt.Errorf("正解JSONのパースエラー (%s): %w", expectedPath, err)
+		}
+		cases = append(cases, evalCase{
+			Name:         base,
+			ImagePath:    filepath.Join(dir, entry.Name()),
+			ResponsePath: filepath.Join(dir, base+responseSuffix),
+			Expected:     expected,
+		})
+	}
+	sort.Slice(cases, func(a, b int) bool { return cases[a].Name < cases[b].Name })
+	return cases, nil
+}
+
+// =================================================================================
+// 解析の実行
+// =================================================================================
+
+// analyzer はレシート画像からAIの応答テキストを得る
+type analyzer func(ctx context.Context, c evalCase) (string, error)
+
+// replayAnalyzer は記録済みの応答を返す（APIを呼び出さない）
+func replayAnalyzer(_ context.Context, c evalCase) (string, error) {
+	data, err := os.ReadFile(c.ResponsePath)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// newLiveAnalyzer はGemini APIで画像を解析するanalyzerを作る（recordがtrueの場合は応答を記録する）
+// 画像の変換とプロンプトの描画はBotと同じ処理を使い、categoriesはプロンプトに渡すカテゴリー
+// cacheがnilでない場合は、同じ画像・モデル・プロンプトのキャッシュ済みの応答を使う
+func newLiveAnalyzer(ctx context.Context, modelName string, prompts *receipt.PromptSet, categories []string, record bool, cache *receipt.ResponseCache) (analyzer, func() error, error) {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		return nil, nil, errors.New("GEMINI_API_KEY環境変数が設定されていません")
+	}
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Gemini APIクライアントの初期化に失敗: %w", err)
+	}
+	// 評価対象のモデルの精度を測るため、フォールバックせず流量制限と再試行だけを使う
+	config := receipt.DefaultResilienceConfig()
+	config.Models = []string{modelName}
+	model := receipt.NewResilientModel(client, config)
+
+	analyze := func(ctx context.Context, c evalCase) (string, error) {
+		data, err := os.ReadFile(c.ImagePath)
+		if err != nil {
+			return "", fmt.Errorf("画像を読み込めません: %w", err)
+		}
+		images, err := receipt.PrepareImages(data, "")
+		if err != nil {
+			return "", err
+		}
+		prompt, promptVersion, err := prompts.Render(receipt.ReceiptPrompt, receipt.NewReceiptPromptData(categories, len(images), false))
+		if err != nil {
+			return "", err
+		}
+
+		parts := make([]genai.Part, 0, len(images)+1)
+		imageData := make([][]byte, 0, len(images))
+		for _, img := range images {
+			parts = append(parts, genai.ImageData(img.Format(), img.Data))
+			imageData = append(imageData, img.Data)
+		}
+		parts = append(parts, genai.Text(prompt))
+
+		cacheKey := receipt.CacheKey(modelName, promptVersion, prompt, imageData...)
+		text, cached := "", false
+		if cache != nil {
+			text, cached = cache.Get(cacheKey, time.Now())
+		}
+		if !cached {
+			resp, _, err := model.GenerateContent(ctx, parts...)
+			if err != nil {
+				return "", fmt.Errorf("Gemini APIレシート解析エラー: %w", err)
+			}
+			if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+				return "", errors.New("AIから解析結果が返されませんでした")
+			}
+			response, _ := resp.Candidates[0].Content.Parts[0].(genai.Text)
+			text = string(response)
+			if cache != nil {
+				entry := receipt.CacheEntry{Response: text, Model: modelName, PromptVersion: promptVersion, CreatedAt: time.Now()}
+				if err := cache.Put(cacheKey, entry); err != nil {
+					log.Printf("キャッシュの保存に失敗: %v", err)
+				}
+			}
+		}
+		if record {
+			if err := os.WriteFile(c.ResponsePath, []byte(text), 0644); err != nil {
+				return "", fmt.Errorf("応答の記録に失敗: %w", err)
+			}
+		}
+		return text, nil
+	}
+	return analyze, client.Close, nil
+}
+
+// runCase は1件を解析して採点する（レイテンシは応答の取得からパースまで）
+func runCase(ctx context.Context, analyze analyzer, c evalCase) caseResult {
+	result := caseResult{Name: c.Name}
+	start := time.Now()
+	text, err := analyze(ctx, c)
+	if errors.Is(err, os.ErrNotExist) {
+		result.Skipped = true
+		return result
+	}
+	if err != nil {
+		result.Latency = time.Since(start)
+		result.Err = err
+		return result
+	}
+	analysis := receipt.ParseResponse(text, nil)
+	result.Latency = time.Since(start)
+	result.Fields = scoreCase(c.Expected, analysis)
+	return result
+}
+
+// =================================================================================
+// 採点
+// =================================================================================
+
+// normalizeText は表記ゆれ（全角英数字・大文字小文字・空白・中黒）を除いて比較用にする
+func normalizeText(text string) string {
+	text = strings.ToLower(receipt.ToHalfWidth(text))
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '・', '･':
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// matchesAny は読み取った値が正解の候補のいずれかと一致するか、候補を含むかを返す
+// 読み取った値が候補の一部だけの場合（「レストラン」など）は正解にしない
+func matchesAny(got string, accepted acceptedValues) bool {
+	normalized := normalizeText(got)
+	if normalized == "" {
+		return false
+	}
+	for _, want := range accepted {
+		wantNormalized := normalizeText(want)
+		if wantNormalized == "" {
+			continue
+		}
+		if normalized == wantNormalized {
+			return true
+		}
+		if utf8.RuneCountInString(wantNormalized) >= minContainedRunes && strings.Contains(normalized, wantNormalized) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueOrEmpty はnilの場合に空文字を返す
+func valueOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// scoreCase は解析結果を正解と項目ごとに比較する
+func scoreCase(expected Expected, analysis receipt.Analysis) map[string]fieldResult {
+	fields := make(map[string]fieldResult)
+
+	if expected.Date != "" {
+		// 年を省略した日付は正解の日付を基準に年を補う
+		now := time.Now().In(receipt.TokyoLocation)
+		if wantDate, err := time.ParseInLocation(receipt.ISODateLayout, expected.Date, receipt.TokyoLocation); err == nil {
+			now = wantDate
+		}
+		got := valueOrEmpty(analysis.Date)
+		normalized, err := receipt.NormalizeDate(got, now)
+		if err != nil {
+			normalized = got
+		}
+		fields[receipt.FieldDate] = fieldResult{Scored: true, Correct: err == nil && normalized == expected.Date, Got: normalized, Want: expected.Date}
+	}
+
+	if expected.Total != nil {
+		got := ""
+		if analysis.TotalAmount != nil {
+			got = fmt.Sprintf("%d", *analysis.TotalAmount)
+		}
+		correct := analysis.TotalAmount != nil && *analysis.TotalAmount == *expected.Total
+		fields[receipt.FieldAmount] = fieldResult{Scored: true, Correct: correct, Got: got, Want: fmt.Sprintf("%d", *expected.Total)}
+	}
+
+	if len(expected.PaymentMethod) > 0 {
+		got := valueOrEmpty(analysis.PaymentMethod)
+		fields[receipt.FieldPayment] = fieldResult{Scored: true, Correct: matchesAny(got, expected.PaymentMethod), Got: got, Want: strings.Join(expected.PaymentMethod, " / ")}
+	}
+
+	if len(expected.Store) > 0 {
+		got := valueOrEmpty(analysis.StoreName)
+		fields[receipt.FieldStore] = fieldResult{Scored: true, Correct: matchesAny(got, expected.Store), Got: got, Want: strings.Join(expected.Store, " / ")}
+	}
+
+	return fields
+}
+
+// =================================================================================
+// レポート
+// =================================================================================
+
+// percentile はソート済みのレイテンシからパーセンタイル値を返す
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted)-1) * p)
+	return sorted[index]
+}
+
+// incompleteCases は応答を記録していない件数と、解析に失敗した件数を返す
+func incompleteCases(results []caseResult) (skipped, failed int) {
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			skipped++
+		case result.Err != nil:
+			failed++
+		}
+	}
+	return skipped, failed
+}
+
+// printReport は件ごとの不一致と、項目ごとの正解率・レイテンシを出力し、全項目の正解率を返す
+func printReport(results []caseResult) float64 {
+	correct := make(map[string]int)
+	scored := make(map[string]int)
+	var latencies []time.Duration
+	skipped, failed := 0, 0
+
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			skipped++
+			fmt.Printf("- %s: 記録済みの応答がないためスキップ（-mode record で作成してください）\n", result.Name)
+			continue
+		case result.Err != nil:
+			failed++
+			fmt.Printf("✗ %s: %v\n", result.Name, result.Err)
+			continue
+		}
+
+		latencies = append(latencies, result.Latency)
+		var mismatches []string
+		for _, field := range evalFields {
+			fieldResult := result.Fields[field]
+			if !fieldResult.Scored {
+				continue
+			}
+			scored[field]++
+			if fieldResult.Correct {
+				correct[field]++
+			} else {
+				mismatches = append(mismatches, fmt.Sprintf("%s: 「%s」（正解: %s）", receipt.FieldLabels[field], fieldResult.Got, fieldResult.Want))
+			}
+		}
+		if len(mismatches) == 0 {
+			fmt.Printf("✓ %s (%s)\n", result.Name, result.Latency.Round(time.Millisecond))
+		} else {
+			fmt.Printf("✗ %s (%s)\n    %s\n", result.Name, result.Latency.Round(time.Millisecond), strings.Join(mismatches, "\n    "))
+		}
+	}
+
+	fmt.Printf("\n評価: %d件（スキップ %d件、エラー %d件）\n", len(results)-skipped-failed, skipped, failed)
+	totalCorrect, totalScored := 0, 0
+	for _, field := range evalFields {
+		if scored[field] == 0 {
+			fmt.Printf("  %s: -\n", receipt.FieldLabels[field])
+			continue
+		}
+		fmt.Printf("  %s: %.1f%% (%d/%d)\n", receipt.FieldLabels[field], 100*float64(correct[field])/float64(scored[field]), correct[field], scored[field])
+		totalCorrect += correct[field]
+		totalScored += scored[field]
+	}
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+		var sum time.Duration
+		for _, latency := range latencies {
+			sum += latency
+		}
+		fmt.Printf("  レイテンシ: 平均 %s / p50 %s / p95 %s / 最大 %s\n",
+			(sum / time.Duration(len(latencies))).Round(time.Millisecond),
+			percentile(latencies, 0.5).Round(time.Millisecond),
+			percentile(latencies, 0.95).Round(time.Millisecond),
+			latencies[len(latencies)-1].Round(time.Millisecond))
+	}
+
+	if totalScored == 0 {
+		return 0
+	}
+	accuracy := float64(totalCorrect) / float64(totalScored)
+	fmt.Printf("  全項目: %.1f%% (%d/%d)\n", 100*accuracy, totalCorrect, totalScored)
+	return accuracy
+}
+
+func main() {
+	dir := flag.String("dir", "img", "評価データ（画像と正解JSON）のディレクトリ")
+	mode := flag.String("mode", "replay", "replay: 記録済みの応答で評価 / live: APIを呼び出して評価 / record: APIを呼び出して応答を記録")
+	modelName := flag.String("model", receipt.Model, "liveとrecordで使用するGeminiのモデル")
+	promptsDir := flag.String("prompts", "", "liveとrecordで既定のプロンプトを上書きするテンプレートのディレクトリ（Botの./promptsと同じ形式）")
+	masterPath := flag.String("master", "", "liveとrecordでプロンプトに渡すカテゴリーを読み込むマスターデータのSQLダンプ（Botのmaster_data_pathと同じファイル）")
+	cachePath := flag.String("cache", "", "liveとrecordで使用するAI応答キャッシュのファイル（空の場合は使用しない）")
+	failUnder := flag.Float64("fail-under", 0, "全項目の正解率（0〜1）がこれを下回った場合に終了コード1で終了する")
+	flag.Parse()
+
+	cases, err := loadCases(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(cases) == 0 {
+		log.Fatalf("評価データがありません: %s", *dir)
+	}
+
+	ctx := context.Background()
+	var analyze analyzer
+	switch *mode {
+	case "replay":
+		analyze = replayAnalyzer
+	case "live", "record":
+		var cache *receipt.ResponseCache
+		if *cachePath != "" {
+			cache = receipt.NewResponseCache(*cachePath, 0)
+			if err := cache.Load(); err != nil {
+				log.Fatalf("キャッシュの読み込みに失敗: %v", err)
+			}
+		}
+		prompts, err := receipt.LoadPrompts(*promptsDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var categories []string
+		if *masterPath != "" {
+			sqlContent, err := os.ReadFile(*masterPath)
+			if err != nil {
+				log.Fatalf("マスターデータの読み込みに失敗: %v", err)
+			}
+			for _, category := range receipt.ParseMasterCategories(string(sqlContent)) {
+				categories = append(categories, category.Name)
+			}
+			log.Printf("%d件のカテゴリーをプロンプトに渡します", len(categories))
+		} else {
+			log.Printf("-master が指定されていないため、カテゴリーなしのプロンプトで解析します（Botのプロンプトとは異なります）")
+		}
+		live, closeClient, err := newLiveAnalyzer(ctx, *modelName, prompts, categories, *mode == "record", cache)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer closeClient()
+		analyze = live
+	default:
+		log.Fatalf("不明なモードです: %s（replay / live / record）", *mode)
+	}
+
+	results := make([]caseResult, 0, len(cases))
+	for _, c := range cases {
+		results = append(results, runCase(ctx, analyze, c))
+	}
+
+	accuracy := printReport(results)
+	if skipped, failed := incompleteCases(results); skipped > 0 || failed > 0 {
+		// 記録漏れや解析の失敗を正解率の評価に含めず通してしまわないよう、失敗として扱う
+		fmt.Printf("\n応答の記録がない画像が%d件、解析に失敗した画像が%d件あります。\n", skipped, failed)
+		os.Exit(1)
+	}
+	if *failUnder > 0 && accuracy < *failUnder {
+		fmt.Printf("\n全項目の正解率 %.1f%% が基準 %.1f%% を下回りました。\n", 100*accuracy, 100**failUnder)
+		os.Exit(1)
+	}
+}
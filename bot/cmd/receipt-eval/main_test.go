@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"yarikuri/receipt"
+)
+
+// labelDir はBotのレシート画像と正解JSONのディレクトリ
+const labelDir = "../../img"
+
+func TestLoadCasesReadsImageLabels(t *testing.T) {
+	cases, err := loadCases(labelDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name  string
+		total int
+	}{
+		{"20250831_110948", 770},
+		{"20250831_111041", 770},
+		{"20250831_173530", 2600},
+		{"20250901_134502", 600},
+	}
+	if len(cases) != len(want) {
+		t.Fatalf("loadCases() = %d件, want %d件", len(cases), len(want))
+	}
+	for idx, c := range cases {
+		if c.Name != want[idx].name || c.Expected.Total == nil || *c.Expected.Total != want[idx].total {
+			t.Errorf("cases[%d] = %s (total %v), want %s (total %d)", idx, c.Name, c.Expected.Total, want[idx].name, want[idx].total)
+		}
+		if c.Expected.Date == "" || len(c.Expected.PaymentMethod) == 0 || len(c.Expected.Store) == 0 {
+			t.Errorf("%s: 正解の項目が欠けています: %+v", c.Name, c.Expected)
+		}
+		if c.ResponsePath != filepath.Join(labelDir, c.Name+responseSuffix) {
+			t.Errorf("%s: ResponsePath = %s", c.Name, c.ResponsePath)
+		}
+	}
+}
+
+// TestReplayScoresImageLabels は正解JSONに対して、記録した応答と同じ形式のテキストを再生して採点する
+func TestReplayScoresImageLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string // 空の場合は応答を記録していない
+		correct  map[string]bool
+	}{
+		{
+			"20250831_110948",
+			"店舗名: 焼きたてのかるび 小金井貫井南店\n日付: 2025年8月31日\n金額: ¥770-\n支払い方法: 楽天ペイ\n詳細: カルビ丼",
+			map[string]bool{receipt.FieldDate: true, receipt.FieldAmount: true, receipt.FieldPayment: true, receipt.FieldStore: true},
+		},
+		{
+			"20250831_111041",
+			"店舗名: 焼きたてのかるび\n日付: ８/３１\n金額: 700円\n支払い方法: 楽天Pay",
+			map[string]bool{receipt.FieldDate: true, receipt.FieldAmount: false, receipt.FieldPayment: true, receipt.FieldStore: true},
+		},
+		{
+			"20250831_173530",
+			"店舗名: ボン ヴォヤージュ\n日付: R7.8.30\n金額: 2,600円(税込)\n支払い方法: 不明",
+			map[string]bool{receipt.FieldDate: false, receipt.FieldAmount: true, receipt.FieldPayment: false, receipt.FieldStore: true},
+		},
+		{"20250901_134502", "", nil},
+	}
+
+	// 正解JSONはBotのものをそのまま使い、画像の代わりに空のファイルを置く（replayは画像を読まない）
+	dir := t.TempDir()
+	for _, tt := range tests {
+		label, err := os.ReadFile(filepath.Join(labelDir, tt.name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{tt.name + ".json": string(label), tt.name + ".jpg": ""}
+		if tt.response != "" {
+			files[tt.name+responseSuffix] = tt.response
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cases, err := loadCases(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != len(tests) {
+		t.Fatalf("loadCases() = %d件, want %d件", len(cases), len(tests))
+	}
+
+	var results []caseResult
+	for idx, tt := range tests {
+		result := runCase(context.Background(), replayAnalyzer, cases[idx])
+		results = append(results, result)
+		if result.Err != nil {
+			t.Errorf("%s: runCase() returned error: %v", tt.name, result.Err)
+			continue
+		}
+		if result.Skipped != (tt.response == "") {
+			t.Errorf("%s: Skipped = %t", tt.name, result.Skipped)
+			continue
+		}
+		for _, field := range evalFields {
+			got := result.Fields[field]
+			if wantCorrect, scored := tt.correct[field]; got.Scored != scored || got.Correct != wantCorrect {
+				t.Errorf("%s: %s = %+v, want scored=%t correct=%t", tt.name, field, got, scored, wantCorrect)
+			}
+		}
+	}
+
+	// 採点した12項目のうち9項目が正解
+	if got, want := printReport(results), 9.0/12.0; got != want {
+		t.Errorf("printReport() = %f, want %f", got, want)
+	}
+	// 応答を記録していない画像があるため、正解率に関わらず失敗として扱う
+	if skipped, failed := incompleteCases(results); skipped != 1 || failed != 0 {
+		t.Errorf("incompleteCases() = %d, %d, want 1, 0", skipped, failed)
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	store := acceptedValues{"プラザパビリオン・レストラン", "東京ディズニーランド"}
+	tests := []struct {
+		got      string
+		accepted acceptedValues
+		want     bool
+	}{
+		{"プラザパビリオン・レストラン", store, true},
+		{"プラザ パビリオン･レストラン", store, true},
+		{"東京ディズニーランド プラザパビリオン・レストラン", store, true},
+		{"レストラン", store, false},
+		{"ン", store, false},
+		{"", store, false},
+		{"楽天Pay", acceptedValues{"楽天ペイ", "楽天pay"}, true},
+		{"VISAカード", acceptedValues{"VISA"}, true},
+		{"A", acceptedValues{"A"}, true},
+		{"AB", acceptedValues{"A"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.got, func(t *testing.T) {
+			if got := matchesAny(tt.got, tt.accepted); got != tt.want {
+				t.Errorf("matchesAny(%q, %v) = %t, want %t", tt.got, tt.accepted, got, tt.want)
+			}
+		})
+	}
+}
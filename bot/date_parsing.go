@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// 日付の正規化
+// =================================================================================
+
+const isoDateLayout = receipt.ISODateLayout // キューに保存する日付の形式
+
+// tokyoLocation は日付の解釈に使うタイムゾーン（サーバーのタイムゾーンに依存させない）
+var tokyoLocation = receipt.TokyoLocation
+
+// nowInTokyo は日本時間の現在時刻を返す
+func nowInTokyo() time.Time {
+	return time.Now().In(tokyoLocation)
+}
+
+// todayInTokyo は日本時間の今日の日付をISO形式で返す
+func todayInTokyo() string {
+	return nowInTokyo().Format(isoDateLayout)
+}
+
+// weekdayLabel は曜日を「月」「火」などの1文字で返す
+func weekdayLabel(t time.Time) string {
+	return []string{"日", "月", "火", "水", "木", "金", "土"}[t.Weekday()]
+}
+
+// normalizeDate は様々な表記の日付をISO形式（YYYY-MM-DD）に変換する
+// 和暦（R7.8.19、令和7年8月19日）、年の省略（8/19）、昨日・先週金曜などの相対表現に対応する
+func normalizeDate(input string, now time.Time) (string, error) {
+	date, err := receipt.NormalizeDate(input, now)
+	if err != nil {
+		return "", validationBotError(err)
+	}
+	return date, nil
+}
+
+// describeDateError は日付の検証エラーをユーザー向けの文章にする
+func describeDateError(input string, err error) string {
+	reason := "日付を解釈できません"
+	if botErr, ok := err.(*BotError); ok {
+		reason = botErr.Message
+	}
+	return fmt.Sprintf("❌ %s: 「%s」\n例: 2025-08-19、2025/8/19、8/19、R7.8.19、令和7年8月19日、昨日、先週金曜", reason, input)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 確定した詳細説明の学習（detail_samplesの自動更新）
+// =================================================================================
+
+const learnedDetailsFile = "learned_details.json" // 家計のデータディレクトリ内
+const maxLearnedDetailsPerCategory = 50           // カテゴリーごとに保持する詳細説明の件数（古いものから入れ替える）
+const detailSampleTokenBudget = 800               // プロンプトに含めるサンプルのトークン数の上限（目安）
+
+// LearnedDetail はキューに追加された支出の詳細説明1件
+type LearnedDetail struct {
+	Text       string    `json:"text"`
+	Edited     bool      `json:"edited"` // ユーザーが確認画面で書き直したものか（サンプルとして優先する）
+	Count      int       `json:"count"`  // 同じ詳細説明が確定した回数
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// estimateTokens はプロンプトのトークン数を概算する（日本語は1文字1トークン程度として数える）
+func estimateTokens(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// loadLearnedDetails は起動時に学習済みの詳細説明を読み込む
+func (h *Household) loadLearnedDetails() error {
+	h.learnedDetailMutex.Lock()
+	defer h.learnedDetailMutex.Unlock()
+
+	h.learnedDetails = make(map[string][]LearnedDetail)
+	path := h.dataPath(learnedDetailsFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return NewBotError(ErrorTypeFileIO, "学習済み詳細説明の読み込みに失敗", err).
+			WithContext("file_path", path)
+	}
+	if err := json.Unmarshal(data, &h.learnedDetails); err != nil {
+		h.learnedDetails = make(map[string][]LearnedDetail)
+		return NewBotError(ErrorTypeFileIO, "学習済み詳細説明のJSONパースエラー", err).
+			WithContext("file_path", path)
+	}
+
+	log.Printf("-> %dカテゴリーの学習済み詳細説明を読み込みました。", len(h.learnedDetails))
+	return nil
+}
+
+// saveLearnedDetailsLocked は学習済みの詳細説明を保存する（learnedDetailMutexを保持して呼ぶこと）
+func (h *Household) saveLearnedDetailsLocked() error {
+	data, err := json.MarshalIndent(h.learnedDetails, "", "  ")
+	if err != nil {
+		return NewBotError(ErrorTypeFileIO, "学習済み詳細説明JSON生成エラー", err)
+	}
+	path := h.dataPath(learnedDetailsFile)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return NewBotError(ErrorTypeFileIO, "学習済み詳細説明の書き込みに失敗", err).
+			WithContext("file_path", path)
+	}
+	return nil
+}
+
+// recordConfirmedDetail はキューに追加した支出の詳細説明をカテゴリーのサンプルとして記録する
+func (h *Household) recordConfirmedDetail(categoryID int, detail string, edited bool) {
+	categoryName := h.categoryNameByID(categoryID)
+	detail = strings.Join(strings.Fields(detail), " ")
+	if categoryName == "不明" || detail == "" {
+		return
+	}
+
+	h.learnedDetailMutex.Lock()
+	defer h.learnedDetailMutex.Unlock()
+
+	if h.learnedDetails == nil {
+		h.learnedDetails = make(map[string][]LearnedDetail)
+	}
+	entries := h.learnedDetails[categoryName]
+
+	// 同じ内容は1件にまとめる
+	key := normalizeForDuplicateCheck(detail)
+	found := false
+	for idx := range entries {
+		if normalizeForDuplicateCheck(entries[idx].Text) == key {
+			entries[idx].Count++
+			entries[idx].Edited = entries[idx].Edited || edited
+			entries[idx].LastUsedAt = time.Now()
+			found = true
+			break
+		}
+	}
+	if !found {
+		entries = append(entries, LearnedDetail{Text: detail, Edited: edited, Count: 1, LastUsedAt: time.Now()})
+	}
+
+	// 上限を超えた場合は、書き直されていないものから古い順に削除する
+	for len(entries) > maxLearnedDetailsPerCategory {
+		oldest := -1
+		for idx, entry := range entries {
+			if oldest == -1 ||
+				(!entry.Edited && entries[oldest].Edited) ||
+				(entry.Edited == entries[oldest].Edited && entry.LastUsedAt.Before(entries[oldest].LastUsedAt)) {
+				oldest = idx
+			}
+		}
+		entries = append(entries[:oldest], entries[oldest+1:]...)
+	}
+	h.learnedDetails[categoryName] = entries
+
+	if err := h.saveLearnedDetailsLocked(); err != nil {
+		HandleError(err, nil)
+		return
+	}
+	log.Printf("詳細説明を学習しました: %s「%s」(edited=%t)", categoryName, detail, edited)
+}
+
+// learnedDetailExamples はカテゴリーの学習済み詳細説明を、書き直されたもの・最近のものから順に返す
+func (h *Household) learnedDetailExamples(categoryName string) []string {
+	h.learnedDetailMutex.Lock()
+	entries := append([]LearnedDetail(nil), h.learnedDetails[categoryName]...)
+	h.learnedDetailMutex.Unlock()
+
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].Edited != entries[b].Edited {
+			return entries[a].Edited
+		}
+		return entries[a].LastUsedAt.After(entries[b].LastUsedAt)
+	})
+
+	examples := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		examples = append(examples, entry.Text)
+	}
+	return examples
+}
+
+// sampleFileLines はサンプルファイルの内容を空行を除いて行ごとに分ける
+func sampleFileLines(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// buildDetailFewShot は学習済みの詳細説明とサンプルファイルから、トークン上限内のサンプルを組み立てる
+func (h *Household) buildDetailFewShot(categoryName string) (string, bool) {
+	candidates := h.learnedDetailExamples(categoryName)
+	candidates = append(candidates, sampleFileLines(h.detailSample(categoryName))...)
+
+	seen := make(map[string]bool)
+	var examples []string
+	tokens := 0
+	for _, candidate := range candidates {
+		key := normalizeForDuplicateCheck(candidate)
+		if seen[key] {
+			continue
+		}
+		cost := estimateTokens(candidate) + 1
+		if tokens+cost > detailSampleTokenBudget {
+			break
+		}
+		seen[key] = true
+		examples = append(examples, candidate)
+		tokens += cost
+	}
+	return strings.Join(examples, "\n"), len(examples) > 0
+}
+
+// detailSample はカテゴリーの詳細説明サンプルを返す
+func (h *Household) detailSample(categoryName string) string {
+	h.detailSampleMutex.RLock()
+	defer h.detailSampleMutex.RUnlock()
+	return h.detailSamples[categoryName]
+}
+
+// exportLearnedDetails は学習済みの詳細説明をサンプルファイルに書き出し、書き出した行数を返す
+func (h *Household) exportLearnedDetails(categoryName string) (int, error) {
+	examples := h.learnedDetailExamples(categoryName)
+	if len(examples) == 0 {
+		return 0, nil
+	}
+
+	// 読み込み時と同じ家計のデータディレクトリに書き出す
+	samplesDir := h.dataPath(detailSamplesDir)
+	filePath := filepath.Join(samplesDir, categoryName+".txt")
+
+	h.detailSampleMutex.Lock()
+	defer h.detailSampleMutex.Unlock()
+	existing := sampleFileLines(h.detailSamples[categoryName])
+	if content, err := os.ReadFile(filePath); err == nil {
+		existing = sampleFileLines(string(content))
+	}
+
+	// 既存のサンプルを残したまま、まだ含まれていない詳細説明を追記する
+	seen := make(map[string]bool)
+	for _, line := range existing {
+		seen[normalizeForDuplicateCheck(line)] = true
+	}
+	lines := existing
+	added := 0
+	for _, example := range examples {
+		key := normalizeForDuplicateCheck(example)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		lines = append(lines, example)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	if err := os.MkdirAll(samplesDir, 0755); err != nil {
+		return 0, NewBotError(ErrorTypeFileIO, "詳細サンプルディレクトリの作成に失敗", err).
+			WithContext("dir", samplesDir)
+	}
+	content := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		return 0, NewBotError(ErrorTypeFileIO, "詳細サンプルファイルの書き込みに失敗", err).
+			WithContext("file_path", filePath)
+	}
+
+	// 次の生成から書き出した内容を使う
+	h.detailSamples[categoryName] = content
+	log.Printf("詳細説明サンプルを書き出しました: %s (%d件追加)", filePath, added)
+	return added, nil
+}
+
+// handleExportSamples は /export_samples コマンドの処理（学習済みの詳細説明を.txtに書き出す）
+func handleExportSamples(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	var targetName string
+	if options := i.ApplicationCommandData().Options; len(options) > 0 {
+		targetName = strings.TrimSpace(options[0].StringValue())
+	}
+
+	var categoryNames []string
+	if targetName != "" {
+		categoryID, found := h.findCategoryIDByName(targetName)
+		if !found {
+			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Content: fmt.Sprintf("❌ カテゴリー「%s」が見つかりません。", targetName),
+					Flags:   discordgo.MessageFlagsEphemeral,
+				},
+			})
+			return
+		}
+		categoryNames = []string{h.categoryNameByID(categoryID)}
+	} else {
+		for _, category := range h.masterCategories {
+			categoryNames = append(categoryNames, category.Name)
+		}
+	}
+
+	var lines []string
+	for _, categoryName := range categoryNames {
+		added, err := h.exportLearnedDetails(categoryName)
+		if err != nil {
+			HandleError(err, nil)
+			lines = append(lines, fmt.Sprintf("・%s: ❌ 書き出しに失敗しました", categoryName))
+			continue
+		}
+		if added > 0 {
+			lines = append(lines, fmt.Sprintf("・%s: %d件を追加", categoryName, added))
+		}
+	}
+
+	content := "📭 書き出す新しい詳細説明はありませんでした。"
+	if len(lines) > 0 {
+		content = fmt.Sprintf("📝 詳細説明サンプルを %s に書き出しました:\n%s", h.dataPath(detailSamplesDir), strings.Join(lines, "\n"))
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("サンプル書き出し応答エラー: %v", err)
+	}
+}
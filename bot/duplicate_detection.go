@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math/bits"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 重複レシート・重複支出の検出
+// =================================================================================
+
+const perceptualHashThreshold = 10 // 知覚ハッシュのハミング距離がこれ以下なら同じレシートとみなす
+const maxDuplicateMatches = 5      // 確認画面に表示する重複候補の最大件数
+
+// DuplicateMatch はキュー内またはレシートアーカイブで見つかった重複候補
+type DuplicateMatch struct {
+	Expense Expense
+	Reasons []string // 重複と判断した理由
+	Synced  bool     // キューから同期済みの支出（アーカイブの紐付けから見つけたため、IDとレシート画像以外は分からない）
+}
+
+// computePerceptualHash は画像のdHash（64bit）を計算する（デコードできない形式の場合はfalse）
+func computePerceptualHash(data []byte) (uint64, bool) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, false
+	}
+
+	// 9x8の輝度グリッドに縮小し、横に隣り合う画素の大小関係をビットにする
+	const width, height = 9, 8
+	bounds := img.Bounds()
+	if bounds.Dx() < width || bounds.Dy() < height {
+		return 0, false
+	}
+
+	var grid [height][width]uint64
+	for gy := 0; gy < height; gy++ {
+		y0 := bounds.Min.Y + gy*bounds.Dy()/height
+		y1 := bounds.Min.Y + (gy+1)*bounds.Dy()/height
+		for gx := 0; gx < width; gx++ {
+			x0 := bounds.Min.X + gx*bounds.Dx()/width
+			x1 := bounds.Min.X + (gx+1)*bounds.Dx()/width
+
+			// 大きな写真でも計算量が増えすぎないよう、領域内を間引いて平均を取る
+			stepX := max(1, (x1-x0)/16)
+			stepY := max(1, (y1-y0)/16)
+			var sum, count uint64
+			for y := y0; y < y1; y += stepY {
+				for x := x0; x < x1; x += stepX {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += (299*uint64(r) + 587*uint64(g) + 114*uint64(b)) / 1000
+					count++
+				}
+			}
+			if count > 0 {
+				grid[gy][gx] = sum / count
+			}
+		}
+	}
+
+	var hash uint64
+	for gy := 0; gy < height; gy++ {
+		for gx := 0; gx < width-1; gx++ {
+			hash <<= 1
+			if grid[gy][gx] < grid[gy][gx+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, true
+}
+
+// isSimilarReceiptHash は2つの知覚ハッシュが同じレシートを撮ったものと言えるほど近いかを判定する
+func isSimilarReceiptHash(a, b uint64) bool {
+	return bits.OnesCount64(a^b) <= perceptualHashThreshold
+}
+
+// receiptPerceptualHash はアーカイブ済み画像の知覚ハッシュを取得する
+func receiptPerceptualHash(key string) (uint64, bool) {
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	entry, exists := receiptArchive[key]
+	if !exists {
+		return 0, false
+	}
+	return entry.perceptualHash()
+}
+
+// perceptualHash はアーカイブ済み画像の知覚ハッシュを返す（記録がなければfalse）
+func (entry *ReceiptArchiveEntry) perceptualHash() (uint64, bool) {
+	if entry.PerceptualHash == "" {
+		return 0, false
+	}
+	hash, err := strconv.ParseUint(entry.PerceptualHash, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return hash, true
+}
+
+// ownsArchiveEntry はアーカイブ済み画像がこの家計の支出に使われたものかを判定する
+func (h *Household) ownsArchiveEntry(entry *ReceiptArchiveEntry) bool {
+	if len(entry.HouseholdIDs) == 0 {
+		// 家計の記録がない画像は、複数の家計に対応する前に既定の家計で登録されたもの
+		return h.ID == defaultHouseholdID
+	}
+	return slices.Contains(entry.HouseholdIDs, h.ID)
+}
+
+// findSyncedReceiptDuplicates は同期済み（キューに残っていない）の支出に使われた同じ・よく似たレシート画像をアーカイブから探す
+// queuedIDs はキューとの比較で判定済みの支出ID
+func (h *Household) findSyncedReceiptDuplicates(receiptKey string, candidateHash uint64, hasCandidateHash bool, queuedIDs map[string]bool) []DuplicateMatch {
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	reasons := make(map[string]string) // 支出ID -> 理由（同じ画像を優先する）
+	for key, entry := range receiptArchive {
+		if len(entry.ExpenseIDs) == 0 || !h.ownsArchiveEntry(entry) {
+			continue
+		}
+		var reason string
+		if key == receiptKey {
+			reason = "同期済みの支出と同じレシート画像"
+		} else if hash, ok := entry.perceptualHash(); ok && hasCandidateHash && isSimilarReceiptHash(candidateHash, hash) {
+			reason = "同期済みの支出とよく似たレシート画像"
+		}
+		if reason == "" {
+			continue
+		}
+		for _, expenseID := range entry.ExpenseIDs {
+			if queuedIDs[expenseID] || reasons[expenseID] == "同期済みの支出と同じレシート画像" {
+				continue
+			}
+			reasons[expenseID] = reason
+		}
+	}
+
+	var matches []DuplicateMatch
+	for expenseID, reason := range reasons {
+		matches = append(matches, DuplicateMatch{
+			Expense: Expense{ID: expenseID},
+			Reasons: []string{reason},
+			Synced:  true,
+		})
+	}
+	sort.Slice(matches, func(a, b int) bool { return matches[a].Expense.ID < matches[b].Expense.ID })
+	return matches
+}
+
+// normalizeForDuplicateCheck は比較用に空白を除去して小文字化する
+func normalizeForDuplicateCheck(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), ""))
+}
+
+// storeNameMatches は既存の支出が同じ店舗のものと思われるかを判定する
+func storeNameMatches(existingDetail, storeName, detail string) bool {
+	existing := normalizeForDuplicateCheck(existingDetail)
+	if existing == "" {
+		return false
+	}
+	if store := normalizeForDuplicateCheck(storeName); store != "" && strings.Contains(existing, store) {
+		return true
+	}
+	candidate := normalizeForDuplicateCheck(detail)
+	if candidate == "" {
+		return false
+	}
+	return existing == candidate || strings.Contains(existing, candidate) || strings.Contains(candidate, existing)
+}
+
+// findDuplicateExpenses はキュー内と同期済みのレシート画像から候補と重複していそうな支出を探す
+func (h *Household) findDuplicateExpenses(candidate Expense, storeName string) ([]DuplicateMatch, error) {
+	h.expenseQueueMutex.Lock()
+	expenseQueue, err := h.loadExpenseQueue()
+	h.expenseQueueMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	candidateHash, hasCandidateHash := uint64(0), false
+	if candidate.ReceiptKey != "" {
+		candidateHash, hasCandidateHash = receiptPerceptualHash(candidate.ReceiptKey)
+	}
+
+	var matches []DuplicateMatch
+	queuedIDs := make(map[string]bool)
+	for _, existing := range expenseQueue {
+		queuedIDs[existing.ID] = true
+		var reasons []string
+
+		// 1. 画像による判定（同一ファイル、または撮り直した同じレシート）
+		if candidate.ReceiptKey != "" && existing.ReceiptKey != "" {
+			if existing.ReceiptKey == candidate.ReceiptKey {
+				reasons = append(reasons, "同じレシート画像")
+			} else if hasCandidateHash {
+				if existingHash, ok := receiptPerceptualHash(existing.ReceiptKey); ok && isSimilarReceiptHash(candidateHash, existingHash) {
+					reasons = append(reasons, "よく似たレシート画像")
+				}
+			}
+		}
+
+		// 2. 内容による判定（手動追加との重複もここで検出する）
+		if existing.Date == candidate.Date && existing.Price == candidate.Price && candidate.Price != 0 &&
+			storeNameMatches(existing.Detail, storeName, candidate.Detail) {
+			reasons = append(reasons, "日付・金額・店舗が一致")
+		}
+
+		if len(reasons) > 0 {
+			matches = append(matches, DuplicateMatch{Expense: existing, Reasons: reasons})
+		}
+	}
+
+	// 3. 同期でキューからなくなった支出の画像（アーカイブの保持期間内のもの）
+	if candidate.ReceiptKey != "" {
+		matches = append(matches, h.findSyncedReceiptDuplicates(candidate.ReceiptKey, candidateHash, hasCandidateHash, queuedIDs)...)
+	}
+
+	if len(matches) > 0 {
+		log.Printf("重複候補を%d件検出しました: date=%s, amount=%d", len(matches), candidate.Date, candidate.Price)
+	}
+	return matches, nil
+}
+
+// refreshConfirmationDuplicates は確認データの現在の内容で重複候補を再計算する
+func refreshConfirmationDuplicates(messageID string) {
+	data := getConfirmationData(messageID)
+	if data == nil {
+		return
+	}
+
+	mu.Lock()
+	candidate := Expense{
+		Date:       data.Date,
+		Price:      data.Amount,
+		Detail:     data.Detail,
+		ReceiptKey: data.ReceiptKey,
+	}
+	var storeName string
+	if data.AIResult.StoreName != nil {
+		storeName = *data.AIResult.StoreName
+	}
+	mu.Unlock()
+
+	duplicates, err := data.Household.findDuplicateExpenses(candidate, storeName)
+	if err != nil {
+		HandleError(err, nil)
+		return
+	}
+
+	updateConfirmationData(messageID, func(data *ConfirmationData) {
+		data.Duplicates = duplicates
+	})
+}
+
+// duplicateWarningField は重複候補がある場合に確認画面へ表示する警告フィールドを返す
+func duplicateWarningField(data *ConfirmationData) *discordgo.MessageEmbedField {
+	if len(data.Duplicates) == 0 || data.ForceAdd {
+		return nil
+	}
+
+	var lines []string
+	for idx, match := range data.Duplicates {
+		if idx >= maxDuplicateMatches {
+			lines = append(lines, fmt.Sprintf("…ほか%d件", len(data.Duplicates)-maxDuplicateMatches))
+			break
+		}
+		if match.Synced {
+			lines = append(lines, fmt.Sprintf("・同期済みの支出 `%s`（%s）", match.Expense.ID, strings.Join(match.Reasons, "、")))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("・%s ¥%d %s（%s）",
+			match.Expense.Date, match.Expense.Price, match.Expense.Detail, strings.Join(match.Reasons, "、")))
+	}
+
+	return &discordgo.MessageEmbedField{
+		Name:   "⚠️ 重複の可能性",
+		Value:  strings.Join(lines, "\n") + "\n追加する場合は「それでも追加」をクリックしてください。",
+		Inline: false,
+	}
+}
+
+// handleForceAddToQueue は重複警告を確認したうえでキューに追加する
+func handleForceAddToQueue(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	messageID := strings.TrimPrefix(customID, "force_add_to_queue:")
+
+	updateConfirmationData(messageID, func(data *ConfirmationData) {
+		data.ForceAdd = true
+	})
+
+	log.Printf("重複警告を確認のうえ追加: messageID=%s", messageID)
+	addConfirmationToQueue(s, i, messageID)
+}
+
+// handleShowDuplicates は重複候補となった既存の支出を表示する
+func handleShowDuplicates(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	customID := i.MessageComponentData().CustomID
+	messageID := strings.TrimPrefix(customID, "show_duplicates:")
+
+	data := getConfirmationData(messageID)
+	if data == nil || len(data.Duplicates) == 0 {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "重複候補は見つかりませんでした。",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	var embeds []*discordgo.MessageEmbed
+	for idx, match := range data.Duplicates {
+		if idx >= maxDuplicateMatches {
+			break
+		}
+		expense := match.Expense
+		if match.Synced {
+			// 同期済みの支出は内容がキューに残っていないため、IDと判定理由だけを表示する
+			embeds = append(embeds, &discordgo.MessageEmbed{
+				Title: fmt.Sprintf("📄 同期済みの支出 (ID: %s)", expense.ID),
+				Color: 0xff9900,
+				Fields: []*discordgo.MessageEmbedField{
+					{Name: "🔍 判定理由", Value: strings.Join(match.Reasons, "、"), Inline: true},
+				},
+			})
+			continue
+		}
+
+		var categoryName string = "不明"
+		for _, category := range h.masterCategories {
+			if category.ID == expense.CategoryID {
+				categoryName = category.Name
+				break
+			}
+		}
+
+		receiptInfo := "なし"
+		if expense.ReceiptKey != "" {
+			receiptInfo = fmt.Sprintf("`/receipt id:%s` で表示", expense.ID)
+		}
+
+		embeds = append(embeds, &discordgo.MessageEmbed{
+			Title: fmt.Sprintf("📄 既存の支出 (ID: %s)", expense.ID),
+			Color: 0xff9900,
+			Fields: []*discordgo.MessageEmbedField{
+				{Name: "📅 日付", Value: expense.Date, Inline: true},
+				{Name: "💵 金額", Value: fmt.Sprintf("¥%d", expense.Price), Inline: true},
+				{Name: "📂 カテゴリー", Value: categoryName, Inline: true},
+				{Name: "📝 詳細", Value: expense.Detail, Inline: false},
+				{Name: "🔍 判定理由", Value: strings.Join(match.Reasons, "、"), Inline: true},
+				{Name: "🧾 レシート", Value: receiptInfo, Inline: true},
+			},
+		})
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("🔍 重複候補 (%d件):", len(data.Duplicates)),
+			Embeds:  embeds,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("重複候補表示エラー: %v", err)
+	}
+}
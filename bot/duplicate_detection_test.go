@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// encodeTestReceipt は明るさの分布をfuncで指定した画像をPNGにする
+func encodeTestReceipt(t *testing.T, width, height int, luminance func(x, y int) uint8) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: luminance(x, y)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// receiptPattern はレシートの行のような縞模様（幅・高さに対する相対位置で決まる）
+func receiptPattern(width, height int, shift uint8) func(x, y int) uint8 {
+	return func(x, y int) uint8 {
+		row := y * 16 / height
+		column := x * 9 / width
+		value := uint8((row*37 + column*53) % 200)
+		return value + shift
+	}
+}
+
+func TestComputePerceptualHashSimilarity(t *testing.T) {
+	original := encodeTestReceipt(t, 180, 320, receiptPattern(180, 320, 0))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		similar bool
+	}{
+		{"同じ画像", original, true},
+		{"縮小して撮り直し", encodeTestReceipt(t, 90, 160, receiptPattern(90, 160, 0)), true},
+		{"明るさが違う", encodeTestReceipt(t, 180, 320, receiptPattern(180, 320, 40)), true},
+		{"別のレシート", encodeTestReceipt(t, 180, 320, func(x, y int) uint8 {
+			return uint8((x*7 + y*3) % 256)
+		}), false},
+	}
+
+	base, ok := computePerceptualHash(original)
+	if !ok {
+		t.Fatal("computePerceptualHash(original) failed")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, ok := computePerceptualHash(tt.data)
+			if !ok {
+				t.Fatal("computePerceptualHash failed")
+			}
+			if got := isSimilarReceiptHash(base, hash); got != tt.similar {
+				t.Errorf("isSimilarReceiptHash() = %t (distance %d), want %t", got, bits.OnesCount64(base^hash), tt.similar)
+			}
+		})
+	}
+}
+
+func TestComputePerceptualHashRejectsTinyAndUnknownImages(t *testing.T) {
+	if _, ok := computePerceptualHash(encodeTestReceipt(t, 4, 4, receiptPattern(4, 4, 0))); ok {
+		t.Error("computePerceptualHash() succeeded for an image smaller than the hash grid")
+	}
+	if _, ok := computePerceptualHash([]byte("%PDF-1.7")); ok {
+		t.Error("computePerceptualHash() succeeded for data that is not an image")
+	}
+}
+
+func TestIsSimilarReceiptHash(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b uint64
+		want bool
+	}{
+		{"一致", 0xF0F0F0F0F0F0F0F0, 0xF0F0F0F0F0F0F0F0, true},
+		{"しきい値ちょうど", 0, 1<<perceptualHashThreshold - 1, true},
+		{"しきい値を超える", 0, 1<<(perceptualHashThreshold+1) - 1, false},
+		{"反転", 0, ^uint64(0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSimilarReceiptHash(tt.a, tt.b); got != tt.want {
+				t.Errorf("isSimilarReceiptHash(%x, %x) = %t, want %t", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreNameMatches(t *testing.T) {
+	tests := []struct {
+		name                    string
+		existing, store, detail string
+		want                    bool
+	}{
+		{"店舗名を含む", "食料品 - イオン 長野店", "イオン長野店", "", true},
+		{"詳細が一致", "ランチ", "", "ランチ", true},
+		{"詳細の一部", "コンビニ ランチ", "", "ランチ", true},
+		{"別の店舗", "食料品 - イオン", "西友", "日用品", false},
+		{"既存の詳細が空", "", "イオン", "イオン", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := storeNameMatches(tt.existing, tt.store, tt.detail); got != tt.want {
+				t.Errorf("storeNameMatches(%q, %q, %q) = %t, want %t", tt.existing, tt.store, tt.detail, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSyncedReceiptDuplicates(t *testing.T) {
+	saved := receiptArchive
+	t.Cleanup(func() { receiptArchive = saved })
+
+	hash := uint64(0xF0F0F0F0F0F0F0F0)
+	receiptArchive = map[string]*ReceiptArchiveEntry{
+		"same":     {Key: "same", ExpenseIDs: []string{"E1", "QUEUED"}, HouseholdIDs: []string{"home"}},
+		"retaken":  {Key: "retaken", PerceptualHash: fmt.Sprintf("%x", hash^0b111), ExpenseIDs: []string{"E2"}, HouseholdIDs: []string{"home"}},
+		"other":    {Key: "other", PerceptualHash: fmt.Sprintf("%x", ^hash), ExpenseIDs: []string{"E3"}, HouseholdIDs: []string{"home"}},
+		"neighbor": {Key: "neighbor", PerceptualHash: fmt.Sprintf("%x", hash), ExpenseIDs: []string{"E4"}, HouseholdIDs: []string{"office"}},
+		"unlinked": {Key: "unlinked", PerceptualHash: fmt.Sprintf("%x", hash)},
+	}
+	h := &Household{HouseholdConfig: HouseholdConfig{ID: "home"}}
+
+	matches := h.findSyncedReceiptDuplicates("same", hash, true, map[string]bool{"QUEUED": true})
+	want := map[string]string{
+		"E1": "同期済みの支出と同じレシート画像",
+		"E2": "同期済みの支出とよく似たレシート画像",
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("findSyncedReceiptDuplicates() = %+v, want %d matches", matches, len(want))
+	}
+	for _, match := range matches {
+		if !match.Synced || len(match.Reasons) != 1 || match.Reasons[0] != want[match.Expense.ID] {
+			t.Errorf("unexpected match %+v", match)
+		}
+	}
+}
+
+func TestOwnsArchiveEntry(t *testing.T) {
+	legacy := &ReceiptArchiveEntry{}
+	if !(&Household{HouseholdConfig: HouseholdConfig{ID: defaultHouseholdID}}).ownsArchiveEntry(legacy) {
+		t.Error("既定の家計が家計の記録のない画像を扱えません")
+	}
+	if (&Household{HouseholdConfig: HouseholdConfig{ID: "office"}}).ownsArchiveEntry(legacy) {
+		t.Error("既定以外の家計が家計の記録のない画像を扱っています")
+	}
+}
+
+func TestEditConfirmationDataResetsForceAdd(t *testing.T) {
+	h := &Household{HouseholdConfig: HouseholdConfig{ID: "home", DataDir: t.TempDir(), QueueDir: t.TempDir()}}
+	interaction := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{User: &discordgo.User{ID: "U1"}}}
+
+	tests := []struct {
+		name      string
+		edit      func(*ConfirmationData)
+		wantForce bool
+	}{
+		{"金額", func(data *ConfirmationData) { data.Amount = 980 }, false},
+		{"日付", func(data *ConfirmationData) { data.Date = "2025-08-25" }, false},
+		{"詳細（店舗）", func(data *ConfirmationData) { data.Detail = "西友" }, false},
+		{"支払い方法", func(data *ConfirmationData) { data.PaymentMethod = "現金" }, true},
+		{"変更なし", func(data *ConfirmationData) {}, true},
+	}
+	for idx, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageID := fmt.Sprintf("force-add-%d", idx)
+			storeConfirmationDataDirect(messageID, &ConfirmationData{
+				MessageID: messageID, Household: h, Date: "2025-08-24", Amount: 1280,
+				Detail: "イオン", PaymentMethod: "PayPay", ForceAdd: true,
+			})
+			t.Cleanup(func() {
+				mu.Lock()
+				delete(confirmationData, messageID)
+				mu.Unlock()
+			})
+
+			editConfirmationData(interaction, messageID, tt.edit)
+			if got := getConfirmationData(messageID).ForceAdd; got != tt.wantForce {
+				t.Errorf("ForceAdd = %t, want %t", got, tt.wantForce)
+			}
+		})
+	}
+}
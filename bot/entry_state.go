@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 確認画面の状態管理（二重送信の防止）
+// =================================================================================
+
+// ConfirmationStatus は確認画面の状態（編集中 → 登録処理中 → 登録済み の順にのみ進む）
+type ConfirmationStatus int
+
+const (
+	ConfirmationEditing    ConfirmationStatus = iota // 編集・キューへの追加ができる
+	ConfirmationSubmitting                           // キューへの書き込み中（他の操作は受け付けない）
+	ConfirmationSubmitted                            // キューに追加済み
+)
+
+const submittedEntryRetention = 24 * time.Hour // 登録済みの確認データを残しておく時間（遅れて押されたボタンに「登録済み」と応答する）
+
+// entryLock は確認画面1件分のロック（待っている処理がなくなったら破棄する）
+type entryLock struct {
+	mutex sync.Mutex
+	refs  int
+}
+
+var (
+	entryLocks      = make(map[string]*entryLock)
+	entryLocksMutex sync.Mutex
+)
+
+// lockEntry は確認画面ごとのロックを取得し、解放する関数を返す
+func lockEntry(messageID string) func() {
+	entryLocksMutex.Lock()
+	lock, exists := entryLocks[messageID]
+	if !exists {
+		lock = &entryLock{}
+		entryLocks[messageID] = lock
+	}
+	lock.refs++
+	entryLocksMutex.Unlock()
+
+	lock.mutex.Lock()
+	return func() {
+		lock.mutex.Unlock()
+		entryLocksMutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(entryLocks, messageID)
+		}
+		entryLocksMutex.Unlock()
+	}
+}
+
+// confirmationStatus は確認画面の現在の状態を返す（確認データがなければfalse）
+func confirmationStatus(messageID string) (ConfirmationStatus, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	data, exists := confirmationData[messageID]
+	if !exists {
+		return ConfirmationEditing, false
+	}
+	return data.Status, true
+}
+
+// beginSubmission は確認画面を登録処理中にする（編集中でなければfalse）
+func beginSubmission(messageID string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	data, exists := confirmationData[messageID]
+	if !exists || data.Status != ConfirmationEditing {
+		return false
+	}
+	data.Status = ConfirmationSubmitting
+	return true
+}
+
+// abortSubmission はキューへの書き込みに失敗した確認画面を編集中に戻す
+func abortSubmission(messageID string) {
+	updateConfirmationData(messageID, func(data *ConfirmationData) {
+		data.Status = ConfirmationEditing
+	})
+}
+
+// completeSubmission は確認画面を登録済みにし、一定時間後に確認データを破棄する
+func completeSubmission(messageID, expenseID string) {
+	updateConfirmationData(messageID, func(data *ConfirmationData) {
+		data.Status = ConfirmationSubmitted
+		data.ExpenseID = expenseID
+	})
+	time.AfterFunc(submittedEntryRetention, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if data, exists := confirmationData[messageID]; exists && data.Status == ConfirmationSubmitted {
+			delete(confirmationData, messageID)
+		}
+	})
+}
+
+// forgetSubmittedEntry は取り消された支出を登録した確認データを破棄する
+func forgetSubmittedEntry(expenseID string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for messageID, data := range confirmationData {
+		if data.Status == ConfirmationSubmitted && data.ExpenseID == expenseID {
+			delete(confirmationData, messageID)
+		}
+	}
+}
+
+// describeConfirmationStatus は編集できない確認画面を操作したときの応答を返す
+func describeConfirmationStatus(messageID string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	data, exists := confirmationData[messageID]
+	if !exists {
+		return "❌ エラー: データが見つかりません。"
+	}
+	switch data.Status {
+	case ConfirmationSubmitting:
+		return "⏳ キューに追加しています。しばらくお待ちください。"
+	case ConfirmationSubmitted:
+		return fmt.Sprintf("✅ この支出は登録済みです。(ID: `%s`)", data.ExpenseID)
+	}
+	return ""
+}
+
+// rejectLockedEntry は登録処理中・登録済みの確認画面への操作を拒否し、拒否した場合はtrueを返す
+func rejectLockedEntry(s *discordgo.Session, i *discordgo.InteractionCreate) bool {
+	messageID := entryMessageID(interactionCustomID(i))
+	if messageID == "" {
+		return false
+	}
+	if status, exists := confirmationStatus(messageID); !exists || status == ConfirmationEditing {
+		return false
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: describeConfirmationStatus(messageID),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("登録済み応答エラー: %v", err)
+	}
+	return true
+}
+
+// submitConfirmation は確認画面の支出をキューに追加し、確認画面を登録済みにする（追加できた場合はtrue）
+// 別の操作で登録処理中・登録済みになっている場合や保存に失敗した場合は、その旨を応答してfalseを返す
+func (h *Household) submitConfirmation(s *discordgo.Session, i *discordgo.InteractionCreate, messageID string, expense Expense) bool {
+	// 書き込み中は確認画面の編集を受け付けない
+	if !beginSubmission(messageID) {
+		notice := describeConfirmationStatus(messageID)
+		if notice == "" {
+			notice = "❌ エラー: データが見つかりません。"
+		}
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: notice,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return false
+	}
+
+	if err := h.saveExpenseToQueue(expense, auditActorID(i)); err != nil {
+		botErr := NewBotError(ErrorTypeFileIO, "Expenseキューファイル保存エラー", err).
+			WithContext("message_id", messageID).
+			WithContext("expense", fmt.Sprintf("%+v", expense))
+		LogBotError(botErr)
+		abortSubmission(messageID)
+
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "❌ エラー: キューへの保存に失敗しました。",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return false
+	}
+
+	log.Printf("キューに追加: %+v", expense)
+	completeSubmission(messageID, expense.ID)
+	markConfirmationSubmitted(s, i, expense.ID)
+	h.recordExpenseAdded(i, messageID, expense)
+	linkReceiptToExpense(expense.ReceiptKey, expense.ID, h.ID)
+	return true
+}
+
+// markConfirmationSubmitted は押された確認画面のボタンを無効にし、「登録済み」と表示する
+// エフェメラルメッセージはチャンネルのメッセージとして編集できないため、そのままにする
+func markConfirmationSubmitted(s *discordgo.Session, i *discordgo.InteractionCreate, expenseID string) {
+	message := i.Message
+	if message == nil || message.Flags&discordgo.MessageFlagsEphemeral != 0 {
+		return
+	}
+
+	label := fmt.Sprintf("✅ 登録済み (ID: %s)", expenseID)
+	embeds := message.Embeds
+	for _, embed := range embeds {
+		embed.Color = 0x808080
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: label}
+	}
+	content := message.Content
+	if len(embeds) == 0 {
+		content = strings.TrimSpace(content + "\n" + label)
+	}
+	components := disableComponents(message.Components)
+
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         message.ID,
+		Channel:    message.ChannelID,
+		Content:    &content,
+		Embeds:     &embeds,
+		Components: &components,
+	})
+	if err != nil {
+		log.Printf("確認画面を登録済みに更新できませんでした: messageID=%s, err=%v", message.ID, err)
+	}
+}
+
+// disableComponents はボタンとセレクトメニューをすべて無効にする
+func disableComponents(components []discordgo.MessageComponent) []discordgo.MessageComponent {
+	for _, component := range components {
+		switch c := component.(type) {
+		case *discordgo.ActionsRow:
+			disableComponents(c.Components)
+		case *discordgo.Button:
+			c.Disabled = true
+		case *discordgo.SelectMenu:
+			c.Disabled = true
+		}
+	}
+	return components
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSubmitConfirmationAddsOnlyWhileEditing(t *testing.T) {
+	h, s, transport := newRecurringTestHousehold(t, t.TempDir())
+	i := &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{ID: "I1", Token: "token"}}
+
+	tests := []struct {
+		name   string
+		status ConfirmationStatus
+		want   bool
+	}{
+		{"編集中なら追加する", ConfirmationEditing, true},
+		{"登録処理中なら追加しない", ConfirmationSubmitting, false},
+		{"登録済みなら追加しない", ConfirmationSubmitted, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageID := "M-" + tt.name
+			mu.Lock()
+			if confirmationData == nil {
+				confirmationData = make(map[string]*ConfirmationData)
+			}
+			confirmationData[messageID] = &ConfirmationData{MessageID: messageID, Household: h, Status: tt.status}
+			mu.Unlock()
+			t.Cleanup(func() {
+				mu.Lock()
+				delete(confirmationData, messageID)
+				mu.Unlock()
+			})
+
+			before, err := h.loadExpenseQueue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			requests := transport.count()
+			expense := Expense{ID: generateUniqueID(), Date: "2025-08-20", Price: 500}
+			if got := h.submitConfirmation(s, i, messageID, expense); got != tt.want {
+				t.Fatalf("submitConfirmation() = %t, want %t", got, tt.want)
+			}
+
+			after, err := h.loadExpenseQueue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantAdded := 0
+			if tt.want {
+				wantAdded = 1
+			}
+			if added := len(after) - len(before); added != wantAdded {
+				t.Errorf("キューに%d件追加されました, want %d件", added, wantAdded)
+			}
+			if status, _ := confirmationStatus(messageID); tt.want && status != ConfirmationSubmitted {
+				t.Errorf("追加後の状態 = %v, want 登録済み", status)
+			}
+			// 追加しなかった場合は理由を応答する
+			if !tt.want && transport.count() != requests+1 {
+				t.Errorf("Discordへの応答 = %d件, want 1件", transport.count()-requests)
+			}
+		})
+	}
+}
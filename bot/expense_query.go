@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/generative-ai-go/genai"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// 支出についての質問（/ask）
+// =================================================================================
+
+// AIは質問を検索条件に変換するだけで、集計はキューのデータからBotが行う（AIに計算させると数字を誤るため）
+
+const maxAskListItems = 15       // 一覧で表示する最大件数
+const maxAskMessageLength = 1900 // 応答メッセージの最大文字数（Discordの上限2000文字に余裕を持たせる）
+
+// 集計方法
+const (
+	askAggregationSum     = "sum"
+	askAggregationCount   = "count"
+	askAggregationAverage = "average"
+	askAggregationMax     = "max"
+	askAggregationMin     = "min"
+	askAggregationList    = "list"
+)
+
+// 内訳の単位
+const (
+	askGroupByNone     = "none"
+	askGroupByCategory = "category"
+	askGroupByGroup    = "group"
+	askGroupByUser     = "user"
+	askGroupByMonth    = "month"
+)
+
+// ExpenseQuery はAIが質問から組み立てた検索条件（名前のまま、IDへの変換はBotが行う）
+type ExpenseQuery struct {
+	StartDate   string   `json:"start_date"`
+	EndDate     string   `json:"end_date"`
+	Categories  []string `json:"categories"`
+	Groups      []string `json:"groups"`
+	Users       []string `json:"users"`
+	Keyword     string   `json:"keyword"`
+	Aggregation string   `json:"aggregation"`
+	GroupBy     string   `json:"group_by"`
+	Unsupported string   `json:"unsupported"` // 支出の検索で答えられない質問の場合の理由
+}
+
+// resolvedExpenseQuery はマスターデータの名前をIDに変換した検索条件
+type resolvedExpenseQuery struct {
+	ExpenseQuery
+	categoryIDs []int
+	groupIDs    []int
+	userIDs     []int
+}
+
+// expenseQueryBucket は内訳1行分の集計
+type expenseQueryBucket struct {
+	Label string
+	Total int
+	Count int
+}
+
+// parseExpenseQuery はAIの応答から検索条件を読み取り、値を検証する
+func parseExpenseQuery(text string) (ExpenseQuery, error) {
+	var query ExpenseQuery
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return query, NewBotError(ErrorTypeAIService, "AIの応答から検索条件を読み取れませんでした", nil).
+			WithContext("response", text)
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &query); err != nil {
+		return query, NewBotError(ErrorTypeAIService, "AIの応答から検索条件を読み取れませんでした", err).
+			WithContext("response", text)
+	}
+
+	query.Aggregation = strings.ToLower(strings.TrimSpace(query.Aggregation))
+	switch query.Aggregation {
+	case askAggregationSum, askAggregationCount, askAggregationAverage, askAggregationMax, askAggregationMin, askAggregationList:
+	case "":
+		query.Aggregation = askAggregationSum
+	default:
+		return query, NewBotError(ErrorTypeAIService, fmt.Sprintf("未対応の集計方法です: %s", query.Aggregation), nil)
+	}
+	query.GroupBy = strings.ToLower(strings.TrimSpace(query.GroupBy))
+	switch query.GroupBy {
+	case askGroupByCategory, askGroupByGroup, askGroupByUser, askGroupByMonth:
+	case "", askGroupByNone:
+		query.GroupBy = askGroupByNone
+	default:
+		return query, NewBotError(ErrorTypeAIService, fmt.Sprintf("未対応の内訳です: %s", query.GroupBy), nil)
+	}
+
+	for _, date := range []*string{&query.StartDate, &query.EndDate} {
+		*date = strings.TrimSpace(*date)
+		if *date == "" {
+			continue
+		}
+		if _, err := time.Parse(isoDateLayout, *date); err != nil {
+			return query, NewBotError(ErrorTypeAIService, fmt.Sprintf("期間を解釈できませんでした: %s", *date), err)
+		}
+	}
+	if query.StartDate != "" && query.EndDate != "" && query.StartDate > query.EndDate {
+		query.StartDate, query.EndDate = query.EndDate, query.StartDate
+	}
+	query.Keyword = strings.TrimSpace(query.Keyword)
+	query.Unsupported = strings.TrimSpace(query.Unsupported)
+	return query, nil
+}
+
+// resolveExpenseQuery は検索条件の名前をマスターデータのIDに変換する（見つからない名前があればエラー）
+func (h *Household) resolveExpenseQuery(query ExpenseQuery) (resolvedExpenseQuery, error) {
+	resolved := resolvedExpenseQuery{ExpenseQuery: query}
+	for _, name := range query.Categories {
+		// findCategoryByKeywordは見つからない場合に既定のカテゴリーを返すため、名前で厳密に探す
+		categoryID, found := h.findCategoryIDByName(name)
+		if !found {
+			return resolved, NewBotError(ErrorTypeValidation, fmt.Sprintf("カテゴリー「%s」が見つかりません", name), nil)
+		}
+		resolved.categoryIDs = append(resolved.categoryIDs, categoryID)
+	}
+	for _, name := range query.Groups {
+		groupID := h.findGroupByKeyword(name)
+		if groupID == nil {
+			return resolved, NewBotError(ErrorTypeValidation, fmt.Sprintf("グループ「%s」が見つかりません", name), nil)
+		}
+		resolved.groupIDs = append(resolved.groupIDs, *groupID)
+	}
+	for _, name := range query.Users {
+		userID, found := h.findUserByName(name)
+		if !found {
+			return resolved, NewBotError(ErrorTypeValidation, fmt.Sprintf("ユーザー「%s」が見つかりません", name), nil)
+		}
+		resolved.userIDs = append(resolved.userIDs, userID)
+	}
+	return resolved, nil
+}
+
+// matches は支出が検索条件に当てはまるかを返す
+func (q resolvedExpenseQuery) matches(expense Expense) bool {
+	if q.StartDate != "" && expense.Date < q.StartDate {
+		return false
+	}
+	if q.EndDate != "" && expense.Date > q.EndDate {
+		return false
+	}
+	if len(q.categoryIDs) > 0 && !slices.Contains(q.categoryIDs, expense.CategoryID) {
+		return false
+	}
+	if len(q.groupIDs) > 0 && (expense.GroupID == nil || !slices.Contains(q.groupIDs, *expense.GroupID)) {
+		return false
+	}
+	if len(q.userIDs) > 0 && !slices.Contains(q.userIDs, expense.UserID) {
+		return false
+	}
+	if q.Keyword != "" && !strings.Contains(normalizeRuleText(expense.Detail), normalizeRuleText(q.Keyword)) {
+		return false
+	}
+	return true
+}
+
+// userNameByID はユーザーIDから名前を返す（ID=0は「自分」）
+func (h *Household) userNameByID(userID int) string {
+	for _, user := range h.masterUsers {
+		if user.ID == userID {
+			return user.Name
+		}
+	}
+	if userID == 0 {
+		return "自分"
+	}
+	return "不明"
+}
+
+// bucketLabel は内訳の単位に応じた支出の見出しを返す
+func (h *Household) bucketLabel(groupBy string, expense Expense) string {
+	switch groupBy {
+	case askGroupByCategory:
+		return h.categoryNameByID(expense.CategoryID)
+	case askGroupByGroup:
+		return h.groupNameByID(expense.GroupID)
+	case askGroupByUser:
+		return h.userNameByID(expense.UserID)
+	case askGroupByMonth:
+		if len(expense.Date) >= 7 {
+			return expense.Date[:7]
+		}
+		return expense.Date
+	default:
+		return ""
+	}
+}
+
+// describeExpenseQuery は検索条件をユーザーが確認できる文章にする
+func (h *Household) describeExpenseQuery(query resolvedExpenseQuery) string {
+	var conditions []string
+	switch {
+	case query.StartDate != "" && query.EndDate != "":
+		conditions = append(conditions, fmt.Sprintf("期間: %s〜%s", query.StartDate, query.EndDate))
+	case query.StartDate != "":
+		conditions = append(conditions, fmt.Sprintf("期間: %s以降", query.StartDate))
+	case query.EndDate != "":
+		conditions = append(conditions, fmt.Sprintf("期間: %sまで", query.EndDate))
+	default:
+		conditions = append(conditions, "期間: すべて")
+	}
+	var names []string
+	for _, categoryID := range query.categoryIDs {
+		names = append(names, h.categoryNameByID(categoryID))
+	}
+	if len(names) > 0 {
+		conditions = append(conditions, "カテゴリー: "+strings.Join(names, "・"))
+	}
+	names = nil
+	for _, groupID := range query.groupIDs {
+		names = append(names, h.groupNameByID(&groupID))
+	}
+	if len(names) > 0 {
+		conditions = append(conditions, "グループ: "+strings.Join(names, "・"))
+	}
+	names = nil
+	for _, userID := range query.userIDs {
+		names = append(names, h.userNameByID(userID))
+	}
+	if len(names) > 0 {
+		conditions = append(conditions, "ユーザー: "+strings.Join(names, "・"))
+	}
+	if query.Keyword != "" {
+		conditions = append(conditions, fmt.Sprintf("詳細に「%s」を含む", query.Keyword))
+	}
+	return strings.Join(conditions, " / ")
+}
+
+// answerExpenseQuery はキューの支出を検索・集計し、応答メッセージを組み立てる
+func (h *Household) answerExpenseQuery(query resolvedExpenseQuery, expenses []Expense) string {
+	var matched []Expense
+	total := 0
+	for _, expense := range expenses {
+		if query.matches(expense) {
+			matched = append(matched, expense)
+			total += expense.Price
+		}
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "🔎 %s\n", h.describeExpenseQuery(query))
+	if len(matched) == 0 {
+		builder.WriteString("該当する支出はありませんでした。")
+		return builder.String()
+	}
+
+	switch query.Aggregation {
+	case askAggregationCount:
+		fmt.Fprintf(&builder, "**%d件**（合計 ¥%d）\n", len(matched), total)
+	case askAggregationAverage:
+		average := (total + len(matched)/2) / len(matched)
+		fmt.Fprintf(&builder, "平均 **¥%d**（%d件、合計 ¥%d）\n", average, len(matched), total)
+	case askAggregationMax, askAggregationMin:
+		// 同じ金額なら新しい日付を優先する
+		sort.SliceStable(matched, func(a, b int) bool { return matched[a].Date > matched[b].Date })
+		target := matched[0]
+		for _, expense := range matched[1:] {
+			if (query.Aggregation == askAggregationMax && expense.Price > target.Price) ||
+				(query.Aggregation == askAggregationMin && expense.Price < target.Price) {
+				target = expense
+			}
+		}
+		label := "最高"
+		if query.Aggregation == askAggregationMin {
+			label = "最低"
+		}
+		fmt.Fprintf(&builder, "%s **¥%d**: %s %s（%s）\n", label, target.Price, target.Date, target.Detail, h.categoryNameByID(target.CategoryID))
+	case askAggregationList:
+		fmt.Fprintf(&builder, "**%d件** 合計 **¥%d**\n", len(matched), total)
+		sort.SliceStable(matched, func(a, b int) bool { return matched[a].Date > matched[b].Date })
+		for idx, expense := range matched {
+			if idx >= maxAskListItems {
+				fmt.Fprintf(&builder, "…ほか%d件\n", len(matched)-maxAskListItems)
+				break
+			}
+			fmt.Fprintf(&builder, "・%s ¥%d %s（%s）\n", expense.Date, expense.Price, expense.Detail, h.categoryNameByID(expense.CategoryID))
+		}
+	default:
+		fmt.Fprintf(&builder, "合計 **¥%d**（%d件）\n", total, len(matched))
+	}
+
+	if query.GroupBy != askGroupByNone {
+		buckets := make(map[string]*expenseQueryBucket)
+		for _, expense := range matched {
+			label := h.bucketLabel(query.GroupBy, expense)
+			if buckets[label] == nil {
+				buckets[label] = &expenseQueryBucket{Label: label}
+			}
+			buckets[label].Total += expense.Price
+			buckets[label].Count++
+		}
+		var sorted []*expenseQueryBucket
+		for _, bucket := range buckets {
+			sorted = append(sorted, bucket)
+		}
+		sort.Slice(sorted, func(a, b int) bool {
+			if query.GroupBy == askGroupByMonth {
+				return sorted[a].Label < sorted[b].Label
+			}
+			if sorted[a].Total != sorted[b].Total {
+				return sorted[a].Total > sorted[b].Total
+			}
+			return sorted[a].Label < sorted[b].Label
+		})
+		builder.WriteString("\n**内訳**\n")
+		for _, bucket := range sorted {
+			fmt.Fprintf(&builder, "・%s: ¥%d（%d件）\n", bucket.Label, bucket.Total, bucket.Count)
+		}
+	}
+	return strings.TrimRight(builder.String(), "\n")
+}
+
+// masterNames はマスターデータの名前の一覧を返す（プロンプトに渡す）
+func (h *Household) masterNames() (categories, groups, users []string) {
+	for _, category := range h.masterCategories {
+		categories = append(categories, category.Name)
+	}
+	for _, group := range h.masterGroups {
+		groups = append(groups, group.Name)
+	}
+	users = append(users, "自分")
+	for _, user := range h.masterUsers {
+		if user.Name != "自分" {
+			users = append(users, user.Name)
+		}
+	}
+	return categories, groups, users
+}
+
+// translateQuestion はAIに質問を検索条件へ変換させる
+func (h *Household) translateQuestion(question string) (ExpenseQuery, error) {
+	now := nowInTokyo()
+	categories, groups, users := h.masterNames()
+	prompt, promptVersion, err := renderPrompt(receipt.AskPrompt, receipt.AskPromptData{
+		Question:   question,
+		Today:      now.Format(isoDateLayout),
+		Weekday:    weekdayLabel(now),
+		Categories: categories,
+		Groups:     groups,
+		Users:      users,
+	})
+	if err != nil {
+		return ExpenseQuery{}, err
+	}
+
+	resp, _, err := geminiClient.GenerateContent(context.Background(), genai.Text(prompt))
+	if err != nil {
+		message := "質問の解釈に失敗しました"
+		if description := describeAIError(err); description != "" {
+			message = description
+		}
+		return ExpenseQuery{}, NewBotError(ErrorTypeAIService, message, err).
+			WithContext("question", question)
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return ExpenseQuery{}, NewBotError(ErrorTypeAIService, "AIから応答が返されませんでした", nil)
+	}
+	text, _ := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	log.Printf("質問の検索条件 (%s): %s", promptVersion, string(text))
+	return parseExpenseQuery(string(text))
+}
+
+// handleAsk は /ask コマンドの処理（AIの応答を待つため、先に応答を保留する）
+func handleAsk(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	question := strings.TrimSpace(i.ApplicationCommandData().Options[0].StringValue())
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		log.Printf("質問コマンド応答エラー: %v", err)
+		return
+	}
+
+	content := h.askAnswer(question)
+	if runes := []rune(content); len(runes) > maxAskMessageLength {
+		content = string(runes[:maxAskMessageLength]) + "\n…（省略されました）"
+	}
+	content = fmt.Sprintf("❓ %s\n%s", question, content)
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+		log.Printf("質問コマンド応答の更新エラー: %v", err)
+	}
+}
+
+// askAnswer は質問への応答メッセージを返す
+func (h *Household) askAnswer(question string) string {
+	query, err := h.translateQuestion(question)
+	if err != nil {
+		HandleError(err, nil)
+		return fmt.Sprintf("❌ %s", describeAnalysisError(err))
+	}
+	if query.Unsupported != "" {
+		return fmt.Sprintf("🤔 支出のデータからは答えられない質問です: %s", query.Unsupported)
+	}
+	resolved, err := h.resolveExpenseQuery(query)
+	if err != nil {
+		return fmt.Sprintf("❌ %s", describeAnalysisError(err))
+	}
+
+	h.expenseQueueMutex.Lock()
+	expenses, err := h.loadExpenseQueue()
+	h.expenseQueueMutex.Unlock()
+	if err != nil {
+		HandleError(err, nil)
+		return "❌ エラー: キューの読み込みに失敗しました。"
+	}
+	return h.answerExpenseQuery(resolved, expenses)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExpenseQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     ExpenseQuery
+	}{
+		{
+			"コードブロックと前後の文章を無視",
+			"検索条件です。\n```json\n{\"start_date\": \"2025-08-01\", \"end_date\": \"2025-08-31\", \"categories\": [\"食費\"], \"aggregation\": \"sum\", \"group_by\": \"none\"}\n```\n以上です。",
+			ExpenseQuery{StartDate: "2025-08-01", EndDate: "2025-08-31", Categories: []string{"食費"}, Aggregation: askAggregationSum, GroupBy: askGroupByNone},
+		},
+		{
+			"集計方法と内訳の省略は合計・内訳なし",
+			`{"keyword": " コンビニ "}`,
+			ExpenseQuery{Keyword: "コンビニ", Aggregation: askAggregationSum, GroupBy: askGroupByNone},
+		},
+		{
+			"大文字・空白の表記ゆれ",
+			`{"aggregation": " Average ", "group_by": "MONTH", "users": ["夫"]}`,
+			ExpenseQuery{Users: []string{"夫"}, Aggregation: askAggregationAverage, GroupBy: askGroupByMonth},
+		},
+		{
+			"期間が逆順なら入れ替える",
+			`{"start_date": "2025-08-31", "end_date": "2025-08-01", "aggregation": "list", "group_by": "category"}`,
+			ExpenseQuery{StartDate: "2025-08-01", EndDate: "2025-08-31", Aggregation: askAggregationList, GroupBy: askGroupByCategory},
+		},
+		{
+			"片方だけの期間",
+			`{"start_date": "2025-07-01", "aggregation": "max", "groups": ["家族"]}`,
+			ExpenseQuery{StartDate: "2025-07-01", Groups: []string{"家族"}, Aggregation: askAggregationMax, GroupBy: askGroupByNone},
+		},
+		{
+			"答えられない質問",
+			`{"aggregation": "count", "unsupported": " 将来の支出は予測できません "}`,
+			ExpenseQuery{Aggregation: askAggregationCount, GroupBy: askGroupByNone, Unsupported: "将来の支出は予測できません"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExpenseQuery(tt.response)
+			if err != nil {
+				t.Fatalf("parseExpenseQuery() returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseExpenseQuery() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExpenseQueryRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"JSONがない", "すみません、わかりません。"},
+		{"壊れたJSON", `{"aggregation": "sum",}`},
+		{"未対応の集計方法", `{"aggregation": "median"}`},
+		{"未対応の内訳", `{"group_by": "store"}`},
+		{"日付の形式が違う", `{"start_date": "2025/08/01"}`},
+		{"存在しない日付", `{"end_date": "2025-02-30"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if query, err := parseExpenseQuery(tt.response); err == nil {
+				t.Errorf("parseExpenseQuery(%q) = %+v, want error", tt.response, query)
+			}
+		})
+	}
+}
+
+func TestResolvedExpenseQueryMatches(t *testing.T) {
+	family := 10
+	expense := Expense{Date: "2025-08-15", Price: 1280, CategoryID: 1, UserID: 2, GroupID: &family, Detail: "セブンイレブン ランチ"}
+
+	tests := []struct {
+		name  string
+		query resolvedExpenseQuery
+		want  bool
+	}{
+		{"条件なし", resolvedExpenseQuery{}, true},
+		{"期間内（境界を含む）", resolvedExpenseQuery{ExpenseQuery: ExpenseQuery{StartDate: "2025-08-15", EndDate: "2025-08-15"}}, true},
+		{"期間より前", resolvedExpenseQuery{ExpenseQuery: ExpenseQuery{StartDate: "2025-08-16"}}, false},
+		{"期間より後", resolvedExpenseQuery{ExpenseQuery: ExpenseQuery{EndDate: "2025-08-14"}}, false},
+		{"カテゴリー", resolvedExpenseQuery{categoryIDs: []int{3, 1}}, true},
+		{"別のカテゴリー", resolvedExpenseQuery{categoryIDs: []int{3}}, false},
+		{"グループ", resolvedExpenseQuery{groupIDs: []int{family}}, true},
+		{"別のグループ", resolvedExpenseQuery{groupIDs: []int{11}}, false},
+		{"ユーザー", resolvedExpenseQuery{userIDs: []int{2}}, true},
+		{"別のユーザー", resolvedExpenseQuery{userIDs: []int{1}}, false},
+		{"キーワード", resolvedExpenseQuery{ExpenseQuery: ExpenseQuery{Keyword: "セブン"}}, true},
+		{"キーワードを含まない", resolvedExpenseQuery{ExpenseQuery: ExpenseQuery{Keyword: "ローソン"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.matches(expense); got != tt.want {
+				t.Errorf("matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	if (resolvedExpenseQuery{groupIDs: []int{family}}).matches(Expense{Date: "2025-08-15"}) {
+		t.Error("グループのない支出がグループの条件に一致しています")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// AI読み取り結果の項目ごとの確信度
+// =================================================================================
+
+const lowConfidenceThreshold = 70 // AIが返す確信度（0〜100）がこれ未満の項目は要確認にする
+const maxReceiptAgeDays = 90      // これより古い日付は読み間違いの可能性があるとして要確認にする
+
+// 要確認の判定対象となる項目
+const (
+	confidenceFieldDate    = receipt.FieldDate
+	confidenceFieldAmount  = receipt.FieldAmount
+	confidenceFieldPayment = receipt.FieldPayment
+)
+
+// confidenceFieldOrder は要確認の項目を表示・確認する順番
+var confidenceFieldOrder = []string{confidenceFieldDate, confidenceFieldAmount, confidenceFieldPayment}
+
+// confidenceFieldLabels は項目の表示名
+var confidenceFieldLabels = receipt.FieldLabels
+
+// lowConfidenceReason はAIが返した確信度が低い場合に理由を返す（確信度が返されていなければ空）
+func lowConfidenceReason(aiResult ReceiptAnalysis, field string) string {
+	value, ok := aiResult.Confidence[field]
+	if !ok || value >= lowConfidenceThreshold {
+		return ""
+	}
+	return fmt.Sprintf("AIの読み取りの確信度が低いです（%d%%）", value)
+}
+
+// assessUncertainFields はAIの解析結果から、確認画面で要確認にする項目と理由を返す
+// paymentFromAI はAIが読み取った支払い方法をそのまま使っているか（ルールや履歴で決めた場合はfalse）
+func assessUncertainFields(aiResult ReceiptAnalysis, paymentMethod string, paymentFromAI bool, now time.Time) map[string]string {
+	uncertain := make(map[string]string)
+
+	// 日付：読み取れない・解釈できない場合は今日の日付になっているため必ず確認してもらう
+	if aiResult.Date == nil {
+		uncertain[confidenceFieldDate] = "レシートから読み取れなかったため今日の日付にしています"
+	} else if normalized, err := normalizeDate(*aiResult.Date, now); err != nil {
+		uncertain[confidenceFieldDate] = fmt.Sprintf("「%s」を解釈できなかったため今日の日付にしています", *aiResult.Date)
+	} else if reason := lowConfidenceReason(aiResult, confidenceFieldDate); reason != "" {
+		uncertain[confidenceFieldDate] = reason
+	} else if parsed, err := time.ParseInLocation(isoDateLayout, normalized, tokyoLocation); err == nil {
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tokyoLocation)
+		if parsed.After(today) {
+			uncertain[confidenceFieldDate] = "未来の日付になっています"
+		} else if parsed.Before(today.AddDate(0, 0, -maxReceiptAgeDays)) {
+			uncertain[confidenceFieldDate] = fmt.Sprintf("%d日以上前の日付になっています", maxReceiptAgeDays)
+		}
+	}
+
+	// 金額：明細の合計と合計金額を突き合わせる
+	if reason := lowConfidenceReason(aiResult, confidenceFieldAmount); reason != "" {
+		uncertain[confidenceFieldAmount] = reason
+	}
+	if aiResult.TotalAmount != nil && aiResult.ItemsTotal != nil && *aiResult.ItemsTotal != *aiResult.TotalAmount {
+		uncertain[confidenceFieldAmount] = fmt.Sprintf("明細の合計（¥%d）と合計金額（¥%d）が一致しません", *aiResult.ItemsTotal, *aiResult.TotalAmount)
+	}
+
+	// 支払い方法
+	if paymentMethod == "" || paymentMethod == "不明" {
+		uncertain[confidenceFieldPayment] = "レシートから読み取れませんでした"
+	} else if paymentFromAI {
+		if reason := lowConfidenceReason(aiResult, confidenceFieldPayment); reason != "" {
+			uncertain[confidenceFieldPayment] = reason
+		}
+	}
+
+	if len(uncertain) > 0 {
+		log.Printf("要確認の項目: %v (confidence=%v)", uncertain, aiResult.Confidence)
+	}
+	return uncertain
+}
+
+// confidenceFieldName は要確認の項目の見出しに⚠️を付ける
+func confidenceFieldName(data *ConfirmationData, field, name string) string {
+	if _, flagged := data.UncertainFields[field]; flagged {
+		return name + " ⚠️"
+	}
+	return name
+}
+
+// uncertainFieldsField は要確認の項目と理由を確認画面に表示するフィールドを返す
+func uncertainFieldsField(data *ConfirmationData) *discordgo.MessageEmbedField {
+	if len(data.UncertainFields) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, field := range confidenceFieldOrder {
+		if reason, flagged := data.UncertainFields[field]; flagged {
+			lines = append(lines, fmt.Sprintf("・%s: %s", confidenceFieldLabels[field], reason))
+		}
+	}
+	lines = append(lines, "内容を確認して「〜は正しい」を押すか、編集してください。確認が終わるまでキューに追加できません。")
+
+	return &discordgo.MessageEmbedField{
+		Name:   "⚠️ 要確認の項目",
+		Value:  strings.Join(lines, "\n"),
+		Inline: false,
+	}
+}
+
+// uncertainFieldButtons は要確認の項目ごとの「〜は正しい」ボタンを返す（要確認の項目がなければnil）
+func uncertainFieldButtons(messageID string, data *ConfirmationData) []discordgo.MessageComponent {
+	var buttons []discordgo.MessageComponent
+	for _, field := range confidenceFieldOrder {
+		if _, flagged := data.UncertainFields[field]; !flagged {
+			continue
+		}
+		buttons = append(buttons, discordgo.Button{
+			CustomID: fmt.Sprintf("confirm_field:%s:%s", messageID, field),
+			Label:    fmt.Sprintf("☑️ %sは正しい", confidenceFieldLabels[field]),
+			Style:    discordgo.PrimaryButton,
+		})
+	}
+	return buttons
+}
+
+// resolveUncertainField は項目を確認済みにする（編集された場合も確認済みとして扱う）
+func resolveUncertainField(data *ConfirmationData, field string) {
+	delete(data.UncertainFields, field)
+}
+
+// handleConfirmField は要確認の項目の「〜は正しい」ボタンを処理する
+func handleConfirmField(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	params := strings.TrimPrefix(customID, "confirm_field:")
+	separator := strings.LastIndex(params, ":")
+	if separator < 0 {
+		log.Printf("不正なcustomID: %s", customID)
+		return
+	}
+	messageID, field := params[:separator], params[separator+1:]
+
+	if getConfirmationData(messageID) == nil {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "❌ エラー: データが見つかりません。",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	updateConfirmationData(messageID, func(data *ConfirmationData) {
+		resolveUncertainField(data, field)
+	})
+	log.Printf("要確認の項目を確認済みにしました: messageID=%s, field=%s", messageID, field)
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("✅ %sを確認済みにしました。", confidenceFieldLabels[field]),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+
+	// 確認画面を更新
+	updateConfirmationDisplay(s, messageID)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/generative-ai-go/genai"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// Gemini APIの障害対策
+// =================================================================================
+
+// aiNoticeSession はAIの縮退・復旧をチャンネルに通知するためのセッション
+var (
+	aiNoticeSession *discordgo.Session
+	aiNoticeMutex   sync.Mutex
+)
+
+// geminiResilienceConfig は環境変数から再試行・流量制限・フォールバックの設定を作る
+//   - GEMINI_MODELS: 使用するモデルをカンマ区切りで指定（先頭を通常使い、失敗時に順に切り替える）
+//   - GEMINI_RPM: 1分あたりの最大リクエスト数
+//   - GEMINI_MAX_ATTEMPTS: モデルごとの最大試行回数
+func geminiResilienceConfig() receipt.ResilienceConfig {
+	config := receipt.DefaultResilienceConfig()
+	if value := os.Getenv("GEMINI_MODELS"); value != "" {
+		var models []string
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				models = append(models, name)
+			}
+		}
+		if len(models) > 0 {
+			config.Models = models
+		}
+	}
+	if value := os.Getenv("GEMINI_RPM"); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			config.RequestsPerMinute = parsed
+		} else {
+			log.Printf("GEMINI_RPMが不正なため既定値を使用します: %s", value)
+		}
+	}
+	if value := os.Getenv("GEMINI_MAX_ATTEMPTS"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			config.MaxAttempts = parsed
+		} else {
+			log.Printf("GEMINI_MAX_ATTEMPTSが不正なため既定値を使用します: %s", value)
+		}
+	}
+	return config
+}
+
+// newGeminiModel は障害対策付きのモデルを作り、縮退・復旧時の通知を設定する
+func newGeminiModel(client *genai.Client) *receipt.ResilientModel {
+	model := receipt.NewResilientModel(client, geminiResilienceConfig())
+	model.OnDegraded = notifyAIDegraded
+	model.OnRecovered = notifyAIRecovered
+	log.Printf("-> Geminiモデル: %s", strings.Join(model.Models(), " → "))
+	return model
+}
+
+// setAINoticeSession は通知に使うセッションを設定する
+func setAINoticeSession(s *discordgo.Session) {
+	aiNoticeMutex.Lock()
+	aiNoticeSession = s
+	aiNoticeMutex.Unlock()
+}
+
+// postAINotice はAIの状態をすべての家計のチャンネルに投稿する（AIはすべての家計で共有しているため）
+func postAINotice(content string) {
+	aiNoticeMutex.Lock()
+	s := aiNoticeSession
+	aiNoticeMutex.Unlock()
+	if s == nil {
+		return
+	}
+	for _, h := range households {
+		if _, err := s.ChannelMessageSend(h.ChannelID, content); err != nil {
+			botErr := NewBotError(ErrorTypeDiscordAPI, "AIの状態の通知に失敗", err).
+				WithContext("household_id", h.ID).
+				WithContext("channel_id", h.ChannelID)
+			LogBotError(botErr)
+		}
+	}
+}
+
+// notifyAIDegraded はAIが縮退した状態になったことを1度だけ通知する（復旧するまで再通知しない）
+func notifyAIDegraded(reason string) {
+	log.Printf("AIが縮退した状態になりました: %s", reason)
+	postAINotice(fmt.Sprintf("⚠️ **AI解析が不安定です**\n%s\nレシートの解析に時間がかかるか、失敗する場合があります。解析できなかったレシートは保留し、AIが使えるようになってから自動で解析します。", reason))
+}
+
+// notifyAIRecovered はAIが通常の状態に戻ったことを通知する
+func notifyAIRecovered() {
+	postAINotice("✅ AI解析が通常の状態に戻りました。")
+}
+
+// describeAIError はAIの呼び出しのエラーをユーザー向けの説明にする
+func describeAIError(err error) string {
+	switch {
+	case errors.Is(err, receipt.ErrCircuitOpen):
+		return "AIの呼び出しが続けて失敗したため一時停止しています"
+	case receipt.IsRetryable(err):
+		return "AIが混み合っているか一時的に利用できません"
+	default:
+		return ""
+	}
+}
+
+// isAIUnavailable はAIが一時的に利用できないことによるエラーかを返す（時間をおけば解析できる可能性がある）
+func isAIUnavailable(err error) bool {
+	return err != nil && (errors.Is(err, receipt.ErrCircuitOpen) || receipt.IsRetryable(err))
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 家計（サーバー・チャンネルごとの設定とデータ）
+// =================================================================================
+
+const defaultHouseholdsFile = "households.json" // HOUSEHOLDS_FILEで変更可
+const defaultHouseholdID = "default"            // 設定ファイルがない場合に環境変数から作る家計のID
+
+// households は設定ファイルから読み込んだ家計の一覧（起動時に読み込み、以後は変更しない）
+var households []*Household
+
+// HouseholdConfig は家計1件分の設定
+type HouseholdConfig struct {
+	ID             string         `json:"id"`
+	Name           string         `json:"name"`
+	GuildID        string         `json:"guild_id"`         // コマンドを登録するサーバー（空の場合はすべてのサーバーで使えるグローバルコマンド）
+	ChannelID      string         `json:"channel_id"`       // レシートを投稿し、確認画面を表示するチャンネル
+	MasterDataPath string         `json:"master_data_path"` // マスターデータのSQLダンプ
+	QueueDir       string         `json:"queue_dir"`        // Expenseキューを置くディレクトリ
+	DataDir        string         `json:"data_dir"`         // ルール・学習データ・詳細説明サンプルなどを置くディレクトリ
+	Budgets        map[string]int `json:"budgets"`          // カテゴリー名 -> 月の予算
+
+	// 「支出として登録」を使えるチャンネル（空の場合はサーバーのすべてのチャンネル）
+	AllowedChannelIDs []string `json:"allowed_channel_ids"`
+
+	// 閲覧者・記録者・管理者の割り当て（省略した場合は全員が管理者）
+	Permissions *PermissionConfig `json:"permissions"`
+
+	// キューへの操作を/undoで取り消せる期間（分、省略時は30分）
+	UndoWindowMinutes int `json:"undo_window_minutes"`
+}
+
+// Household は家計1件分の設定と、その家計だけが参照するデータ
+type Household struct {
+	HouseholdConfig
+
+	masterCategories   []Category
+	masterGroups       []Group
+	masterPaymentTypes []PaymentType
+	masterUsers        []User
+	masterSourceList   []SourceList
+	masterTypeKind     []TypeKind
+	masterTypeList     []TypeList
+	typeListMap        map[string]string
+	typeKindMap        map[int]string
+	detailSamples      map[string]string // カテゴリ名 -> 詳細説明サンプル
+	detailSampleMutex  sync.RWMutex      // detailSamplesの同期（/reload・/export_samplesと生成が並行するため）
+
+	masterDataQueues  map[string][]MasterQueueItem // マスターデータ種別ごとのキュー
+	masterQueueMutex  sync.RWMutex                 // マスターデータキューの同期
+	expenseQueueMutex sync.Mutex                   // Expenseキューファイルの読み書きを保護
+
+	ruleSet   RuleSet    // 登録済みのルール
+	ruleMutex sync.Mutex // ruleSetの同期
+
+	storeHistories    map[string]*StoreHistory // 正規化した店舗名 -> 履歴
+	storeHistoryMutex sync.Mutex               // storeHistoriesの同期
+
+	learnedDetails     map[string][]LearnedDetail // カテゴリー名 -> 確定済みの詳細説明
+	learnedDetailMutex sync.Mutex                 // learnedDetailsの同期
+
+	auditMutex sync.Mutex // 監査ログファイルの読み書きを保護
+
+	recurringSet   RecurringSet // 登録済みの定期支出
+	recurringMutex sync.Mutex   // recurringSetの同期
+}
+
+// dataPath は家計のデータディレクトリ内のファイルのパスを返す
+func (h *Household) dataPath(name string) string {
+	return filepath.Join(h.DataDir, name)
+}
+
+// expenseQueuePath は家計のExpenseキューファイルのパスを返す
+func (h *Household) expenseQueuePath() string {
+	return filepath.Join(h.QueueDir, filepath.Base(expenseQueueFile))
+}
+
+// displayName はログやメッセージに表示する家計の名前を返す
+func (h *Household) displayName() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return h.ID
+}
+
+// householdsFile は家計の設定ファイルのパスを環境変数から決定する
+func householdsFile() string {
+	if value := os.Getenv("HOUSEHOLDS_FILE"); value != "" {
+		return value
+	}
+	return defaultHouseholdsFile
+}
+
+// defaultHouseholdConfig は設定ファイルがない場合に、環境変数と従来のファイル配置から家計を1件作る
+func defaultHouseholdConfig() HouseholdConfig {
+	return HouseholdConfig{
+		ID:             defaultHouseholdID,
+		GuildID:        os.Getenv("GUILD_ID"),
+		ChannelID:      os.Getenv("CHANNEL_ID"),
+		MasterDataPath: masterDataDumpPath,
+		QueueDir:       filepath.Dir(expenseQueueFile),
+		DataDir:        ".",
+
+		AllowedChannelIDs: splitEnvList(os.Getenv("ALLOWED_CHANNEL_IDS")),
+		Permissions:       defaultPermissionConfig(),
+		UndoWindowMinutes: envInt("UNDO_WINDOW_MINUTES"),
+	}
+}
+
+// envInt は整数の環境変数を読み込む（未設定・不正な値は0）
+func envInt(name string) int {
+	value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// splitEnvList はカンマ区切りの環境変数を空でない要素のリストにする
+func splitEnvList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadHouseholdConfigs は家計の設定を読み込む（設定ファイルがなければ環境変数の1件のみ）
+func loadHouseholdConfigs() ([]HouseholdConfig, error) {
+	path := householdsFile()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			config := defaultHouseholdConfig()
+			if config.ChannelID == "" {
+				return nil, NewBotError(ErrorTypeConfiguration, "CHANNEL_ID環境変数が設定されていません", nil).
+					WithContext("households_file", path)
+			}
+			return []HouseholdConfig{config}, nil
+		}
+		return nil, NewBotError(ErrorTypeFileIO, "家計の設定ファイルの読み込みに失敗", err).
+			WithContext("file_path", path)
+	}
+
+	var configs []HouseholdConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, NewBotError(ErrorTypeConfiguration, "家計の設定ファイルのJSONパースエラー", err).
+			WithContext("file_path", path)
+	}
+	if len(configs) == 0 {
+		return nil, NewBotError(ErrorTypeConfiguration, "家計が1件も設定されていません", nil).
+			WithContext("file_path", path)
+	}
+
+	seenIDs := make(map[string]bool)
+	seenChannels := make(map[string]bool)
+	for idx := range configs {
+		config := &configs[idx]
+		if config.ID == "" || config.ChannelID == "" {
+			return nil, NewBotError(ErrorTypeConfiguration, "家計のidとchannel_idは必須です", nil).
+				WithContext("file_path", path).
+				WithContext("index", idx)
+		}
+		if seenIDs[config.ID] || seenChannels[config.ChannelID] {
+			return nil, NewBotError(ErrorTypeConfiguration, "家計のidまたはchannel_idが重複しています", nil).
+				WithContext("file_path", path).
+				WithContext("household_id", config.ID)
+		}
+		seenIDs[config.ID] = true
+		seenChannels[config.ChannelID] = true
+
+		// 省略されたディレクトリは家計ごとに分ける（他の家計のデータと混ざらないように）
+		if config.DataDir == "" {
+			config.DataDir = filepath.Join("households", config.ID)
+		}
+		if config.QueueDir == "" {
+			config.QueueDir = filepath.Join(config.DataDir, "queues")
+		}
+		if config.MasterDataPath == "" {
+			return nil, NewBotError(ErrorTypeConfiguration, "家計のmaster_data_pathは必須です", nil).
+				WithContext("file_path", path).
+				WithContext("household_id", config.ID)
+		}
+		if config.Permissions != nil && config.Permissions.DefaultRole != "" {
+			if _, err := parseRole(config.Permissions.DefaultRole); err != nil {
+				return nil, NewBotError(ErrorTypeConfiguration, "家計のdefault_roleが不正です", err).
+					WithContext("file_path", path).
+					WithContext("household_id", config.ID)
+			}
+		}
+	}
+	return configs, nil
+}
+
+// loadHouseholds は家計ごとのマスターデータ・学習データ・ルールなどを読み込む
+// マスターデータと詳細説明サンプルが読み込めない場合は起動できないためエラーを返す
+func loadHouseholds() error {
+	configs, err := loadHouseholdConfigs()
+	if err != nil {
+		return err
+	}
+
+	households = nil
+	for _, config := range configs {
+		h := &Household{HouseholdConfig: config}
+		log.Printf("家計「%s」を読み込んでいます (channel=%s, guild=%s)", h.displayName(), h.ChannelID, h.GuildID)
+		for _, dir := range []string{h.DataDir, h.QueueDir} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return NewBotError(ErrorTypeFileIO, "家計のディレクトリの作成に失敗", err).
+					WithContext("household_id", h.ID).
+					WithContext("dir", dir)
+			}
+		}
+
+		if err := h.loadMasterData(h.MasterDataPath); err != nil {
+			return err
+		}
+		h.recordMasterSync("")
+		if err := h.loadDetailSamples(h.dataPath(detailSamplesDir)); err != nil {
+			return err
+		}
+
+		h.masterDataQueues = make(map[string][]MasterQueueItem)
+		if err := h.loadMasterQueueFromFile(); err != nil {
+			botErr := NewBotError(ErrorTypeFileIO, "マスターキューファイル読み込みエラー", err).
+				WithContext("household_id", h.ID).
+				WithContext("file_path", h.dataPath(masterQueueFile))
+			LogBotError(botErr)
+		}
+		h.masterDataQueues = make(map[string][]MasterQueueItem)
+
+		// 店舗ごとの分類の学習データ・自動分類ルール・確定済みの詳細説明
+		if err := h.loadStoreHistories(); err != nil {
+			HandleError(err, nil)
+		}
+		if err := h.loadRules(); err != nil {
+			HandleError(err, nil)
+		}
+		if err := h.loadLearnedDetails(); err != nil {
+			HandleError(err, nil)
+		}
+		if err := h.loadRecurring(); err != nil {
+			HandleError(err, nil)
+		}
+		if h.Permissions == nil {
+			log.Printf("家計「%s」は権限が設定されていないため、全員が管理者として操作できます", h.displayName())
+		}
+		households = append(households, h)
+	}
+	log.Printf("-> %d件の家計を読み込みました。", len(households))
+	return nil
+}
+
+// householdByID はIDから家計を探す
+func householdByID(id string) *Household {
+	for _, h := range households {
+		if h.ID == id {
+			return h
+		}
+	}
+	return nil
+}
+
+// householdByChannel はレシートを投稿するチャンネルから家計を探す
+func householdByChannel(channelID string) *Household {
+	for _, h := range households {
+		if h.ChannelID == channelID {
+			return h
+		}
+	}
+	return nil
+}
+
+// householdByGuild はサーバーから家計を探す（サーバーを指定していない家計はどのサーバーからも使える）
+func householdByGuild(guildID string) *Household {
+	var fallback *Household
+	for _, h := range households {
+		if h.GuildID != "" && h.GuildID == guildID {
+			return h
+		}
+		if h.GuildID == "" && fallback == nil {
+			fallback = h
+		}
+	}
+	return fallback
+}
+
+// householdForInteraction はインタラクションが行われたチャンネル・サーバーの家計を返す（該当がなければnil）
+func householdForInteraction(i *discordgo.InteractionCreate) *Household {
+	if h := householdByChannel(i.ChannelID); h != nil {
+		return h
+	}
+	if i.GuildID == "" {
+		return nil
+	}
+	return householdByGuild(i.GuildID)
+}
+
+// respondUnknownHousehold は家計が登録されていない場所からのインタラクションに応答する
+func respondUnknownHousehold(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "❌ このサーバー・チャンネルは家計として登録されていません。",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// registerHouseholdCommands は家計のサーバーごとにコマンドを登録する
+// サーバーを指定していない家計がある場合のみグローバルコマンドとして登録し、それ以外は古いグローバルコマンドを削除する
+func registerHouseholdCommands(s *discordgo.Session) {
+	guildIDs := []string{}
+	global := false
+	for _, h := range households {
+		switch {
+		case h.GuildID == "":
+			global = true
+		case !slices.Contains(guildIDs, h.GuildID):
+			guildIDs = append(guildIDs, h.GuildID)
+		}
+	}
+
+	globalCommands := []*discordgo.ApplicationCommand{}
+	if global {
+		globalCommands = commands
+	}
+	registered, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", globalCommands)
+	if err != nil {
+		botErr := NewBotError(ErrorTypeDiscordAPI, "スラッシュコマンドの登録に失敗", err).
+			WithContext("commands_count", len(globalCommands))
+		LogBotError(botErr)
+	} else if global {
+		log.Printf("%d個のコマンドをグローバルに登録しました。", len(registered))
+	}
+
+	for _, guildID := range guildIDs {
+		registered, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, guildID, commands)
+		if err != nil {
+			botErr := NewBotError(ErrorTypeDiscordAPI, "スラッシュコマンドの登録に失敗", err).
+				WithContext("guild_id", guildID).
+				WithContext("commands_count", len(commands))
+			LogBotError(botErr)
+			continue
+		}
+		log.Printf("%d個のコマンドをサーバー %s に登録しました。", len(registered), guildID)
+	}
+}
+
+// budgetStatus は支出を追加した月のカテゴリーの予算の消化状況を返す（予算が設定されていなければ空）
+func (h *Household) budgetStatus(categoryID int, date string) string {
+	categoryName := h.categoryNameByID(categoryID)
+	budget, ok := h.Budgets[categoryName]
+	if !ok || budget <= 0 || len(date) < len("2006-01") {
+		return ""
+	}
+	month := date[:len("2006-01")]
+
+	h.expenseQueueMutex.Lock()
+	expenses, err := h.loadExpenseQueue()
+	h.expenseQueueMutex.Unlock()
+	if err != nil {
+		HandleError(err, nil)
+		return ""
+	}
+	total := 0
+	for _, expense := range expenses {
+		if expense.CategoryID == categoryID && strings.HasPrefix(expense.Date, month) {
+			total += expense.Price
+		}
+	}
+
+	status := fmt.Sprintf("📊 %sの%s: ¥%d / 予算 ¥%d（%d%%）", month, categoryName, total, budget, total*100/budget)
+	if total > budget {
+		status += fmt.Sprintf(" ⚠️ ¥%d超過しています", total-budget)
+	}
+	return status
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// 画像形式の判定・変換
+// =================================================================================
+
+// PreparedImage はAIに送信できる形式に変換済みの画像
+type PreparedImage = receipt.PreparedImage
+
+// isReceiptAttachment は添付ファイルがレシートとして処理できる形式かを判定する
+func isReceiptAttachment(attachment *discordgo.MessageAttachment) bool {
+	if strings.HasPrefix(attachment.ContentType, "image/") || attachment.ContentType == "application/pdf" {
+		return true
+	}
+	// HEICはContentTypeが付かないことがあるため拡張子でも判定する
+	switch strings.ToLower(filepath.Ext(attachment.Filename)) {
+	case ".jpg", ".jpeg", ".png", ".webp", ".heic", ".heif", ".pdf":
+		return true
+	}
+	return false
+}
+
+// prepareReceiptImages は受信したファイルをAIに送れる画像に変換する（PDFは複数ページになりうる）
+func prepareReceiptImages(data []byte, declared string) ([]PreparedImage, error) {
+	images, err := receipt.PrepareImages(data, declared)
+	var imageErr *receipt.ImageError
+	if !errors.As(err, &imageErr) {
+		return images, err
+	}
+	errorType := ErrorTypeFileIO
+	switch imageErr.Kind {
+	case receipt.ImageUnsupported:
+		errorType = ErrorTypeValidation
+	case receipt.ImageToolMissing:
+		errorType = ErrorTypeConfiguration
+	}
+	botErr := NewBotError(errorType, imageErr.Message, imageErr.Err)
+	if imageErr.Detail != "" {
+		botErr = botErr.WithContext("detail", imageErr.Detail)
+	}
+	return nil, botErr
+}
@@ -5,25 +5,25 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
-	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
-	"unicode"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/google/generative-ai-go/genai"
 	"github.com/joho/godotenv"
 	"google.golang.org/api/option"
+
+	"yarikuri/receipt"
 )
 
 // =================================================================================
@@ -75,6 +75,11 @@ func (e *BotError) Error() string {
 	return fmt.Sprintf("[%s] %s", e.Type, e.Message)
 }
 
+// Unwrap 原因のエラーを返す
+func (e *BotError) Unwrap() error {
+	return e.Cause
+}
+
 // LogBotError 統一ログ出力関数
 func LogBotError(err *BotError) {
 	contextStr := ""
@@ -110,32 +115,20 @@ func HandleError(err error, fallback func()) {
 // グローバル変数定義
 // =================================================================================
 var (
-	targetChannelID string
-	geminiClient    *genai.GenerativeModel
-	typeListMap     map[string]string
-	typeKindMap     map[int]string
+	geminiClient    *receipt.ResilientModel
 	transactions      map[string]*TransactionState // 進行中のトランザクションを管理
 	confirmationData  map[string]*ConfirmationData // 確認画面のデータを管理
 	mu                sync.Mutex                   // transactionsマップの同時アクセスを保護
-	detailSamples     map[string]string            // カテゴリ名 -> 詳細説明サンプル
-
-	masterCategories   []Category
-	masterGroups       []Group
-	masterPaymentTypes []PaymentType
-	masterUsers        []User
-	masterSourceList   []SourceList
-	masterTypeKind     []TypeKind
-	masterTypeList     []TypeList
-	
-	// マスターデータキュー管理
-	masterDataQueues   map[string][]MasterQueueItem // マスターデータ種別ごとのキュー
-	masterQueueMutex   sync.RWMutex                 // マスターデータキューの同期
 )
 
 const itemsPerPage = 15
-const queueFilePath = "queue.json"
+const masterQueueFile = "master_queue.json" // 家計のデータディレクトリに置くマスターデータキュー
+const expenseQueueFile = "../queues/expense_queue.json" // 既定の家計のExpenseキュー
 const tempImageDir = "./bot/img"
-const detailSamplesDir = "./detail_samples" // 詳細説明サンプルのディレクトリ
+const detailSamplesDir = "./detail_samples" // 詳細説明サンプルのディレクトリ（家計のデータディレクトリからの相対パス）
+const masterDataDumpPath = "/home/ubuntu/Bot/discord/yarikuri/dump_local_db/master_data_dump.sql" // 既定の家計のマスターデータのSQLダンプ
+
+const analysisTimeout = 30 * time.Second        // ユーザー入力後にAI解析結果を待つ最大時間
 
 // =================================================================================
 // 構造体定義
@@ -149,34 +142,83 @@ type TypeKind struct { ID int; TypeName string }
 type TypeList struct { ID string; TypeName string }
 
 type Expense struct {
-	Date       string `json:"date"`
-	Price      int    `json:"price"`
-	CategoryID int    `json:"category_id"`
-	UserID     int    `json:"user_id"`
-	Detail     string `json:"detail"`
-	GroupID    *int   `json:"group_id,omitempty"`
-	PaymentID  *int   `json:"payment_id,omitempty"`
+	ID            string `json:"id,omitempty"`
+	Date          string `json:"date"`
+	Price         int    `json:"price"`
+	CategoryID    int    `json:"category_id"`
+	UserID        int    `json:"user_id"`
+	Detail        string `json:"detail"`
+	GroupID       *int   `json:"group_id,omitempty"`
+	PaymentID     *int   `json:"payment_id,omitempty"`
+	ReceiptKey    string `json:"receipt_key,omitempty"`    // レシートアーカイブの画像キー
+	PromptVersion string `json:"prompt_version,omitempty"` // 解析・詳細説明の生成に使ったプロンプトのバージョン（カンマ区切り）
 }
 
-type ReceiptAnalysis struct {
-	IsReceipt     bool    `json:"is_receipt"`
-	StoreName     *string `json:"store_name"`
-	Date          *string `json:"date"`
-	TotalAmount   *int    `json:"total_amount"`
-	PaymentMethod *string `json:"payment_method"`
-	Items         *string `json:"items"`
+// ReceiptAnalysis はレシートのAI解析結果（評価ツールと共通のreceiptパッケージで定義）
+type ReceiptAnalysis = receipt.Analysis
+
+// AnalysisStatus はAI解析の進行状態
+type AnalysisStatus string
+
+const (
+	AnalysisStatusCollecting AnalysisStatus = "collecting" // 長いレシートの続きの画像を受付中
+	AnalysisStatusRunning    AnalysisStatus = "running"
+	AnalysisStatusSucceeded  AnalysisStatus = "succeeded"
+	AnalysisStatusFailed     AnalysisStatus = "failed"
+	AnalysisStatusPending    AnalysisStatus = "pending" // AIが利用できないため保留中（自動で再試行する）
+)
+
+// AnalysisOutcome はAI解析1回分の結果（失敗時もResultには読み取れた部分が入る）
+type AnalysisOutcome struct {
+	Result ReceiptAnalysis
+	Err    error
 }
 
 type TransactionState struct {
 	InitialMessageID string
+	Household        *Household // レシートを投稿した家計
+	ChannelID        string
+	PromptMessageID  string             // 「詳細情報を入力」ボタンを表示しているメッセージのID
+	Message          *discordgo.Message // 再解析用に元の投稿を保持
+	Attachments      []*discordgo.MessageAttachment // 解析対象の添付ファイル（長いレシートは複数）
+	AttachmentLabel  string                         // 複数添付時の「[1/3枚目]」表示
+	Stitched         bool                           // 複数の画像を1枚のレシートとしてまとめて解析するか
 	Interaction      *discordgo.InteractionCreate
 	ImagePath        string
+	ReceiptKey       string // レシートアーカイブの画像キー
 	UserInput        map[string]string
-	AIResultChan     chan ReceiptAnalysis
+	AIResultChan     chan AnalysisOutcome
+	Status           AnalysisStatus
+	LastResult       ReceiptAnalysis // 直近の解析で読み取れた内容（失敗時は部分的）
+	LastError        error
+	Attempts         int  // 再解析ボタンが押された回数
+	NextAttemptAt    time.Time // 保留中の解析を次に自動で再試行する時刻
+	awaitingResult   bool // processReceiptWithUserInputが結果を待機中かどうか
+	stitchDeadline   time.Time // 長いレシートの続きの画像の受付を終了する（した）時刻
+}
+
+// analysisDeadlineLocked はユーザー入力後にAI解析結果を待つ期限を返す（muを保持して呼ぶこと）
+// 長いレシートは続きの画像の受付を終えてから解析するため、受付の終了時刻（画像が届くたびに延びる）から数える
+func (state *TransactionState) analysisDeadlineLocked(waitStart time.Time) time.Time {
+	deadline := waitStart.Add(analysisTimeout)
+	if stitchEnd := state.stitchDeadline.Add(analysisTimeout); stitchEnd.After(deadline) {
+		return stitchEnd
+	}
+	return deadline
+}
+
+// posterID はレシートを投稿したユーザーのIDを返す
+func (state *TransactionState) posterID() string {
+	if state.Message == nil || state.Message.Author == nil {
+		return ""
+	}
+	return state.Message.Author.ID
 }
 
 type ConfirmationData struct {
 	MessageID        string
+	Household        *Household // 支出を追加する家計
+	PosterID         string     // 投稿したユーザー（確認画面を操作できる本人）
 	Date             string
 	Amount           int
 	CategoryID       int
@@ -189,6 +231,14 @@ type ConfirmationData struct {
 	RemainingAmount  *int  // 残り金額（分割処理用）
 	IsPartialEntry   bool  // 分割エントリかどうか
 	ParentMessageID  *string // 親のメッセージID（分割の場合）
+	ReceiptKey       string  // レシートアーカイブの画像キー
+	Duplicates       []DuplicateMatch // キュー内の重複候補
+	ForceAdd         bool             // 重複警告を確認済みか（「それでも追加」が押された）
+	AppliedRules     []string         // 確認画面の作成時に適用された自動分類ルール（表示用）
+	DetailEdited     bool             // 詳細説明がユーザーに書き直されたか（サンプルとして優先して学習する）
+	UncertainFields  map[string]string // AIの読み取りが不確かな項目 -> 理由（確認済みになるまでキューに追加できない）
+	Status           ConfirmationStatus // 編集中・登録処理中・登録済み（二重送信の防止）
+	ExpenseID        string             // 登録したExpenseのID（登録済みの場合）
 }
 
 // マスターデータキューアイテム
@@ -206,7 +256,7 @@ type MasterQueueItem struct {
 // =================================================================================
 // データ読み込み・解析関連
 // =================================================================================
-func loadMasterData(filePath string) error {
+func (h *Household) loadMasterData(filePath string) error {
 	log.Println("マスターデータのダンプファイルを読み込んでいます...")
 	sqlBytes, err := os.ReadFile(filePath)
 	if err != nil {
@@ -216,74 +266,76 @@ func loadMasterData(filePath string) error {
 	}
 	sqlContent := string(sqlBytes)
 	
-	records, _ := parseTableData(sqlContent, "category_list")
-	for _, rec := range records {
-		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); masterCategories = append(masterCategories, Category{ID: id, Name: strings.TrimSpace(rec[1])})
+	// 再読み込みの場合に備えて読み込み済みのデータを破棄する
+	h.masterCategories, h.masterGroups, h.masterPaymentTypes, h.masterUsers = nil, nil, nil, nil
+	h.masterSourceList, h.masterTypeKind, h.masterTypeList = nil, nil, nil
+	
+	for _, category := range receipt.ParseMasterCategories(sqlContent) {
+		h.masterCategories = append(h.masterCategories, Category{ID: category.ID, Name: category.Name})
 	}
-	sort.Slice(masterCategories, func(i, j int) bool { return sortJapaneseFirst(masterCategories[i].Name, masterCategories[j].Name) })
-	log.Printf("-> %d件のカテゴリを読み込み、ソートしました。\n", len(masterCategories))
+	log.Printf("-> %d件のカテゴリを読み込み、ソートしました。\n", len(h.masterCategories))
 
-	records, _ = parseTableData(sqlContent, "group_list")
+	records, _ := receipt.ParseTableData(sqlContent, "group_list")
 	for _, rec := range records {
-		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); masterGroups = append(masterGroups, Group{ID: id, Name: strings.TrimSpace(rec[1])})
+		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); h.masterGroups = append(h.masterGroups, Group{ID: id, Name: strings.TrimSpace(rec[1])})
 	}
-	sort.Slice(masterGroups, func(i, j int) bool { return sortJapaneseFirst(masterGroups[i].Name, masterGroups[j].Name) })
-	log.Printf("-> %d件のグループを読み込み、ソートしました。\n", len(masterGroups))
+	sort.Slice(h.masterGroups, func(i, j int) bool { return receipt.SortJapaneseFirst(h.masterGroups[i].Name, h.masterGroups[j].Name) })
+	log.Printf("-> %d件のグループを読み込み、ソートしました。\n", len(h.masterGroups))
 
-	records, _ = parseTableData(sqlContent, "payment_type")
+	records, _ = receipt.ParseTableData(sqlContent, "payment_type")
 	for _, rec := range records {
-		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); masterPaymentTypes = append(masterPaymentTypes, PaymentType{PayID: id, PayKind: strings.TrimSpace(rec[1]), TypeID: strings.TrimSpace(rec[2])})
+		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); h.masterPaymentTypes = append(h.masterPaymentTypes, PaymentType{PayID: id, PayKind: strings.TrimSpace(rec[1]), TypeID: strings.TrimSpace(rec[2])})
 	}
-	sort.Slice(masterPaymentTypes, func(i, j int) bool { return sortJapaneseFirst(masterPaymentTypes[i].PayKind, masterPaymentTypes[j].PayKind) })
-	log.Printf("-> %d件の支払い方法を読み込み、ソートしました。\n", len(masterPaymentTypes))
+	sort.Slice(h.masterPaymentTypes, func(i, j int) bool { return receipt.SortJapaneseFirst(h.masterPaymentTypes[i].PayKind, h.masterPaymentTypes[j].PayKind) })
+	log.Printf("-> %d件の支払い方法を読み込み、ソートしました。\n", len(h.masterPaymentTypes))
 	
-	records, _ = parseTableData(sqlContent, "user_list")
+	records, _ = receipt.ParseTableData(sqlContent, "user_list")
 	for _, rec := range records {
-		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); masterUsers = append(masterUsers, User{ID: id, Name: strings.TrimSpace(rec[1])})
+		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); h.masterUsers = append(h.masterUsers, User{ID: id, Name: strings.TrimSpace(rec[1])})
 	}
-	sort.Slice(masterUsers, func(i, j int) bool { return sortJapaneseFirst(masterUsers[i].Name, masterUsers[j].Name) })
-	log.Printf("-> %d件のユーザーを読み込み、ソートしました。\n", len(masterUsers))
+	sort.Slice(h.masterUsers, func(i, j int) bool { return receipt.SortJapaneseFirst(h.masterUsers[i].Name, h.masterUsers[j].Name) })
+	log.Printf("-> %d件のユーザーを読み込み、ソートしました。\n", len(h.masterUsers))
 	
-	records, _ = parseTableData(sqlContent, "source_list")
+	records, _ = receipt.ParseTableData(sqlContent, "source_list")
 	for _, rec := range records {
-		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); typeId, _ := strconv.Atoi(strings.TrimSpace(rec[2])); masterSourceList = append(masterSourceList, SourceList{ID: id, SourceName: strings.TrimSpace(rec[1]), TypeID: typeId})
+		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); typeId, _ := strconv.Atoi(strings.TrimSpace(rec[2])); h.masterSourceList = append(h.masterSourceList, SourceList{ID: id, SourceName: strings.TrimSpace(rec[1]), TypeID: typeId})
 	}
-	sort.Slice(masterSourceList, func(i, j int) bool { return sortJapaneseFirst(masterSourceList[i].SourceName, masterSourceList[j].SourceName) })
-	log.Printf("-> %d件の収入源を読み込み、ソートしました。\n", len(masterSourceList))
+	sort.Slice(h.masterSourceList, func(i, j int) bool { return receipt.SortJapaneseFirst(h.masterSourceList[i].SourceName, h.masterSourceList[j].SourceName) })
+	log.Printf("-> %d件の収入源を読み込み、ソートしました。\n", len(h.masterSourceList))
 
-	records, _ = parseTableData(sqlContent, "type_kind")
+	records, _ = receipt.ParseTableData(sqlContent, "type_kind")
 	for _, rec := range records {
-		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); masterTypeKind = append(masterTypeKind, TypeKind{ID: id, TypeName: strings.TrimSpace(rec[1])})
+		id, _ := strconv.Atoi(strings.TrimSpace(rec[0])); h.masterTypeKind = append(h.masterTypeKind, TypeKind{ID: id, TypeName: strings.TrimSpace(rec[1])})
 	}
-	typeKindMap = make(map[int]string)
-	for _, item := range masterTypeKind { typeKindMap[item.ID] = item.TypeName }
-	log.Printf("-> %d件の収入種別を読み込み、マップを作成しました。\n", len(masterTypeKind))
+	h.typeKindMap = make(map[int]string)
+	for _, item := range h.masterTypeKind { h.typeKindMap[item.ID] = item.TypeName }
+	log.Printf("-> %d件の収入種別を読み込み、マップを作成しました。\n", len(h.masterTypeKind))
 
-	records, _ = parseTableData(sqlContent, "type_list")
+	records, _ = receipt.ParseTableData(sqlContent, "type_list")
 	for _, rec := range records {
-		masterTypeList = append(masterTypeList, TypeList{ID: strings.TrimSpace(rec[0]), TypeName: strings.TrimSpace(rec[1])})
+		h.masterTypeList = append(h.masterTypeList, TypeList{ID: strings.TrimSpace(rec[0]), TypeName: strings.TrimSpace(rec[1])})
 	}
-	typeListMap = make(map[string]string)
-	for _, item := range masterTypeList { typeListMap[item.ID] = item.TypeName }
-	log.Printf("-> %d件の支払い種別を読み込み、マップを作成しました。\n", len(masterTypeList))
+	h.typeListMap = make(map[string]string)
+	for _, item := range h.masterTypeList { h.typeListMap[item.ID] = item.TypeName }
+	log.Printf("-> %d件の支払い種別を読み込み、マップを作成しました。\n", len(h.masterTypeList))
 
 	log.Println("マスターデータの読み込みが完了しました。")
 	return nil
 }
 
 // loadDetailSamples はマスターカテゴリーに基づいて詳細説明サンプルを読み込む
-func loadDetailSamples(samplesDir string) error {
+func (h *Household) loadDetailSamples(samplesDir string) error {
 	log.Println("詳細説明サンプルを読み込んでいます...")
-	detailSamples = make(map[string]string)
+	samples := make(map[string]string)
 	
 	// masterCategoriesが読み込まれていることを確認
-	if len(masterCategories) == 0 {
+	if len(h.masterCategories) == 0 {
 		return NewBotError(ErrorTypeValidation, "マスターカテゴリーが読み込まれていません", nil).
 			WithContext("required_action", "loadMasterData()を先に実行してください")
 	}
 	
 	// 各カテゴリーに対応するtxtファイルを探す
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		filePath := filepath.Join(samplesDir, category.Name+".txt")
 		
 		// ファイルが存在するかチェック
@@ -302,38 +354,24 @@ func loadDetailSamples(samplesDir string) error {
 			continue
 		}
 		
-		detailSamples[category.Name] = string(content)
+		samples[category.Name] = string(content)
 		log.Printf("-> カテゴリー「%s」のサンプルを読み込みました", category.Name)
 	}
 	
-	log.Printf("詳細説明サンプルの読み込みが完了しました。合計%d件", len(detailSamples))
+	h.detailSampleMutex.Lock()
+	h.detailSamples = samples
+	h.detailSampleMutex.Unlock()
+	log.Printf("詳細説明サンプルの読み込みが完了しました。合計%d件", len(samples))
 	return nil
 }
 
-func parseTableData(sqlContent, tableName string) ([][]string, error) {
-	startMarker := "COPY public." + tableName
-	endMarker := "\\."
-	startIndex := strings.Index(sqlContent, startMarker)
-	if startIndex == -1 { return nil, nil }
-	dataStartIndex := strings.Index(sqlContent[startIndex:], ";")
-	if dataStartIndex == -1 { return nil, nil }
-	dataBlockStartIndex := startIndex + dataStartIndex + 1
-	endIndex := strings.Index(sqlContent[dataBlockStartIndex:], endMarker)
-	if endIndex == -1 { return nil, nil }
-	dataBlock := sqlContent[dataBlockStartIndex : dataBlockStartIndex+endIndex]
-	lines := strings.Split(strings.TrimSpace(dataBlock), "\n")
-	var records [][]string
-	for _, line := range lines {
-		if line != "" {
-			records = append(records, strings.Split(line, "\t"))
-		}
-	}
-	return records, nil
-}
-
 // =================================================================================
 // Discordコマンド定義
 // =================================================================================
+
+// adminPermission は管理者用コマンドの既定の実行権限
+var adminPermission int64 = discordgo.PermissionAdministrator
+
 var commands = []*discordgo.ApplicationCommand{
 	{ Name: "check_master", Description: "メモリに読み込まれているマスターデータの件数を確認します。", },
 	{
@@ -369,14 +407,129 @@ var commands = []*discordgo.ApplicationCommand{
 			{ Type: discordgo.ApplicationCommandOptionString, Name: "type_name", Description: "支払い方法の場合のみ：支払い種別（現金、クレジット等）", Required: false, },
 		},
 	},
+	{
+		Name: "receipt", Description: "キューに追加した支出の元のレシート画像を表示します。",
+		Options: []*discordgo.ApplicationCommandOption{
+			{ Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "支出ID（キュー追加時に表示されるID）", Required: true, },
+		},
+	},
+	{
+		Name: "rule", Description: "カテゴリー等を自動で設定するルールを管理します。",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type: discordgo.ApplicationCommandOptionSubCommand, Name: "add", Description: "ルールを追加します。",
+				Options: []*discordgo.ApplicationCommandOption{
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "condition", Description: "条件（例: detail contains Suica / store contains ドラッグ and amount > 3000）", Required: true, },
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "category", Description: "設定するカテゴリー名", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "group", Description: "設定するグループ名", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "payment", Description: "設定する支払い方法", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionInteger, Name: "priority", Description: "優先度（小さいほど先に評価、既定: 100）", Required: false, },
+				},
+			},
+			{ Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "ルールを優先度順に表示します。", },
+			{
+				Type: discordgo.ApplicationCommandOptionSubCommand, Name: "delete", Description: "ルールを削除します。",
+				Options: []*discordgo.ApplicationCommandOption{
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "ルールID（例: R1）", Required: true, },
+				},
+			},
+			{
+				Type: discordgo.ApplicationCommandOptionSubCommand, Name: "dryrun", Description: "既存のキューにルールを適用した場合の結果を表示します（データは変更しません）。",
+				Options: []*discordgo.ApplicationCommandOption{
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "試すルールID（省略時はすべて）", Required: false, },
+				},
+			},
+		},
+	},
+	{
+		Name: "export_samples", Description: "確定した詳細説明をdetail_samplesのサンプルファイルに書き出します。",
+		Options: []*discordgo.ApplicationCommandOption{
+			{ Type: discordgo.ApplicationCommandOptionString, Name: "category", Description: "書き出すカテゴリー名（省略時はすべて）", Required: false, },
+		},
+	},
+	{
+		Name: "ask", Description: "支出について質問します（例: 先月の外食いくら? / 東北旅行の合計）。",
+		Options: []*discordgo.ApplicationCommandOption{
+			{ Type: discordgo.ApplicationCommandOptionString, Name: "question", Description: "質問（期間・カテゴリー・グループ・ユーザーで絞り込んで集計します）", Required: true, },
+		},
+	},
+	{ Name: registerExpenseCommandName, Type: discordgo.MessageApplicationCommand, },
+	{
+		Name: "recurring", Description: "家賃・光熱費・サブスクリプションなどの定期支出を管理します。",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type: discordgo.ApplicationCommandOptionSubCommand, Name: "add", Description: "定期支出を追加します。",
+				Options: []*discordgo.ApplicationCommandOption{
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "名前（詳細として登録されます。例: 家賃）", Required: true, },
+					{ Type: discordgo.ApplicationCommandOptionInteger, Name: "amount", Description: "金額（毎回変わる場合は目安の金額）", Required: true, },
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "category", Description: "カテゴリー名", Required: true, },
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "payment", Description: "支払い方法", Required: true, },
+					{
+						Type: discordgo.ApplicationCommandOptionString, Name: "schedule", Description: "周期", Required: true,
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{ Name: "毎月N日", Value: recurringMonthly },
+							{ Name: "毎年M月N日", Value: recurringYearly },
+							{ Name: "N週間ごと", Value: recurringWeekly },
+							{ Name: "毎月最終営業日", Value: recurringLastBusinessDay },
+						},
+					},
+					{ Type: discordgo.ApplicationCommandOptionInteger, Name: "day", Description: "日（毎月・毎年、省略時は開始日の日）", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionInteger, Name: "month", Description: "月（毎年、省略時は開始日の月）", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionInteger, Name: "interval_weeks", Description: "間隔の週数（N週間ごと、既定: 1）", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "start", Description: "開始日（省略時は今日）", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionBoolean, Name: "variable", Description: "金額が毎回変わる（予定日に確認画面を表示します）", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "group", Description: "グループ名", Required: false, },
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "payer", Description: "支払者のユーザー名", Required: false, },
+				},
+			},
+			{ Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "定期支出と次回の予定日を表示します。", },
+			{
+				Type: discordgo.ApplicationCommandOptionSubCommand, Name: "delete", Description: "定期支出を削除します。",
+				Options: []*discordgo.ApplicationCommandOption{
+					{ Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "定期支出ID（例: S1）", Required: true, },
+				},
+			},
+		},
+	},
+	{ Name: "undo", Description: "自分が直近に行ったキューへの操作（追加など）を取り消します。", },
+	{
+		Name: "audit", Description: "支出・マスターデータの変更履歴を表示します。",
+		Options: []*discordgo.ApplicationCommandOption{
+			{ Type: discordgo.ApplicationCommandOptionString, Name: "entity", Description: "支出ID・確認画面のメッセージID・マスターデータのIDで絞り込み", Required: false, },
+			{ Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "操作したユーザーで絞り込み", Required: false, },
+			{ Type: discordgo.ApplicationCommandOptionInteger, Name: "limit", Description: "表示件数（既定: 20、最大: 50）", Required: false, },
+		},
+	},
+	{
+		Name: "reload", Description: "マスターデータ・詳細説明サンプル・プロンプトを再読み込みします（管理者用）。",
+		DefaultMemberPermissions: &adminPermission,
+	},
+	{
+		Name: "cache", Description: "レシート解析のAI応答キャッシュを管理します（管理者用）。",
+		DefaultMemberPermissions: &adminPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{ Type: discordgo.ApplicationCommandOptionSubCommand, Name: "clear", Description: "キャッシュをすべて削除します。", },
+		},
+	},
 }
 
 var commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-	"check_master": handleCheckMaster,
-	"show_master":  handleShowMaster,
-	"add":          handleAdd,
-	"fix":          handleFix,
-	"add_master":   handleAddMaster,
+	"check_master":   handleCheckMaster,
+	"show_master":    handleShowMaster,
+	"add":            handleAdd,
+	"fix":            handleFix,
+	"add_master":     handleAddMaster,
+	"receipt":        handleReceipt,
+	"rule":           handleRule,
+	"export_samples": handleExportSamples,
+	"cache":          handleCache,
+	"reload":         handleReload,
+	"ask":            handleAsk,
+	"audit":          handleAudit,
+	"undo":           handleUndo,
+	"recurring":      handleRecurring,
+
+	registerExpenseCommandName: handleRegisterExpense,
 }
 
 // =================================================================================
@@ -385,61 +538,272 @@ var commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.Interac
 
 // messageCreate は、画像投稿をトリガーに並行処理を開始する
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author.ID == s.State.User.ID || m.ChannelID != targetChannelID || len(m.Attachments) == 0 {
+	if m.Author.ID == s.State.User.ID || m.Author.Bot {
+		return
+	}
+	// 家計のチャンネルへの投稿のみを対象にする
+	h := householdByChannel(m.ChannelID)
+	if h == nil {
 		return
 	}
-	attachment := m.Attachments[0]
-	if !strings.HasPrefix(attachment.ContentType, "image/") {
+	// 添付のない投稿は「ランチ 980 PayPay」のような1行の支出メモとして扱う
+	if len(m.Attachments) == 0 {
+		handleTextEntry(s, h, m)
 		return
 	}
 
-	log.Printf("画像を受信: %s", m.ID)
+	attachments := receiptAttachments(m.Message)
+	if len(attachments) == 0 {
+		return
+	}
 
+	log.Printf("画像を受信: %s (%d件)", m.ID, len(attachments))
+
+	// 同じユーザーの長いレシートを受付中なら、続きの画像として追加する
+	if joinStitchGroup(s, m, attachments) {
+		return
+	}
+	// RECEIPT_STITCH_MODE=auto・always では、画像1枚だけの投稿は同じユーザーが続けて投稿する画像を待ってからまとめて解析する
+	if len(attachments) == 1 && stitchMode() != stitchModeOff {
+		startReceiptTransaction(s, h, m, m.ID, attachments, "", true)
+		return
+	}
+	startReceiptTransactions(s, h, m, attachments)
+}
+
+// receiptAttachments はメッセージの添付ファイルのうちレシートとして扱えるものをすべて返す
+func receiptAttachments(message *discordgo.Message) []*discordgo.MessageAttachment {
+	var attachments []*discordgo.MessageAttachment
+	for _, attachment := range message.Attachments {
+		if isReceiptAttachment(attachment) {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+// startReceiptTransactions は投稿の添付ファイルを1枚ずつ（まとめて解析する指定があれば1枚として）解析する
+func startReceiptTransactions(s *discordgo.Session, h *Household, m *discordgo.MessageCreate, attachments []*discordgo.MessageAttachment) {
+	// まとめて解析するよう指定された場合は、すべての添付を1枚のレシートとして扱う
+	if isStitchRequested(m) {
+		startReceiptTransaction(s, h, m, m.ID, attachments, "", true)
+		return
+	}
+
+	for idx, attachment := range attachments {
+		// 添付ファイルごとに別のレシートとして扱う（1件目はメッセージIDをそのままキーにする）
+		transactionID := m.ID
+		var label string
+		if len(attachments) > 1 {
+			label = fmt.Sprintf("[%d/%d枚目] ", idx+1, len(attachments))
+			if idx > 0 {
+				transactionID = fmt.Sprintf("%s-%d", m.ID, idx+1)
+			}
+		}
+		startReceiptTransaction(s, h, m, transactionID, []*discordgo.MessageAttachment{attachment}, label, false)
+	}
+}
+
+// startReceiptTransaction はレシート1枚分の解析と補足情報入力ボタンの表示を開始する
+func startReceiptTransaction(s *discordgo.Session, h *Household, m *discordgo.MessageCreate, transactionID string, attachments []*discordgo.MessageAttachment, label string, stitched bool) {
 	// 1. 状態を初期化
 	state := &TransactionState{
-		InitialMessageID: m.ID,
-		AIResultChan:     make(chan ReceiptAnalysis, 1),
-	}
+		InitialMessageID: transactionID,
+		Household:        h,
+		ChannelID:        m.ChannelID,
+		Message:          m.Message,
+		Attachments:      attachments,
+		AttachmentLabel:  label,
+		Stitched:         stitched,
+		AIResultChan:     make(chan AnalysisOutcome, 1),
+		Status:           AnalysisStatusRunning,
+	}
+	collecting := stitched && stitchWindow() > 0
 	mu.Lock()
-	transactions[m.ID] = state
+	transactions[transactionID] = state
+	if collecting {
+		// 続きの画像を待ってから解析する
+		openStitchGroupLocked(s, m, state)
+	}
 	mu.Unlock()
 
 	// 2. バックグラウンドでAI解析を開始
-	go analyzeReceiptInBackground(s, m, state)
+	if !collecting {
+		go analyzeReceiptInBackground(s, state)
+	}
 
 	// 3. フォアグラウンドでユーザーに補足情報入力を求めるボタンを表示
-	_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-		Content: "📋 レシートを解析中です...\n下のボタンをクリックして詳細情報を入力してください:",
-		Components: []discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					discordgo.Button{
-						CustomID: "receipt_info_button:" + m.ID,
-						Label:    "詳細情報を入力",
-						Style:    discordgo.PrimaryButton,
-						Emoji:    &discordgo.ComponentEmoji{Name: "📝"},
-					},
-				},
-			},
-		},
+	mu.Lock()
+	content, components := renderReceiptPrompt(state)
+	mu.Unlock()
+	msg, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content:    content,
+		Components: components,
 	})
 	if err != nil {
 		log.Printf("補足情報ボタンの表示に失敗: %v", err)
+		return
+	}
+
+	mu.Lock()
+	state.PromptMessageID = msg.ID
+	finished := state.Status != AnalysisStatusRunning && state.Status != AnalysisStatusCollecting
+	mu.Unlock()
+
+	// ボタン表示前に解析が終わっていた場合は表示を追いつかせる
+	if finished {
+		updateReceiptPrompt(s, state)
 	}
 }
 
+// renderReceiptPrompt は解析状態に応じたボタンメッセージの内容を組み立てる（呼び出し側でmuを保持すること）
+func renderReceiptPrompt(state *TransactionState) (string, []discordgo.MessageComponent) {
+	content, components := renderReceiptPromptBody(state)
+	return state.AttachmentLabel + content, components
+}
+
+// renderReceiptPromptBody は解析状態ごとのメッセージ本文とボタンを返す
+func renderReceiptPromptBody(state *TransactionState) (string, []discordgo.MessageComponent) {
+	messageID := state.InitialMessageID
+	infoButton := discordgo.Button{
+		CustomID: "receipt_info_button:" + messageID,
+		Label:    "詳細情報を入力",
+		Style:    discordgo.PrimaryButton,
+		Emoji:    &discordgo.ComponentEmoji{Name: "📝"},
+	}
+
+	switch state.Status {
+	case AnalysisStatusCollecting:
+		content := fmt.Sprintf("🧩 続きの画像を待っています（現在%d件の画像）\n長いレシートを分割して撮影した場合は、続きの画像を%d秒以内に投稿すると1枚のレシートとしてまとめて解析します。投稿し終えたら「今すぐ解析」で待たずに解析を始められます:",
+			len(state.Attachments), int(stitchWindow().Seconds()))
+		buttons := []discordgo.MessageComponent{}
+		if state.UserInput == nil {
+			buttons = append(buttons, infoButton)
+		}
+		buttons = append(buttons, discordgo.Button{
+			CustomID: "receipt_stitch_done:" + messageID,
+			Label:    "今すぐ解析",
+			Style:    discordgo.SecondaryButton,
+			Emoji:    &discordgo.ComponentEmoji{Name: "▶️"},
+		})
+		return content, []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+
+	case AnalysisStatusSucceeded:
+		return "✅ レシートの解析が完了しました。\n下のボタンをクリックして詳細情報を入力してください:",
+			[]discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{infoButton}}}
+
+	case AnalysisStatusFailed:
+		content := fmt.Sprintf("⚠️ 解析失敗: %s\n「再解析」で再試行するか、「手入力で続行」で確認画面を開いて各項目を入力してください。",
+			describeAnalysisError(state.LastError))
+		buttons := []discordgo.MessageComponent{}
+		// 補足情報が未入力の場合は、先にカテゴリー等を入力できるようにしておく
+		if state.UserInput == nil {
+			buttons = append(buttons, infoButton)
+		}
+		buttons = append(buttons,
+			discordgo.Button{
+				CustomID: "receipt_retry:" + messageID,
+				Label:    "再解析",
+				Style:    discordgo.SecondaryButton,
+				Emoji:    &discordgo.ComponentEmoji{Name: "🔄"},
+			},
+			discordgo.Button{
+				CustomID: "receipt_manual:" + messageID,
+				Label:    "手入力で続行",
+				Style:    discordgo.SecondaryButton,
+				Emoji:    &discordgo.ComponentEmoji{Name: "✏️"},
+			},
+		)
+		return content, []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+
+	case AnalysisStatusPending:
+		content := fmt.Sprintf("⏳ AIが利用できないため解析を保留しています: %s\n%s頃に自動で再試行し、解析でき次第お知らせします。待たずに続ける場合は「手入力で続行」を押してください。",
+			describeAnalysisError(state.LastError), state.NextAttemptAt.In(tokyoLocation).Format("15:04"))
+		if state.UserInput != nil {
+			content = strings.Replace(content, "お知らせします", "入力済みの補足情報で確認画面を表示します", 1)
+		}
+		buttons := []discordgo.MessageComponent{}
+		if state.UserInput == nil {
+			buttons = append(buttons, infoButton)
+		}
+		buttons = append(buttons,
+			discordgo.Button{
+				CustomID: "receipt_retry:" + messageID,
+				Label:    "今すぐ再解析",
+				Style:    discordgo.SecondaryButton,
+				Emoji:    &discordgo.ComponentEmoji{Name: "🔄"},
+			},
+			discordgo.Button{
+				CustomID: "receipt_manual:" + messageID,
+				Label:    "手入力で続行",
+				Style:    discordgo.SecondaryButton,
+				Emoji:    &discordgo.ComponentEmoji{Name: "✏️"},
+			},
+		)
+		return content, []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+
+	default:
+		content := "📋 レシートを解析中です...\n下のボタンをクリックして詳細情報を入力してください:"
+		if state.Attempts > 0 {
+			content = fmt.Sprintf("🔄 レシートを再解析中です... (%d回目)\n下のボタンをクリックして詳細情報を入力してください:", state.Attempts)
+		}
+		if state.UserInput != nil {
+			return strings.SplitN(content, "\n", 2)[0], []discordgo.MessageComponent{}
+		}
+		return content, []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{infoButton}}}
+	}
+}
+
+// updateReceiptPrompt はボタンメッセージを現在の解析状態に合わせて書き換える
+func updateReceiptPrompt(s *discordgo.Session, state *TransactionState) {
+	mu.Lock()
+	channelID := state.ChannelID
+	promptMessageID := state.PromptMessageID
+	content, components := renderReceiptPrompt(state)
+	mu.Unlock()
+
+	if promptMessageID == "" {
+		return
+	}
+
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         promptMessageID,
+		Channel:    channelID,
+		Content:    &content,
+		Components: &components,
+	})
+	if err != nil {
+		botErr := NewBotError(ErrorTypeDiscordAPI, "解析状態メッセージの更新に失敗", err).
+			WithContext("message_id", state.InitialMessageID)
+		LogBotError(botErr)
+	}
+}
+
+// describeAnalysisError は解析エラーをユーザー向けの短い説明に変換する
+func describeAnalysisError(err error) string {
+	if err == nil {
+		return "不明なエラー"
+	}
+	if botErr, ok := err.(*BotError); ok {
+		return botErr.Message
+	}
+	return err.Error()
+}
+
 // (handleCheckMaster, handleShowMaster, handleFix, handlePagination は変更なし)
 func handleCheckMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	embed := &discordgo.MessageEmbed{
 		Title: "マスターデータ読み込み状況", Color: 0x00ff00, 
 		Fields: []*discordgo.MessageEmbedField{
-			{Name: "カテゴリ", Value: fmt.Sprintf("%d 件", len(masterCategories)), Inline: true},
-			{Name: "グループ", Value: fmt.Sprintf("%d 件", len(masterGroups)), Inline: true},
-			{Name: "ユーザー", Value: fmt.Sprintf("%d 件", len(masterUsers)), Inline: true},
-			{Name: "支払い方法", Value: fmt.Sprintf("%d 件", len(masterPaymentTypes)), Inline: true},
-			{Name: "収入源", Value: fmt.Sprintf("%d 件", len(masterSourceList)), Inline: true},
-			{Name: "収入種別", Value: fmt.Sprintf("%d 件", len(masterTypeKind)), Inline: true},
-			{Name: "支払い種別", Value: fmt.Sprintf("%d 件", len(masterTypeList)), Inline: true},
+			{Name: "カテゴリ", Value: fmt.Sprintf("%d 件", len(h.masterCategories)), Inline: true},
+			{Name: "グループ", Value: fmt.Sprintf("%d 件", len(h.masterGroups)), Inline: true},
+			{Name: "ユーザー", Value: fmt.Sprintf("%d 件", len(h.masterUsers)), Inline: true},
+			{Name: "支払い方法", Value: fmt.Sprintf("%d 件", len(h.masterPaymentTypes)), Inline: true},
+			{Name: "収入源", Value: fmt.Sprintf("%d 件", len(h.masterSourceList)), Inline: true},
+			{Name: "収入種別", Value: fmt.Sprintf("%d 件", len(h.masterTypeKind)), Inline: true},
+			{Name: "支払い種別", Value: fmt.Sprintf("%d 件", len(h.masterTypeList)), Inline: true},
+			{Name: "プロンプト", Value: promptVersionsSummary(), Inline: false},
 		},
 	}
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -448,8 +812,9 @@ func handleCheckMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	})
 }
 func handleShowMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	dataType := i.ApplicationCommandData().Options[0].StringValue()
-	embed, components, err := generatePaginatedData(dataType, 0)
+	embed, components, err := h.generatePaginatedData(dataType, 0)
 	if err != nil {
 		botErr := NewBotError(ErrorTypeDataAccess, "ページデータ生成エラー", err).
 			WithContext("data_type", dataType).
@@ -475,25 +840,36 @@ func handleFix(s *discordgo.Session, i *discordgo.InteractionCreate) {
 
 // handleReceiptInfoButton はボタンクリック時にカテゴリー選択画面を表示する
 func handleReceiptInfoButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "receipt_info_button:")
 	
+	// 解析済みで同じ店舗の履歴があれば、最も多く選ばれたカテゴリーを初期選択にする
+	suggestion, hasSuggestion := h.suggestTransactionStoreChoice(messageID)
+	content := "📋 まずカテゴリーを選択してください:"
+	
 	// カテゴリー選択用のSelectMenuオプションを準備（最大25件）
 	var categoryOptions []discordgo.SelectMenuOption
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		if len(categoryOptions) >= 25 {
 			break // Discord SelectMenuの制限
 		}
-		categoryOptions = append(categoryOptions, discordgo.SelectMenuOption{
+		option := discordgo.SelectMenuOption{
 			Label: category.Name,
 			Value: strconv.Itoa(category.ID),
-		})
+		}
+		if hasSuggestion && suggestion.HasCategory && category.ID == suggestion.CategoryID {
+			option.Default = true
+			option.Description = "前回と同じ"
+			content = fmt.Sprintf("📋 まずカテゴリーを選択してください（🔁 「%s」では前回「%s」を選択）:", suggestion.StoreName, category.Name)
+		}
+		categoryOptions = append(categoryOptions, option)
 	}
 
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: "📋 まずカテゴリーを選択してください:",
+			Content: content,
 			Flags:   discordgo.MessageFlagsEphemeral,
 			Components: []discordgo.MessageComponent{
 				discordgo.ActionsRow{
@@ -524,6 +900,7 @@ func handleReceiptInfoButton(s *discordgo.Session, i *discordgo.InteractionCreat
 
 // handleCategorySelect はカテゴリー選択後にモーダルを表示する
 func handleCategorySelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "category_select:")
 	
@@ -538,6 +915,17 @@ func handleCategorySelect(s *discordgo.Session, i *discordgo.InteractionCreate)
 	// 	}
 	// }
 
+	// 同じ店舗でよく使うグループを初期値にする（不要なら入力欄を空にすればよい）
+	var groupKeyword string
+	if suggestion, ok := h.suggestTransactionStoreChoice(messageID); ok && suggestion.GroupID != nil {
+		for _, group := range h.masterGroups {
+			if group.ID == *suggestion.GroupID {
+				groupKeyword = group.Name
+				break
+			}
+		}
+	}
+
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseModal,
 		Data: &discordgo.InteractionResponseData{
@@ -570,6 +958,7 @@ func handleCategorySelect(s *discordgo.Session, i *discordgo.InteractionCreate)
 						Style:       discordgo.TextInputShort,
 						Required:    false,
 						Placeholder: "例: 外食",
+						Value:       groupKeyword,
 					},
 				}},
 				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
@@ -620,6 +1009,7 @@ func handleCategorySearch(s *discordgo.Session, i *discordgo.InteractionCreate)
 
 // handleCategorySearchModal はキーワード検索結果を表示する
 func handleCategorySearchModal(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.ModalSubmitData().CustomID
 	messageID := strings.TrimPrefix(customID, "category_search_modal:")
 	
@@ -636,7 +1026,7 @@ func handleCategorySearchModal(s *discordgo.Session, i *discordgo.InteractionCre
 	}
 	
 	// カテゴリーを検索
-	matchedCategories := searchCategories(searchKeyword)
+	matchedCategories := h.searchCategories(searchKeyword)
 	
 	if len(matchedCategories) == 0 {
 		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -688,11 +1078,11 @@ func handleCategorySearchModal(s *discordgo.Session, i *discordgo.InteractionCre
 }
 
 // searchCategories はキーワードに基づいてカテゴリーを検索する
-func searchCategories(keyword string) []Category {
+func (h *Household) searchCategories(keyword string) []Category {
 	keyword = strings.ToLower(keyword)
 	var matched []Category
 	
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		categoryName := strings.ToLower(category.Name)
 		
 		// 完全一致を優先
@@ -776,6 +1166,20 @@ func handleReceiptInfoModal(s *discordgo.Session, i *discordgo.InteractionCreate
 	
 	log.Printf("モーダルデータを受信: messageID=%s, data=%+v", messageID, userInput)
 	
+	// 金額が入力されている場合は解釈できるかを先に確認する（ボタンから入力し直せるよう状態は変更しない）
+	if priceStr := strings.TrimSpace(userInput["price"]); priceStr != "" {
+		if _, err := parseAmount(priceStr); err != nil {
+			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseChannelMessageWithSource,
+				Data: &discordgo.InteractionResponseData{
+					Content: describeAmountError(priceStr, err) + "\n「詳細情報を入力」から入力し直してください。",
+					Flags:   discordgo.MessageFlagsEphemeral,
+				},
+			})
+			return
+		}
+	}
+	
 	// 一時的に応答を送信
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -797,89 +1201,281 @@ func processReceiptWithUserInput(s *discordgo.Session, messageID string, userInp
 	// トランザクション状態を取得
 	mu.Lock()
 	state, exists := transactions[messageID]
+	if exists {
+		state.UserInput = userInput
+	}
 	mu.Unlock()
-	
+
 	if !exists {
 		log.Printf("トランザクション状態が見つかりません: %s", messageID)
 		return
 	}
-	
-	// AI解析結果を待機（タイムアウト付き）
-	select {
-	case aiResult := <-state.AIResultChan:
-		log.Printf("AI解析結果とユーザー入力を結合中: messageID=%s", messageID)
-		
-		// カテゴリーをIDから決定（新しい選択方式）
-		var categoryID int
-		if categoryIDStr := userInput["category_id"]; categoryIDStr != "" {
-			// SelectMenuから選択されたカテゴリーID
-			if cid, err := strconv.Atoi(categoryIDStr); err == nil {
-				categoryID = cid
-			} else {
-				categoryID = 1 // デフォルト値
-			}
-		} else {
-			// 旧方式のキーワード検索（フォールバック）
-			categoryKeyword := userInput["category_keyword"]
-			categoryID = findCategoryByKeyword(categoryKeyword)
-		}
-		
-		// グループをキーワードから決定（任意）
-		var groupID *int
-		if groupKeyword := userInput["group_keyword"]; groupKeyword != "" {
-			if gid := findGroupByKeyword(groupKeyword); gid != nil {
-				groupID = gid
-			}
-		}
-		
-		// ユーザー処理（名前ベース、デフォルトは「自分」でID=0）
-		var userID int = 0 // デフォルトは「自分」のID=0
-		if userName := userInput["user_name"]; userName != "" && userName != "自分" {
-			// ユーザー名で検索
-			for _, user := range masterUsers {
-				if strings.Contains(user.Name, userName) || strings.Contains(userName, user.Name) {
-					userID = user.ID
-					break
-				}
-			}
+
+	// 既に解析失敗が確定している場合は待たずに再解析・手入力の選択肢を表示
+	mu.Lock()
+	pending := state.Status == AnalysisStatusPending
+	alreadyFailed := state.Status == AnalysisStatusFailed || pending
+	resultChan := state.AIResultChan
+	state.awaitingResult = !alreadyFailed
+	mu.Unlock()
+	if alreadyFailed {
+		if pending {
+			// 保留中の解析が成功したときに確認画面まで進められるよう、補足情報も保存しておく
+			setPendingUserInput(messageID, userInput)
 		}
-		
-		// 金額処理（ユーザー入力があれば優先、なければAI解析結果を使用）
-		var amount int
-		if aiResult.TotalAmount != nil {
-			amount = *aiResult.TotalAmount
-		}
-		if priceStr := userInput["price"]; priceStr != "" {
-			if userAmount, err := strconv.Atoi(priceStr); err == nil {
-				amount = userAmount
+		log.Printf("AI解析は失敗済みのため再解析・手入力を案内します: %s", messageID)
+		updateReceiptPrompt(s, state)
+		return
+	}
+
+	// AI解析結果を待機（長いレシートの続きの画像が届いて受付が延びた場合は期限も延ばす）
+	waitStart := time.Now()
+	var outcome AnalysisOutcome
+	timedOut := false
+	for {
+		mu.Lock()
+		deadline := state.analysisDeadlineLocked(waitStart)
+		mu.Unlock()
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case outcome = <-resultChan:
+			timer.Stop()
+		case <-timer.C:
+			mu.Lock()
+			extended := state.analysisDeadlineLocked(waitStart).After(deadline)
+			mu.Unlock()
+			if extended {
+				continue
 			}
+			timedOut = true
 		}
-		
-		// 詳細説明を生成
-		detail := generateDetailFromSamples(categoryID, aiResult)
-		
-		log.Printf("処理結果 - Amount: %d, Category: %d, Group: %v, User: %d, Detail: %s",
-			amount, categoryID, groupID, userID, detail)
-		
-		// 処理完了をチャンネルに通知
-		go sendProcessingResult(s, state.InitialMessageID, amount, categoryID, groupID, userID, detail, aiResult)
-		
-	case <-time.After(30 * time.Second):
+		break
+	}
+
+	if timedOut {
 		log.Printf("AI解析がタイムアウトしました: %s", messageID)
+		mu.Lock()
+		state.awaitingResult = false
+		state.Status = AnalysisStatusFailed
+		state.LastError = NewBotError(ErrorTypeAIService, "AI解析がタイムアウトしました", nil)
+		mu.Unlock()
+		updateReceiptPrompt(s, state)
+		return
 	}
-	
+
+	mu.Lock()
+	state.awaitingResult = false
+	mu.Unlock()
+	if outcome.Err != nil {
+		// 失敗時はトランザクションを残し、再解析または手入力での続行を待つ
+		log.Printf("AI解析に失敗したため再解析・手入力を案内します: messageID=%s, err=%v", messageID, outcome.Err)
+		updateReceiptPrompt(s, state)
+		return
+	}
+
+	log.Printf("AI解析結果とユーザー入力を結合中: messageID=%s", messageID)
+	mu.Lock()
+	receiptKey := state.ReceiptKey
+	mu.Unlock()
+	sendConfirmationFromInput(s, state.Household, state.InitialMessageID, state.posterID(), receiptKey, userInput, outcome.Result, true)
+
 	// 状態をクリーンアップ
 	mu.Lock()
 	delete(transactions, messageID)
 	mu.Unlock()
 }
 
+// sendConfirmationFromInput はユーザー入力と解析結果（部分的でも可）から確認画面を作成する
+func sendConfirmationFromInput(s *discordgo.Session, h *Household, messageID, posterID, receiptKey string, userInput map[string]string, aiResult ReceiptAnalysis, useAIDetail bool) {
+	// カテゴリーをIDから決定（新しい選択方式）
+	var categoryID int
+	var fixed RuleFixedFields
+	if categoryIDStr := userInput["category_id"]; categoryIDStr != "" {
+		// SelectMenuから選択されたカテゴリーID
+		if cid, err := strconv.Atoi(categoryIDStr); err == nil {
+			categoryID = cid
+			fixed.Category = true
+		} else {
+			categoryID = 1 // デフォルト値
+		}
+	} else if categoryKeyword := userInput["category_keyword"]; categoryKeyword != "" {
+		// 旧方式のキーワード検索（フォールバック）
+		categoryID = h.findCategoryByKeyword(categoryKeyword)
+		fixed.Category = true
+	} else if suggestion, ok := h.suggestStoreChoice(receiptStoreName(aiResult)); ok && suggestion.HasCategory {
+		// 未選択の場合は同じ店舗で最も多く選ばれたカテゴリーを使う
+		categoryID = suggestion.CategoryID
+	} else {
+		categoryID = 1 // デフォルトカテゴリー
+	}
+
+	// グループをキーワードから決定（任意）
+	var groupID *int
+	if groupKeyword, answered := userInput["group_keyword"]; groupKeyword != "" {
+		if gid := h.findGroupByKeyword(groupKeyword); gid != nil {
+			groupID = gid
+			fixed.Group = true
+		}
+	} else if !answered {
+		// 補足情報を入力せずに続行した場合は同じ店舗で最も多く選ばれたグループを使う
+		if suggestion, ok := h.suggestStoreChoice(receiptStoreName(aiResult)); ok && suggestion.HasGroup {
+			groupID = suggestion.GroupID
+		}
+	}
+
+	// 投稿者が指定した支払い方法は自動分類ルールで上書きしない
+	fixed.PaymentMethod = userInput["payment_method"] != ""
+
+	// ユーザー処理（名前ベース、デフォルトは「自分」でID=0）
+	var userID int = 0 // デフォルトは「自分」のID=0
+	if userName := userInput["user_name"]; userName != "" {
+		if id, found := h.findUserByName(userName); found {
+			userID = id
+		}
+	}
+
+	// 金額処理（ユーザー入力があれば優先、なければAI解析結果を使用）
+	var amount int
+	if aiResult.TotalAmount != nil {
+		amount = *aiResult.TotalAmount
+	}
+	if priceStr := strings.TrimSpace(userInput["price"]); priceStr != "" {
+		// 入力はモーダル送信時に検証済み
+		if userAmount, err := parseAmount(priceStr); err == nil {
+			amount = userAmount
+		}
+	}
+
+	// 詳細説明を生成（手入力での続行時はAIを呼ばずに読み取れた情報から組み立てる）
+	var detail string
+	if useAIDetail {
+		var detailPromptVersion string
+		detail, detailPromptVersion = h.generateDetailFromSamples(categoryID, aiResult)
+		if detailPromptVersion != "" {
+			// 解析結果は取引の状態と共有しているため、コピーに追記する
+			aiResult.PromptVersions = append(slices.Clip(aiResult.PromptVersions), detailPromptVersion)
+		}
+	} else {
+		var storeName, items string
+		if aiResult.StoreName != nil {
+			storeName = *aiResult.StoreName
+		}
+		if aiResult.Items != nil {
+			items = *aiResult.Items
+		}
+		detail = generateFallbackDetail(storeName, items)
+	}
+
+	log.Printf("処理結果 - Amount: %d, Category: %d, Group: %v, User: %d, Detail: %s",
+		amount, categoryID, groupID, userID, detail)
+
+	// 処理完了をチャンネルに通知
+	go sendProcessingResult(s, h, messageID, posterID, amount, categoryID, groupID, userID, detail, aiResult, receiptKey, fixed)
+}
+
+// handleReceiptRetry は「再解析」ボタンの処理（バックオフ付きで再試行する）
+func handleReceiptRetry(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	messageID := strings.TrimPrefix(customID, "receipt_retry:")
+
+	mu.Lock()
+	state, exists := transactions[messageID]
+	alreadyRunning := exists && (state.Status == AnalysisStatusRunning || state.Status == AnalysisStatusCollecting)
+	if exists && !alreadyRunning {
+		state.Status = AnalysisStatusRunning
+		state.LastError = nil
+		state.Attempts++
+		state.AIResultChan = make(chan AnalysisOutcome, 1)
+	}
+	mu.Unlock()
+
+	if !exists || alreadyRunning {
+		content := "❌ エラー: 解析データが見つかりません。レシートを再投稿してください。"
+		if alreadyRunning {
+			content = "🔄 既に解析中です。しばらくお待ちください。"
+		}
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		log.Printf("再解析応答エラー: %v", err)
+	}
+
+	log.Printf("レシートを再解析します: messageID=%s", messageID)
+	updateReceiptPrompt(s, state)
+	// 一時的なエラーの再試行はGeminiクライアント（ResilientModel）が行うため、ここでは1回だけ解析する
+	go analyzeReceiptInBackground(s, state)
+}
+
+// handleReceiptManual は「手入力で続行」ボタンの処理（読み取れた内容で確認画面を開く）
+func handleReceiptManual(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	messageID := strings.TrimPrefix(customID, "receipt_manual:")
+
+	mu.Lock()
+	state, exists := transactions[messageID]
+	var partial ReceiptAnalysis
+	var receiptKey string
+	var h *Household
+	var posterID string
+	userInput := map[string]string{}
+	if exists {
+		h = state.Household
+		posterID = state.posterID()
+		partial = state.LastResult
+		receiptKey = state.ReceiptKey
+		if state.UserInput != nil {
+			userInput = state.UserInput
+		}
+		delete(transactions, messageID)
+	}
+	mu.Unlock()
+	removePendingAnalysis(messageID)
+
+	if !exists {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "❌ エラー: 解析データが見つかりません。レシートを再投稿してください。",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	content := "✏️ 手入力で続行します。確認画面で各項目を入力・修正してください。"
+	components := []discordgo.MessageComponent{}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: components,
+		},
+	})
+	if err != nil {
+		log.Printf("手入力続行応答エラー: %v", err)
+	}
+
+	log.Printf("手入力で確認画面を作成します: messageID=%s", messageID)
+	sendConfirmationFromInput(s, h, messageID, posterID, receiptKey, userInput, partial, false)
+}
+
 // findCategoryByKeyword はキーワードからカテゴリーIDを見つける
-func findCategoryByKeyword(keyword string) int {
+func (h *Household) findCategoryByKeyword(keyword string) int {
 	keyword = strings.ToLower(keyword)
 	log.Printf("カテゴリー検索: %s", keyword)
 	
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		categoryName := strings.ToLower(category.Name)
 		log.Printf("  比較中: %s", categoryName)
 		
@@ -908,11 +1504,11 @@ func findCategoryByKeyword(keyword string) int {
 }
 
 // findGroupByKeyword はキーワードからグループIDを見つける
-func findGroupByKeyword(keyword string) *int {
+func (h *Household) findGroupByKeyword(keyword string) *int {
 	keyword = strings.ToLower(keyword)
 	log.Printf("グループ検索: %s", keyword)
 	
-	for _, group := range masterGroups {
+	for _, group := range h.masterGroups {
 		groupName := strings.ToLower(group.Name)
 		log.Printf("  比較中: %s", groupName)
 		
@@ -933,62 +1529,58 @@ func findGroupByKeyword(keyword string) *int {
 	return nil
 }
 
-// generateDetailFromSamples はLLMを使用してカテゴリー別の詳細説明を生成する
-func generateDetailFromSamples(categoryID int, aiResult ReceiptAnalysis) string {
+// findUserByName はユーザー名からユーザーIDを見つける（「自分」はID=0）
+func (h *Household) findUserByName(userName string) (int, bool) {
+	if userName == "自分" {
+		return 0, true
+	}
+	for _, user := range h.masterUsers {
+		if strings.Contains(user.Name, userName) || strings.Contains(userName, user.Name) {
+			return user.ID, true
+		}
+	}
+	return 0, false
+}
+
+// generateDetailFromSamples はLLMを使用してカテゴリー別の詳細説明を生成し、使用したプロンプトのバージョンも返す
+// （LLMを使わずに組み立てた場合のバージョンは空）
+func (h *Household) generateDetailFromSamples(categoryID int, aiResult ReceiptAnalysis) (string, string) {
 	// カテゴリー名を取得
 	var categoryName string
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		if category.ID == categoryID {
 			categoryName = category.Name
 			break
 		}
 	}
 	
-	// detail_samplesから該当カテゴリーのサンプルを探す
-	samplePattern, hasSample := detailSamples[categoryName]
+	// detail_samplesと確定済みの詳細説明から、トークン上限内のサンプルを組み立てる
+	samplePattern, hasSample := h.buildDetailFewShot(categoryName)
 	
 	// AI解析結果から基本情報を抽出
-	var storeName, items, paymentMethod string
+	var storeName, items string
 	if aiResult.StoreName != nil {
 		storeName = *aiResult.StoreName
 	}
 	if aiResult.Items != nil {
 		items = *aiResult.Items
 	}
-	if aiResult.PaymentMethod != nil {
-		paymentMethod = *aiResult.PaymentMethod
-	}
 	
 	// サンプルパターンがある場合はLLMで詳細説明を生成
 	if hasSample {
-		prompt := fmt.Sprintf(`あなたは家計簿の詳細説明を生成するアシスタントです。
-
-以下の情報に基づいて、「%s」カテゴリーの詳細説明を生成してください。
-
-【レシート情報】
-店舗名: %s
-商品/サービス: %s
-支払い方法: %s
-
-【このカテゴリーの入力パターンサンプル】
-%s
-
-【生成ルール】
-1. サンプルパターンに従った形式で記述してください
-2. 店舗名と商品名は正確に記載してください
-3. 簡潔で分かりやすい表現にしてください
-4. 日本語で記述してください
-5. 特殊記号や改行は使用せず、一行で記述してください
-
-詳細説明:`, categoryName, storeName, items, paymentMethod, samplePattern)
+		prompt, promptVersion, err := renderPrompt(receipt.DetailPrompt, receipt.NewDetailPromptData(categoryName, aiResult, samplePattern))
+		if err != nil {
+			HandleError(err, nil)
+			return generateFallbackDetail(storeName, items), ""
+		}
 
 		// Gemini APIで詳細説明を生成
 		ctx := context.Background()
-		resp, err := geminiClient.GenerateContent(ctx, genai.Text(prompt))
+		resp, _, err := geminiClient.GenerateContent(ctx, genai.Text(prompt))
 		if err != nil {
 			log.Printf("詳細説明生成エラー: %v", err)
 			// エラーの場合は従来の方式にフォールバック
-			return generateFallbackDetail(storeName, items)
+			return generateFallbackDetail(storeName, items), ""
 		}
 		
 		if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
@@ -999,14 +1591,14 @@ func generateDetailFromSamples(categoryID int, aiResult ReceiptAnalysis) string
 			cleanedText = strings.ReplaceAll(cleanedText, "\r", " ")
 			
 			if cleanedText != "" {
-				log.Printf("LLMで詳細説明を生成: %s", cleanedText)
-				return cleanedText
+				log.Printf("LLMで詳細説明を生成 (%s): %s", promptVersion, cleanedText)
+				return cleanedText, promptVersion
 			}
 		}
 	}
 	
 	// サンプルがない場合やLLM生成に失敗した場合はフォールバック
-	return generateFallbackDetail(storeName, items)
+	return generateFallbackDetail(storeName, items), ""
 }
 
 // generateFallbackDetail はフォールバック用の詳細説明を生成
@@ -1029,10 +1621,51 @@ func generateFallbackDetail(storeName, items string) string {
 }
 
 // sendProcessingResult はキュー追加前の確認画面を表示する
-func sendProcessingResult(s *discordgo.Session, messageID string, amount int, categoryID int, groupID *int, userID int, detail string, aiResult ReceiptAnalysis) {
+// fixedはユーザーが指定済みの項目で、自動分類ルールでは上書きしない
+func sendProcessingResult(s *discordgo.Session, h *Household, messageID, posterID string, amount int, categoryID int, groupID *int, userID int, detail string, aiResult ReceiptAnalysis, receiptKey string, fixed RuleFixedFields) {
+	// 支払い方法情報を取得（読み取れない場合は同じ店舗で最も多く使った支払い方法）
+	var paymentMethod string = "不明"
+	paymentFromAI := false
+	if aiResult.PaymentMethod != nil {
+		paymentMethod = *aiResult.PaymentMethod
+		paymentFromAI = true
+	} else if suggestion, ok := h.suggestStoreChoice(receiptStoreName(aiResult)); ok && suggestion.PaymentMethod != "" {
+		paymentMethod = suggestion.PaymentMethod
+	}
+	
+	// 自動分類ルールを優先度順に評価し、ユーザーが指定していない項目を一致したルールの設定で上書きする
+	ruleDetail := detail
+	if aiResult.Items != nil {
+		ruleDetail += " " + *aiResult.Items
+	}
+	ruleResult := h.applyRules(RuleInput{
+		Detail:        ruleDetail,
+		StoreName:     receiptStoreName(aiResult),
+		Amount:        amount,
+		PaymentMethod: paymentMethod,
+		Fixed:         fixed,
+	})
+	if ruleResult.CategoryID != nil {
+		categoryID = *ruleResult.CategoryID
+	}
+	if ruleResult.GroupID != nil {
+		groupID = ruleResult.GroupID
+	}
+	if ruleResult.PaymentMethod != "" {
+		paymentMethod = ruleResult.PaymentMethod
+		paymentFromAI = false
+	}
+	var appliedRules []string
+	for _, rule := range ruleResult.Fired {
+		appliedRules = append(appliedRules, rule.describe(h))
+	}
+	if len(appliedRules) > 0 {
+		log.Printf("自動分類ルールを適用: messageID=%s, rules=%v", messageID, appliedRules)
+	}
+	
 	// カテゴリー名を取得
 	var categoryName string = "不明"
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		if category.ID == categoryID {
 			categoryName = category.Name
 			break
@@ -1042,7 +1675,7 @@ func sendProcessingResult(s *discordgo.Session, messageID string, amount int, ca
 	// グループ名を取得
 	var groupName string = "なし"
 	if groupID != nil {
-		for _, group := range masterGroups {
+		for _, group := range h.masterGroups {
 			if group.ID == *groupID {
 				groupName = group.Name
 				break
@@ -1052,38 +1685,43 @@ func sendProcessingResult(s *discordgo.Session, messageID string, amount int, ca
 	
 	// ユーザー名を取得
 	var userName string = "不明"
-	for _, user := range masterUsers {
+	for _, user := range h.masterUsers {
 		if user.ID == userID {
 			userName = user.Name
 			break
 		}
 	}
 	
-	// 日付情報
-	var dateStr string = "不明"
+	// 日付情報（AIの表記ゆれをISO形式に揃え、読み取れない場合は日本時間の今日とする）
+	dateStr := todayInTokyo()
 	if aiResult.Date != nil {
-		dateStr = *aiResult.Date
-	} else {
-		dateStr = time.Now().Format("2006-01-02")
-	}
-	
-	// 支払い方法情報を取得
-	var paymentMethod string = "不明"
-	if aiResult.PaymentMethod != nil {
-		paymentMethod = *aiResult.PaymentMethod
+		if normalized, err := normalizeDate(*aiResult.Date, nowInTokyo()); err == nil {
+			dateStr = normalized
+		} else {
+			log.Printf("AIが読み取った日付を解釈できないため今日の日付を使用します: %s (%v)", *aiResult.Date, err)
+		}
 	}
 	
 	// データを一時保存用の構造体に格納
-	storeConfirmationData(messageID, amount, categoryID, groupID, userID, detail, dateStr, paymentMethod, aiResult)
+	storeConfirmationData(h, messageID, posterID, amount, categoryID, groupID, userID, detail, dateStr, paymentMethod, aiResult, receiptKey)
+	uncertainFields := assessUncertainFields(aiResult, paymentMethod, paymentFromAI, nowInTokyo())
+	updateConfirmationData(messageID, func(data *ConfirmationData) {
+		data.AppliedRules = appliedRules
+		data.UncertainFields = uncertainFields
+	})
+	
+	// キュー内の既存データと重複していないか確認
+	refreshConfirmationDuplicates(messageID)
+	data := getConfirmationData(messageID)
 	
 	// Embedを作成（確認画面用）
 	embed := &discordgo.MessageEmbed{
 		Title: "📋 キューに追加前の確認",
 		Color: 0xffa500,
 		Fields: []*discordgo.MessageEmbedField{
-			{Name: "📅 日付", Value: dateStr, Inline: true},
-			{Name: "💵 金額", Value: fmt.Sprintf("¥%d", amount), Inline: true},
-			{Name: "💳 支払い方法", Value: paymentMethod, Inline: true},
+			{Name: confidenceFieldName(data, confidenceFieldDate, "📅 日付"), Value: dateStr, Inline: true},
+			{Name: confidenceFieldName(data, confidenceFieldAmount, "💵 金額"), Value: fmt.Sprintf("¥%d", amount), Inline: true},
+			{Name: confidenceFieldName(data, confidenceFieldPayment, "💳 支払い方法"), Value: paymentMethod, Inline: true},
 			{Name: "📂 カテゴリー", Value: categoryName, Inline: true},
 			{Name: "🏷️ グループ", Value: groupName, Inline: true},
 			{Name: "👤 支払者", Value: userName, Inline: true},
@@ -1093,8 +1731,36 @@ func sendProcessingResult(s *discordgo.Session, messageID string, amount int, ca
 			Text: "各項目を編集できます。問題なければ「キューに追加」をクリックしてください。",
 		},
 	}
+	if uncertain := uncertainFieldsField(data); uncertain != nil {
+		embed.Fields = append(embed.Fields, uncertain)
+	}
+	if fired := appliedRulesField(data); fired != nil {
+		embed.Fields = append(embed.Fields, fired)
+	}
+	if learned := storeHistoryField(data); learned != nil {
+		embed.Fields = append(embed.Fields, learned)
+	}
+	if warning := duplicateWarningField(data); warning != nil {
+		embed.Fields = append(embed.Fields, warning)
+	}
 	
 	// 編集ボタンを作成
+	components := buildConfirmationComponents(messageID, data)
+	
+	// メッセージを送信
+	_, err := s.ChannelMessageSendComplex(h.ChannelID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{embed},
+		Components: components,
+	})
+	if err != nil {
+		log.Printf("確認画面の送信に失敗: %v", err)
+	} else {
+		log.Printf("確認画面を送信しました: messageID=%s", messageID)
+	}
+}
+
+// buildConfirmationComponents は確認画面の編集・追加ボタンを組み立てる
+func buildConfirmationComponents(messageID string, data *ConfirmationData) []discordgo.MessageComponent {
 	components := []discordgo.MessageComponent{
 		discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
@@ -1134,12 +1800,28 @@ func sendProcessingResult(s *discordgo.Session, messageID string, amount int, ca
 				},
 			},
 		},
-		discordgo.ActionsRow{
+	}
+
+	// AIの読み取りが不確かな項目は「〜は正しい」を押すか編集するまで追加ボタンを無効にする
+	needsReview := len(data.UncertainFields) > 0
+	if buttons := uncertainFieldButtons(messageID, data); len(buttons) > 0 {
+		components = append(components, discordgo.ActionsRow{Components: buttons})
+	}
+
+	// 重複候補がある場合は「それでも追加」「既存を表示」で明示的に確認してもらう
+	if len(data.Duplicates) > 0 && !data.ForceAdd {
+		components = append(components, discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
 				discordgo.Button{
-					CustomID: fmt.Sprintf("add_to_queue:%s", messageID),
-					Label:    "✅ キューに追加",
-					Style:    discordgo.SuccessButton,
+					CustomID: fmt.Sprintf("force_add_to_queue:%s", messageID),
+					Label:    "⚠️ それでも追加",
+					Style:    discordgo.PrimaryButton,
+					Disabled: needsReview,
+				},
+				discordgo.Button{
+					CustomID: fmt.Sprintf("show_duplicates:%s", messageID),
+					Label:    "🔍 既存を表示",
+					Style:    discordgo.SecondaryButton,
 				},
 				discordgo.Button{
 					CustomID: fmt.Sprintf("cancel_entry:%s", messageID),
@@ -1147,23 +1829,31 @@ func sendProcessingResult(s *discordgo.Session, messageID string, amount int, ca
 					Style:    discordgo.DangerButton,
 				},
 			},
-		},
+		})
+		return components
 	}
-	
-	// メッセージを送信
-	_, err := s.ChannelMessageSendComplex(targetChannelID, &discordgo.MessageSend{
-		Embeds:     []*discordgo.MessageEmbed{embed},
-		Components: components,
+
+	components = append(components, discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				CustomID: fmt.Sprintf("add_to_queue:%s", messageID),
+				Label:    "✅ キューに追加",
+				Style:    discordgo.SuccessButton,
+				Disabled: needsReview,
+			},
+			discordgo.Button{
+				CustomID: fmt.Sprintf("cancel_entry:%s", messageID),
+				Label:    "❌ キャンセル",
+				Style:    discordgo.DangerButton,
+			},
+		},
 	})
-	if err != nil {
-		log.Printf("確認画面の送信に失敗: %v", err)
-	} else {
-		log.Printf("確認画面を送信しました: messageID=%s", messageID)
-	}
+
+	return components
 }
 
 // storeConfirmationData は確認画面のデータを一時保存する
-func storeConfirmationData(messageID string, amount int, categoryID int, groupID *int, userID int, detail, date, paymentMethod string, aiResult ReceiptAnalysis) {
+func storeConfirmationData(h *Household, messageID, posterID string, amount int, categoryID int, groupID *int, userID int, detail, date, paymentMethod string, aiResult ReceiptAnalysis, receiptKey string) {
 	mu.Lock()
 	defer mu.Unlock()
 	
@@ -1173,6 +1863,8 @@ func storeConfirmationData(messageID string, amount int, categoryID int, groupID
 	
 	confirmationData[messageID] = &ConfirmationData{
 		MessageID:     messageID,
+		Household:     h,
+		PosterID:      posterID,
 		Date:          date,
 		Amount:        amount,
 		CategoryID:    categoryID,
@@ -1181,6 +1873,7 @@ func storeConfirmationData(messageID string, amount int, categoryID int, groupID
 		Detail:        detail,
 		PaymentMethod: paymentMethod,
 		AIResult:      aiResult,
+		ReceiptKey:    receiptKey,
 	}
 }
 
@@ -1212,6 +1905,7 @@ func updateConfirmationData(messageID string, updateFunc func(*ConfirmationData)
 }
 
 func handlePagination(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{ Type: discordgo.InteractionResponseDeferredMessageUpdate, })
 	if err != nil { log.Printf("遅延応答エラー: %v", err); return }
 	customID := i.MessageComponentData().CustomID
@@ -1220,7 +1914,7 @@ func handlePagination(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	dataType := parts[1]
 	page, err := strconv.Atoi(parts[2])
 	if err != nil { log.Printf("ページ番号解析エラー: %v", err); return }
-	embed, components, err := generatePaginatedData(dataType, page)
+	embed, components, err := h.generatePaginatedData(dataType, page)
 	if err != nil { log.Printf("ページデータ生成エラー: %v", err); return }
 	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{ Embeds: &[]*discordgo.MessageEmbed{embed}, Components: &components, })
 	if err != nil { log.Printf("メッセージ更新エラー: %v", err) }
@@ -1228,8 +1922,9 @@ func handlePagination(s *discordgo.Session, i *discordgo.InteractionCreate) {
 
 // handleAdd は /add コマンドの処理
 func handleAdd(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	var typeOptions []discordgo.SelectMenuOption
-	for _, typeItem := range masterTypeList {
+	for _, typeItem := range h.masterTypeList {
 		typeOptions = append(typeOptions, discordgo.SelectMenuOption{ Label: typeItem.TypeName, Value: typeItem.ID, })
 	}
 
@@ -1241,7 +1936,7 @@ func handleAdd(s *discordgo.Session, i *discordgo.InteractionCreate) {
 				discordgo.ActionsRow{ Components: []discordgo.MessageComponent{
 					discordgo.TextInput{
 						CustomID: "date", Label: "日付 (YYYY-MM-DD)", Style: discordgo.TextInputShort,
-						Placeholder: "例: " + time.Now().Format("2006-01-02"), Required: true, Value: time.Now().Format("2006-01-02"),
+						Placeholder: "例: " + todayInTokyo(), Required: true, Value: todayInTokyo(),
 					},
 				}},
 				discordgo.ActionsRow{ Components: []discordgo.MessageComponent{
@@ -1289,22 +1984,24 @@ func handleEditDateModal(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		}
 	}
 	
-	// 日付フォーマットを検証
-	_, err := time.Parse("2006-01-02", newDate)
+	// 日付を検証し、ISO形式に揃える（8/19、R7.8.19、昨日などの表記も受け付ける）
+	normalized, err := normalizeDate(newDate, nowInTokyo())
 	if err != nil {
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
-				Content: "❌ 日付の形式が正しくありません。YYYY-MM-DD形式で入力してください。",
+				Content: describeDateError(newDate, err),
 				Flags:   discordgo.MessageFlagsEphemeral,
 			},
 		})
 		return
 	}
+	newDate = normalized
 	
 	// データを更新
-	updateConfirmationData(messageID, func(data *ConfirmationData) {
+	editConfirmationData(i, messageID, func(data *ConfirmationData) {
 		data.Date = newDate
+		resolveUncertainField(data, confidenceFieldDate)
 	})
 	
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -1336,13 +2033,13 @@ func handleEditAmountModal(s *discordgo.Session, i *discordgo.InteractionCreate)
 		}
 	}
 	
-	// 金額を数値に変換
-	newAmount, err := strconv.Atoi(amountStr)
-	if err != nil || newAmount < 0 {
+	// 金額を数値に変換（カンマ・円記号・全角数字・万/千の単位、返金のマイナスも受け付ける）
+	newAmount, err := parseAmount(amountStr)
+	if err != nil {
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
-				Content: "❌ 金額は正の整数で入力してください。",
+				Content: describeAmountError(amountStr, err),
 				Flags:   discordgo.MessageFlagsEphemeral,
 			},
 		})
@@ -1350,8 +2047,9 @@ func handleEditAmountModal(s *discordgo.Session, i *discordgo.InteractionCreate)
 	}
 	
 	// データを更新
-	updateConfirmationData(messageID, func(data *ConfirmationData) {
+	editConfirmationData(i, messageID, func(data *ConfirmationData) {
 		data.Amount = newAmount
+		resolveUncertainField(data, confidenceFieldAmount)
 	})
 	
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -1384,8 +2082,9 @@ func handleEditPaymentModal(s *discordgo.Session, i *discordgo.InteractionCreate
 	}
 	
 	// データを更新
-	updateConfirmationData(messageID, func(data *ConfirmationData) {
+	editConfirmationData(i, messageID, func(data *ConfirmationData) {
 		data.PaymentMethod = newPaymentMethod
+		resolveUncertainField(data, confidenceFieldPayment)
 	})
 	
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -1418,8 +2117,9 @@ func handleEditDetailModal(s *discordgo.Session, i *discordgo.InteractionCreate)
 	}
 	
 	// データを更新
-	updateConfirmationData(messageID, func(data *ConfirmationData) {
+	editConfirmationData(i, messageID, func(data *ConfirmationData) {
 		data.Detail = newDetail
+		data.DetailEdited = true
 	})
 	
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -1464,7 +2164,7 @@ func handleEditDate(s *discordgo.Session, i *discordgo.InteractionCreate) {
 				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
 					discordgo.TextInput{
 						CustomID:    "date",
-						Label:       "日付 (YYYY-MM-DD形式)",
+						Label:       "日付 (2025-08-24、8/24、R7.8.24、昨日など)",
 						Style:       discordgo.TextInputShort,
 						Required:    true,
 						Value:       data.Date,
@@ -1505,7 +2205,7 @@ func handleEditAmount(s *discordgo.Session, i *discordgo.InteractionCreate) {
 				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
 					discordgo.TextInput{
 						CustomID:    "amount",
-						Label:       "金額（数字のみ）",
+						Label:       "金額（1,280円・1.2万・返金は-500）",
 						Style:       discordgo.TextInputShort,
 						Required:    true,
 						Value:       strconv.Itoa(data.Amount),
@@ -1522,6 +2222,7 @@ func handleEditAmount(s *discordgo.Session, i *discordgo.InteractionCreate) {
 
 // handleEditPayment は支払い方法編集用のセレクトメニューまたはモーダルを表示する
 func handleEditPayment(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "edit_payment:")
 	
@@ -1540,7 +2241,7 @@ func handleEditPayment(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// 現在の支払い方法がcreditの場合、詳細選択を提供
 	if strings.ToLower(data.PaymentMethod) == "クレジット" || strings.ToLower(data.PaymentMethod) == "credit" {
 		// type_kindがcardの支払い方法を取得
-		cardPaymentOptions := getCardPaymentOptions()
+		cardPaymentOptions := h.getCardPaymentOptions()
 		
 		if len(cardPaymentOptions) > 0 {
 			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -1582,12 +2283,12 @@ func handleEditPayment(s *discordgo.Session, i *discordgo.InteractionCreate) {
 }
 
 // getCardPaymentOptions はtype_kindがcardの支払い方法オプションを取得する
-func getCardPaymentOptions() []discordgo.SelectMenuOption {
+func (h *Household) getCardPaymentOptions() []discordgo.SelectMenuOption {
 	var options []discordgo.SelectMenuOption
 	
 	// type_kindからcardのIDを探す
 	var cardTypeID int
-	for _, typeKind := range masterTypeKind {
+	for _, typeKind := range h.masterTypeKind {
 		if strings.ToLower(typeKind.TypeName) == "card" || typeKind.TypeName == "カード" {
 			cardTypeID = typeKind.ID
 			break
@@ -1596,7 +2297,7 @@ func getCardPaymentOptions() []discordgo.SelectMenuOption {
 	
 	// cardTypeIDに対応するtype_listのIDを探す
 	var cardTypeListIDs []string
-	for _, typeList := range masterTypeList {
+	for _, typeList := range h.masterTypeList {
 		// type_listとtype_kindの関連を確認（IDが一致するかチェック）
 		if typeKindId, err := strconv.Atoi(typeList.ID); err == nil && typeKindId == cardTypeID {
 			cardTypeListIDs = append(cardTypeListIDs, typeList.ID)
@@ -1604,7 +2305,7 @@ func getCardPaymentOptions() []discordgo.SelectMenuOption {
 	}
 	
 	// card系のpayment_typeを取得
-	for _, payment := range masterPaymentTypes {
+	for _, payment := range h.masterPaymentTypes {
 		for _, cardTypeListID := range cardTypeListIDs {
 			if payment.TypeID == cardTypeListID {
 				if len(options) >= 25 { // Discord SelectMenuの制限
@@ -1671,6 +2372,7 @@ func showPaymentEditModal(s *discordgo.Session, i *discordgo.InteractionCreate,
 
 // handleEditGroup はグループ編集用のセレクトメニューを表示する
 func handleEditGroup(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "edit_group:")
 	
@@ -1693,7 +2395,7 @@ func handleEditGroup(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		Value: "none",
 	})
 	
-	for _, group := range masterGroups {
+	for _, group := range h.masterGroups {
 		if len(groupOptions) >= 25 {
 			break
 		}
@@ -1728,6 +2430,7 @@ func handleEditGroup(s *discordgo.Session, i *discordgo.InteractionCreate) {
 
 // handleEditPayer は支払者編集用のセレクトメニューを表示する
 func handleEditPayer(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "edit_payer:")
 	
@@ -1745,7 +2448,7 @@ func handleEditPayer(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	
 	// ユーザー選択用のSelectMenuオプションを準備（最大25件）
 	var userOptions []discordgo.SelectMenuOption
-	for _, user := range masterUsers {
+	for _, user := range h.masterUsers {
 		if len(userOptions) >= 25 {
 			break
 		}
@@ -1823,34 +2526,34 @@ func handleEditDetail(s *discordgo.Session, i *discordgo.InteractionCreate) {
 // =================================================================================
 // ヘルパー関数
 // =================================================================================
-func generatePaginatedData(dataType string, page int) (*discordgo.MessageEmbed, []discordgo.MessageComponent, error) {
+func (h *Household) generatePaginatedData(dataType string, page int) (*discordgo.MessageEmbed, []discordgo.MessageComponent, error) {
 	var allItems []string
 	var title string
 	switch dataType {
 	case "category":
 		title = "カテゴリ一覧"
-		categoriesWithQueue := getMasterDataWithQueue("category").([]Category)
+		categoriesWithQueue := h.getMasterDataWithQueue("category").([]Category)
 		for _, item := range categoriesWithQueue { allItems = append(allItems, item.Name) }
 	case "group":
 		title = "グループ一覧"
-		groupsWithQueue := getMasterDataWithQueue("group").([]Group)
+		groupsWithQueue := h.getMasterDataWithQueue("group").([]Group)
 		for _, item := range groupsWithQueue { allItems = append(allItems, item.Name) }
 	case "user":
 		title = "ユーザー一覧"
-		usersWithQueue := getMasterDataWithQueue("user").([]User)
+		usersWithQueue := h.getMasterDataWithQueue("user").([]User)
 		for _, item := range usersWithQueue { allItems = append(allItems, item.Name) }
 	case "payment_type":
 		title = "支払い方法一覧"
-		paymentsWithQueue := getMasterDataWithQueue("payment_type").([]PaymentType)
+		paymentsWithQueue := h.getMasterDataWithQueue("payment_type").([]PaymentType)
 		for _, item := range paymentsWithQueue {
-			typeName := typeListMap[item.TypeID];
+			typeName := h.typeListMap[item.TypeID];
 			if typeName == "" { typeName = "不明" };
 			allItems = append(allItems, fmt.Sprintf("%s (%s)", item.PayKind, typeName))
 		}
 	case "source_list":
 		title = "収入源一覧"
-		for _, item := range masterSourceList {
-			typeName := typeKindMap[item.TypeID];
+		for _, item := range h.masterSourceList {
+			typeName := h.typeKindMap[item.TypeID];
 			if typeName == "" { typeName = "不明" };
 			allItems = append(allItems, fmt.Sprintf("%s (%s)", item.SourceName, typeName))
 		}
@@ -1888,144 +2591,206 @@ func calculatePageBounds(page, totalItems int) (int, int) {
 	if end > totalItems { end = totalItems }
 	return start, end
 }
-func isJapanese(s string) bool {
-	for _, r := range s { if unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han) { return true } }; return false
+// analyzeReceiptInBackground は、バックグラウンドで画像解析を実行する
+func analyzeReceiptInBackground(s *discordgo.Session, state *TransactionState) {
+	mu.Lock()
+	resultChan := state.AIResultChan
+	mu.Unlock()
+
+	result, err := runReceiptAnalysis(state)
+	finishAnalysis(s, state, resultChan, result, err)
+}
+
+// finishAnalysis は解析結果を状態に反映し、待機中の処理へ通知する
+func finishAnalysis(s *discordgo.Session, state *TransactionState, resultChan chan AnalysisOutcome, result ReceiptAnalysis, err error) {
+	mu.Lock()
+	// 再解析で新しい試行が始まっている場合や、手入力で続行済みの場合は結果を破棄する
+	if resultChan != state.AIResultChan || transactions[state.InitialMessageID] != state {
+		mu.Unlock()
+		log.Printf("古い解析結果を破棄しました: messageID=%s", state.InitialMessageID)
+		return
+	}
+	state.LastResult = result
+	state.LastError = err
+	switch {
+	case err == nil:
+		state.Status = AnalysisStatusSucceeded
+	case isAIUnavailable(err) && state.ReceiptKey != "":
+		// 画像はアーカイブ済みのため、AIが使えるようになってから解析をやり直せる
+		state.Status = AnalysisStatusPending
+	default:
+		state.Status = AnalysisStatusFailed
+	}
+	status := state.Status
+	// タイムアウト後や再解析後に成功した場合は、入力済みの補足情報で確認画面まで進める
+	resume := err == nil && state.UserInput != nil && !state.awaitingResult
+	userInput := state.UserInput
+	mu.Unlock()
+
+	wasPending := false
+	if status == AnalysisStatusPending {
+		deferAnalysis(state, err)
+	} else {
+		wasPending = removePendingAnalysis(state.InitialMessageID)
+	}
+
+	select {
+	case resultChan <- AnalysisOutcome{Result: result, Err: err}:
+	default:
+	}
+
+	updateReceiptPrompt(s, state)
+	if resume {
+		go processReceiptWithUserInput(s, state.InitialMessageID, userInput)
+	}
+	if wasPending && !resume {
+		if err == nil {
+			postPendingNotice(s, state, "✅ 保留していたレシートの解析が完了しました。「詳細情報を入力」から続けてください。")
+		} else {
+			postPendingNotice(s, state, "⚠️ 保留していたレシートの解析に失敗しました。「再解析」または「手入力で続行」を選んでください。")
+		}
+	}
 }
-func sortJapaneseFirst(s1, s2 string) bool {
-	isJp1, isJp2 := isJapanese(s1), isJapanese(s2)
-	if isJp1 != isJp2 { return isJp1 }; return s1 < s2
+
+// parseReceiptResponse はAIの応答テキストから各項目を読み取る
+func (h *Household) parseReceiptResponse(text string) ReceiptAnalysis {
+	return receipt.ParseResponse(text, h.enhancePaymentMethod)
 }
-func downloadImage(url string) (string, error) {
-	response, err := http.Get(url)
-	if err != nil {
-		return "", NewBotError(ErrorTypeNetwork, "画像URLへのHTTPリクエストに失敗", err).
-			WithContext("url", url)
-	}
-	defer response.Body.Close()
 
-	os.MkdirAll(tempImageDir, 0755)
+// runReceiptAnalysis は画像のダウンロードからAI解析・パースまでを1回実行する
+func runReceiptAnalysis(state *TransactionState) (ReceiptAnalysis, error) {
+	var analysisResult ReceiptAnalysis
+	m := state.Message
+	h := state.Household
 
-	filePath := filepath.Join(tempImageDir, filepath.Base(response.Request.URL.Path))
-	file, err := os.Create(filePath)
-	if err != nil {
-		return "", NewBotError(ErrorTypeFileIO, "一時画像ファイルの作成に失敗", err).
-			WithContext("file_path", filePath)
-	}
-	defer file.Close()
+	mu.Lock()
+	attachments := append([]*discordgo.MessageAttachment(nil), state.Attachments...)
+	stitched := state.Stitched
+	archivedKey := state.ReceiptKey
+	mu.Unlock()
 
-	_, err = io.Copy(file, response.Body)
-	if err != nil {
-		return "", NewBotError(ErrorTypeFileIO, "画像データの書き込みに失敗", err).
-			WithContext("file_path", filePath)
+	var receiptKeys []string
+	var images []PreparedImage
+	var imgPath string
+	if archivedKey != "" {
+		// ダウンロード済みの画像はアーカイブから読み込む（再起動後や添付ファイルのURLが期限切れになった後の再試行のため）
+		var err error
+		receiptKeys, images, imgPath, err = loadArchivedReceiptImages(archivedKey)
+		if err != nil {
+			HandleError(err, nil)
+			return analysisResult, err
+		}
+		attachments = nil
 	}
+	for _, attachment := range attachments {
+		// 1. 画像をダウンロード
+		receiptKey, path, err := downloadImage(attachment.URL)
+		if err != nil {
+			log.Printf("画像ダウンロード失敗: %v", err)
+			return analysisResult, NewBotError(ErrorTypeNetwork, "画像のダウンロードに失敗しました", err)
+		}
+		if imgPath == "" {
+			imgPath = path
+		}
+		receiptKeys = append(receiptKeys, receiptKey)
 
-	return filePath, nil
-}
+		// 2. 実際の形式を判定し、AIに送れる画像に変換する（HEIC・PDFの変換、大きな写真の縮小）
+		imgData, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("画像読み込み失敗: %v", err)
+			return analysisResult, NewBotError(ErrorTypeFileIO, "画像の読み込みに失敗しました", err)
+		}
+		prepared, err := prepareReceiptImages(imgData, attachment.ContentType)
+		if err != nil {
+			HandleError(err, nil)
+			return analysisResult, err
+		}
+		images = append(images, prepared...)
+	}
+	if len(receiptKeys) == 0 {
+		return analysisResult, NewBotError(ErrorTypeValidation, "解析対象の画像がありません", nil)
+	}
 
-// analyzeReceiptInBackground は、バックグラウンドで画像解析を実行する
-func analyzeReceiptInBackground(s *discordgo.Session, m *discordgo.MessageCreate, state *TransactionState) {
-	// 1. 画像をダウンロード
-	imgPath, err := downloadImage(m.Attachments[0].URL)
-	if err != nil {
-		log.Printf("画像ダウンロード失敗: %v", err)
-		close(state.AIResultChan)
-		return
+	// 分割撮影した続きの画像は先頭の画像に紐付けて保存し、支出からまとめて参照できるようにする
+	if len(receiptKeys) > 1 {
+		setReceiptContinuations(receiptKeys[0], receiptKeys[1:])
 	}
+	mu.Lock()
 	state.ImagePath = imgPath
+	state.ReceiptKey = receiptKeys[0]
+	mu.Unlock()
 
-	// 2. AIに画像解析を依頼
-	imgData, err := os.ReadFile(imgPath)
+	// 3. AIに画像解析を依頼
+	promptText, promptVersion, err := renderPrompt(receipt.ReceiptPrompt, receipt.NewReceiptPromptData(h.categoryNames(), len(images), stitched))
 	if err != nil {
-		log.Printf("画像読み込み失敗: %v", err)
-		close(state.AIResultChan)
-		return
+		return analysisResult, err
 	}
-
-	prompt := genai.Text(`あなたはレシート情報抽出アシスタントです。
-添付されたレシート画像から、以下の情報を指定されたフォーマットで正確に書き出してください。
-
-日付: [yyyy/mm/dd形式または省略形式]
-金額: [金額（整数または小数）]
-支払い方法: [レシートに記載されている実際の支払い方法]
-カテゴリー: [御飯代/交通費/その他のカテゴリー]
-グループ: [グループ名またはnull]
-ユーザー: [ユーザー名]
-詳細: [店舗名や購入商品の詳細情報]
-
-**重要ルール：**
-1. 日付はyyyy/mm/dd形式で記載してください。ただし、月や日が一桁の場合は0を省略してもよい（例: 2025/8/19 や 2025-8-19）
-2. 金額は整数または小数で記載してください（円マークは不要）
-3. 支払い方法は画像に表示されている実際の方法を正確に記載してください：
-	  - クレジットカードの場合：「クレジットカード」または具体的なカード名
-	  - 電子マネー/QR決済：「楽天ペイ」「PayPay」「QuicPay」「iD」「Suica」など実際の名称
-	  - 現金の場合：「現金」
-	  - その他：実際に表示されている支払い方法名
-4. グループに該当する情報がない場合は「null」と記載してください
-5. ユーザーは基本的に「自分」としてください
-6. 詳細には店舗名や購入した商品名を含めてください
-7. 見えない・読み取れない部分は「不明」と記載してください`)
+	prompt := genai.Text(promptText)
 	
-	ctx := context.Background()
-	resp, err := geminiClient.GenerateContent(ctx, genai.ImageData("png", imgData), prompt)
-	if err != nil {
-		botErr := NewBotError(ErrorTypeAIService, "Gemini APIレシート解析エラー", err).
-			WithContext("user_id", m.Author.ID).
-			WithContext("image_path", imgPath)
-		LogBotError(botErr)
-		close(state.AIResultChan)
-		return
+	parts := make([]genai.Part, 0, len(images)+1)
+	for _, img := range images {
+		parts = append(parts, genai.ImageData(img.Format(), img.Data))
 	}
+	parts = append(parts, prompt)
 
-	// 3. 結果をパースしてチャネルに送信
-	var analysisResult ReceiptAnalysis
-	jsonStr := string(resp.Candidates[0].Content.Parts[0].(genai.Text))
-	
-	// JSONパース処理を実装
-	log.Printf("Gemini API応答: %s", jsonStr)
-	
-	// 簡易的なパース（実際のレスポンス形式に応じて調整が必要）
-	lines := strings.Split(jsonStr, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "日付:") {
-			dateStr := strings.TrimSpace(strings.Split(line, ":")[1])
-			if dateStr != "" && dateStr != "不明" {
-				analysisResult.Date = &dateStr
-			}
-		}
-		if strings.Contains(line, "金額:") {
-			amountStr := strings.TrimSpace(strings.Split(line, ":")[1])
-			if amount, err := strconv.Atoi(amountStr); err == nil {
-				analysisResult.TotalAmount = &amount
+	// 同じ画像・モデル・プロンプトの応答がキャッシュにあればAPIを呼び出さない（再試行・再投稿時）
+	cacheKey := receiptCacheKey(images, promptVersion, promptText)
+	jsonStr, cached := lookupAIResponse(cacheKey)
+	var modelName string
+	if !cached {
+		ctx := context.Background()
+		resp, answeredModel, err := geminiClient.GenerateContent(ctx, parts...)
+		if err != nil {
+			message := "Gemini APIレシート解析エラー"
+			if description := describeAIError(err); description != "" {
+				message = description
 			}
+			botErr := NewBotError(ErrorTypeAIService, message, err).
+				WithContext("user_id", m.Author.ID).
+				WithContext("image_path", imgPath)
+			LogBotError(botErr)
+			return analysisResult, botErr
 		}
-		if strings.Contains(line, "支払い方法:") {
-			paymentStr := strings.TrimSpace(strings.Split(line, ":")[1])
-			if paymentStr != "" && paymentStr != "不明" {
-				// クレジット系の場合、より詳細な分類を試みる
-				enhancedPaymentMethod := enhancePaymentMethod(paymentStr)
-				analysisResult.PaymentMethod = &enhancedPaymentMethod
-			}
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+			return analysisResult, NewBotError(ErrorTypeAIService, "AIから解析結果が返されませんでした", nil)
 		}
-		if strings.Contains(line, "詳細:") {
-			itemsStr := strings.TrimSpace(strings.Split(line, ":")[1])
-			if itemsStr != "" && itemsStr != "不明" {
-				analysisResult.Items = &itemsStr
-			}
+		jsonStr = string(resp.Candidates[0].Content.Parts[0].(genai.Text))
+		modelName = answeredModel
+	}
+
+	// 4. 結果をパース
+	// JSONパース処理を実装
+	log.Printf("Gemini API応答 (cache=%t, model=%s): %s", cached, modelName, jsonStr)
+	
+	analysisResult = h.parseReceiptResponse(jsonStr)
+	analysisResult.PromptVersions = []string{promptVersion}
+	if stitched && len(images) > 1 {
+		// 画像ごとの商品リストをつなぎ目の重複を除いて1つにまとめ、全画像分を含むAIの商品リストと置き換える
+		// （AIの商品リストに足すと、同じ商品が2回ずつ並ぶ）
+		if items := mergeItemsAtSeams(parseStitchedItems(jsonStr)); len(items) > 0 {
+			itemsStr := strings.Join(items, "、")
+			analysisResult.Items = &itemsStr
 		}
 	}
-	
-	// レシート判定：日付と金額が解析できた場合にtrue
-	analysisResult.IsReceipt = (analysisResult.Date != nil && analysisResult.TotalAmount != nil)
-	
+
 	log.Printf("解析結果: IsReceipt=%t, Date=%v, Amount=%v",
 		analysisResult.IsReceipt, analysisResult.Date, analysisResult.TotalAmount)
 	
-	state.AIResultChan <- analysisResult
+	// 金額が読み取れない場合は解析失敗として扱う（読み取れた部分は手入力で続行する際に使う）
+	if analysisResult.TotalAmount == nil {
+		return analysisResult, NewBotError(ErrorTypeAIService, "合計金額を読み取れませんでした", nil)
+	}
+
+	// 読み取れた応答だけをキャッシュする（失敗した応答を残すと再試行しても同じ結果になるため）
+	if !cached {
+		storeAIResponse(cacheKey, modelName, promptVersion, jsonStr)
+	}
+
+	return analysisResult, nil
 }
 
 // enhancePaymentMethod は支払い方法をより詳細に分類する
-func enhancePaymentMethod(originalPaymentMethod string) string {
+func (h *Household) enhancePaymentMethod(originalPaymentMethod string) string {
 	paymentLower := strings.ToLower(originalPaymentMethod)
 	
 	// クレジット系の場合、マスターデータから最適なマッチを探す
@@ -2033,7 +2798,7 @@ func enhancePaymentMethod(originalPaymentMethod string) string {
 		strings.Contains(paymentLower, "カード") || strings.Contains(paymentLower, "card") {
 		
 		// マスターデータから最適なカード系支払い方法を探す
-		cardPayments := getCardPaymentOptions()
+		cardPayments := h.getCardPaymentOptions()
 		
 		// 完全一致を優先
 		for _, option := range cardPayments {
@@ -2067,12 +2832,6 @@ func main() {
 	err := godotenv.Load()
 	if err != nil { log.Println("Note: .env file not found, continuing without it.") }
 
-	targetChannelID = os.Getenv("CHANNEL_ID")
-	if targetChannelID == "" {
-		botErr := NewBotError(ErrorTypeConfiguration, "CHANNEL_ID環境変数が設定されていません", nil)
-		LogBotError(botErr)
-		log.Fatal("CHANNEL_ID must be set in the .env file")
-	}
 	botToken := os.Getenv("TOKEN")
 	if botToken == "" {
 		botErr := NewBotError(ErrorTypeConfiguration, "TOKEN環境変数が設定されていません", nil)
@@ -2086,20 +2845,21 @@ func main() {
 		log.Fatal("GEMINI_API_KEY must be set in the .env file")
 	}
 
-	dumpFilePath := "/home/ubuntu/Bot/discord/yarikuri/dump_local_db/master_data_dump.sql"
-	if err := loadMasterData(dumpFilePath); err != nil {
+	// 家計ごとのマスターデータ・詳細説明サンプル・学習データ・ルールを読み込み
+	// （households.jsonがない場合はCHANNEL_IDの1件のみ）
+	if err := loadHouseholds(); err != nil {
 		if botErr, ok := err.(*BotError); ok {
 			LogBotError(botErr)
 		}
-		log.Fatalf("マスターデータの読み込みに失敗しました: %v", err)
+		log.Fatalf("家計の読み込みに失敗しました: %v", err)
 	}
 
-	// 詳細説明サンプルを読み込み
-	if err := loadDetailSamples(detailSamplesDir); err != nil {
+	// プロンプトテンプレートを読み込み
+	if err := loadPromptTemplates(); err != nil {
 		if botErr, ok := err.(*BotError); ok {
 			LogBotError(botErr)
 		}
-		log.Fatalf("詳細説明サンプルの読み込みに失敗しました: %v", err)
+		log.Fatalf("プロンプトテンプレートの読み込みに失敗しました: %v", err)
 	}
 
 	ctx := context.Background()
@@ -2110,21 +2870,27 @@ func main() {
 		LogBotError(botErr)
 		log.Fatal(err)
 	}
-	geminiClient = client.GenerativeModel("gemini-1.5-flash-latest")
+	geminiClient = newGeminiModel(client)
 	log.Println("Gemini APIクライアントの初期化が完了しました。")
 
 	transactions = make(map[string]*TransactionState)
 	confirmationData = make(map[string]*ConfirmationData)
-	masterDataQueues = make(map[string][]MasterQueueItem)
 
-	// マスターキューファイルを読み込み
-	err = loadMasterQueueFromFile()
-	if err != nil {
-		botErr := NewBotError(ErrorTypeFileIO, "マスターキューファイル読み込みエラー", err).
-			WithContext("file_path", queueFilePath)
-		LogBotError(botErr)
+	// レシート画像アーカイブを読み込み、期限切れ画像の定期削除を開始
+	if err := loadReceiptArchive(); err != nil {
+		HandleError(err, nil)
+	}
+	startReceiptArchiveCleanup()
+
+	// AIが利用できず保留していた解析を読み込み（アーカイブの読み込み後に行う）
+	if err := loadPendingAnalyses(); err != nil {
+		HandleError(err, nil)
+	}
+
+	// AI応答のキャッシュを読み込み
+	if err := loadAIResponseCache(); err != nil {
+		HandleError(err, nil)
 	}
-	masterDataQueues = make(map[string][]MasterQueueItem)
 
 	dg, err := discordgo.New("Bot " + botToken)
 	if err != nil {
@@ -2133,21 +2899,29 @@ func main() {
 		LogBotError(botErr)
 		log.Fatalf("Error creating Discord session: %v", err)
 	}
+	setAINoticeSession(dg)
 
 	dg.AddHandler(messageCreate)
 	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
 		log.Printf("Logged in as: %v#%v", s.State.User.Username, s.State.User.Discriminator)
 		log.Println("スラッシュコマンドを登録しています...")
-		registeredCommands, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", commands)
-		if err != nil {
-			botErr := NewBotError(ErrorTypeDiscordAPI, "スラッシュコマンドの登録に失敗", err).
-				WithContext("commands_count", len(commands))
-			LogBotError(botErr)
-		} else {
-			log.Printf("%d個のコマンドを登録しました。", len(registeredCommands))
-		}
+		registerHouseholdCommands(s)
 	})
 	dg.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		// 家計として登録されていないサーバー・チャンネルからの操作は受け付けない
+		h := householdForInteraction(i)
+		if h == nil {
+			respondUnknownHousehold(s, i)
+			return
+		}
+		// 閲覧者・記録者・管理者の権限と、確認画面の投稿者を確認する
+		if !authorizeInteraction(s, h, i) {
+			return
+		}
+		// 登録処理中・登録済みの確認画面は操作できない
+		if rejectLockedEntry(s, i) {
+			return
+		}
 		switch i.Type {
 		case discordgo.InteractionApplicationCommand:
 			if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
@@ -2159,6 +2933,12 @@ func main() {
 				handlePagination(s, i)
 			} else if strings.HasPrefix(customID, "receipt_info_button:") {
 				handleReceiptInfoButton(s, i)
+			} else if strings.HasPrefix(customID, "receipt_stitch_done:") {
+				handleStitchDone(s, i)
+			} else if strings.HasPrefix(customID, "receipt_retry:") {
+				handleReceiptRetry(s, i)
+			} else if strings.HasPrefix(customID, "receipt_manual:") {
+				handleReceiptManual(s, i)
 			} else if strings.HasPrefix(customID, "category_select:") {
 				handleCategorySelect(s, i)
 			} else if strings.HasPrefix(customID, "category_search:") {
@@ -2177,6 +2957,12 @@ func main() {
 				handleEditDetail(s, i)
 			} else if strings.HasPrefix(customID, "add_to_queue:") {
 				handleAddToQueue(s, i)
+			} else if strings.HasPrefix(customID, "confirm_field:") {
+				handleConfirmField(s, i)
+			} else if strings.HasPrefix(customID, "force_add_to_queue:") {
+				handleForceAddToQueue(s, i)
+			} else if strings.HasPrefix(customID, "show_duplicates:") {
+				handleShowDuplicates(s, i)
 			} else if strings.HasPrefix(customID, "cancel_entry:") {
 				handleCancelEntry(s, i)
 			} else if strings.HasPrefix(customID, "group_select:") {
@@ -2195,6 +2981,8 @@ func main() {
 				handleSkipRemaining(s, i)
 			} else if strings.HasPrefix(customID, "add_remaining_to_queue:") {
 				handleAddRemainingToQueue(s, i)
+			} else if strings.HasPrefix(customID, "undo_expense:") {
+				handleUndoButton(s, i)
 			}
 		case discordgo.InteractionModalSubmit:
 			customID := i.ModalSubmitData().CustomID
@@ -2215,11 +3003,15 @@ func main() {
 		}
 	})
 
-	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages
+	// 1行のテキストからの支出登録はメッセージ本文を読むため、Message Content Intentが必要
+	// （Developer Portalの「Privileged Gateway Intents」でMESSAGE CONTENT INTENTを有効にしておくこと）
+	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
 
 	err = dg.Open()
 	if err != nil { log.Fatalf("Error opening connection: %v", err) }
 	defer dg.Close()
+	startPendingAnalysisWorker(dg)
+	startRecurringScheduler(dg)
 
 	log.Println("Bot is now running. Press CTRL+C to exit.")
 
@@ -2234,15 +3026,19 @@ func main() {
 
 // updateConfirmationDisplay は確認画面を更新する
 func updateConfirmationDisplay(s *discordgo.Session, messageID string) {
+	// 日付や金額の編集で重複の有無が変わるため再判定する
+	refreshConfirmationDuplicates(messageID)
+	
 	data := getConfirmationData(messageID)
 	if data == nil {
 		log.Printf("確認データが見つかりません: %s", messageID)
 		return
 	}
+	h := data.Household
 	
 	// カテゴリー名を取得
 	var categoryName string = "不明"
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		if category.ID == data.CategoryID {
 			categoryName = category.Name
 			break
@@ -2252,7 +3048,7 @@ func updateConfirmationDisplay(s *discordgo.Session, messageID string) {
 	// グループ名を取得
 	var groupName string = "なし"
 	if data.GroupID != nil {
-		for _, group := range masterGroups {
+		for _, group := range h.masterGroups {
 			if group.ID == *data.GroupID {
 				groupName = group.Name
 				break
@@ -2262,7 +3058,7 @@ func updateConfirmationDisplay(s *discordgo.Session, messageID string) {
 	
 	// ユーザー名を取得
 	var userName string = "不明"
-	for _, user := range masterUsers {
+	for _, user := range h.masterUsers {
 		if user.ID == data.UserID {
 			userName = user.Name
 			break
@@ -2274,9 +3070,9 @@ func updateConfirmationDisplay(s *discordgo.Session, messageID string) {
 		Title: "📋 キューに追加前の確認 (更新済み)",
 		Color: 0x00ff00,
 		Fields: []*discordgo.MessageEmbedField{
-			{Name: "📅 日付", Value: data.Date, Inline: true},
-			{Name: "💵 金額", Value: fmt.Sprintf("¥%d", data.Amount), Inline: true},
-			{Name: "💳 支払い方法", Value: data.PaymentMethod, Inline: true},
+			{Name: confidenceFieldName(data, confidenceFieldDate, "📅 日付"), Value: data.Date, Inline: true},
+			{Name: confidenceFieldName(data, confidenceFieldAmount, "💵 金額"), Value: fmt.Sprintf("¥%d", data.Amount), Inline: true},
+			{Name: confidenceFieldName(data, confidenceFieldPayment, "💳 支払い方法"), Value: data.PaymentMethod, Inline: true},
 			{Name: "📂 カテゴリー", Value: categoryName, Inline: true},
 			{Name: "🏷️ グループ", Value: groupName, Inline: true},
 			{Name: "👤 支払者", Value: userName, Inline: true},
@@ -2286,65 +3082,24 @@ func updateConfirmationDisplay(s *discordgo.Session, messageID string) {
 			Text: "✅ データが更新されました。各項目を編集できます。問題なければ「キューに追加」をクリックしてください。",
 		},
 	}
+	if uncertain := uncertainFieldsField(data); uncertain != nil {
+		embed.Fields = append(embed.Fields, uncertain)
+	}
+	if fired := appliedRulesField(data); fired != nil {
+		embed.Fields = append(embed.Fields, fired)
+	}
+	if learned := storeHistoryField(data); learned != nil {
+		embed.Fields = append(embed.Fields, learned)
+	}
+	if warning := duplicateWarningField(data); warning != nil {
+		embed.Fields = append(embed.Fields, warning)
+	}
 	
 	// 編集ボタンを作成
-	components := []discordgo.MessageComponent{
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.Button{
-					CustomID: fmt.Sprintf("edit_date:%s", messageID),
-					Label:    "📅 日付を編集",
-					Style:    discordgo.SecondaryButton,
-				},
-				discordgo.Button{
-					CustomID: fmt.Sprintf("edit_amount:%s", messageID),
-					Label:    "💵 金額を編集",
-					Style:    discordgo.SecondaryButton,
-				},
-				discordgo.Button{
-					CustomID: fmt.Sprintf("edit_payment:%s", messageID),
-					Label:    "💳 支払い方法を編集",
-					Style:    discordgo.SecondaryButton,
-				},
-			},
-		},
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.Button{
-					CustomID: fmt.Sprintf("edit_group:%s", messageID),
-					Label:    "🏷️ グループを編集",
-					Style:    discordgo.SecondaryButton,
-				},
-				discordgo.Button{
-					CustomID: fmt.Sprintf("edit_payer:%s", messageID),
-					Label:    "👤 支払者を編集",
-					Style:    discordgo.SecondaryButton,
-				},
-				discordgo.Button{
-					CustomID: fmt.Sprintf("edit_detail:%s", messageID),
-					Label:    "📝 詳細を編集",
-					Style:    discordgo.SecondaryButton,
-				},
-			},
-		},
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.Button{
-					CustomID: fmt.Sprintf("add_to_queue:%s", messageID),
-					Label:    "✅ キューに追加",
-					Style:    discordgo.SuccessButton,
-				},
-				discordgo.Button{
-					CustomID: fmt.Sprintf("cancel_entry:%s", messageID),
-					Label:    "❌ キャンセル",
-					Style:    discordgo.DangerButton,
-				},
-			},
-		},
-	}
+	components := buildConfirmationComponents(messageID, data)
 	
 	// 新しいメッセージを送信（更新済み確認画面）
-	_, err := s.ChannelMessageSendComplex(targetChannelID, &discordgo.MessageSend{
+	_, err := s.ChannelMessageSendComplex(h.ChannelID, &discordgo.MessageSend{
 		Embeds:     []*discordgo.MessageEmbed{embed},
 		Components: components,
 	})
@@ -2361,13 +3116,14 @@ func updateConfirmationDisplay(s *discordgo.Session, messageID string) {
 
 // handleGroupSelect はグループ選択を処理する
 func handleGroupSelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "group_select:")
 	
 	selectedValue := i.MessageComponentData().Values[0]
 	
 	// データを更新
-	updateConfirmationData(messageID, func(data *ConfirmationData) {
+	editConfirmationData(i, messageID, func(data *ConfirmationData) {
 		if selectedValue == "none" {
 			data.GroupID = nil
 		} else {
@@ -2381,7 +3137,7 @@ func handleGroupSelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	var groupName string = "なし"
 	if selectedValue != "none" {
 		if groupID, err := strconv.Atoi(selectedValue); err == nil {
-			for _, group := range masterGroups {
+			for _, group := range h.masterGroups {
 				if group.ID == groupID {
 					groupName = group.Name
 					break
@@ -2407,6 +3163,7 @@ func handleGroupSelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
 
 // handlePayerSelect は支払者選択を処理する
 func handlePayerSelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "payer_select:")
 	
@@ -2414,7 +3171,7 @@ func handlePayerSelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	
 	// データを更新
 	if userID, err := strconv.Atoi(selectedValue); err == nil {
-		updateConfirmationData(messageID, func(data *ConfirmationData) {
+		editConfirmationData(i, messageID, func(data *ConfirmationData) {
 			data.UserID = userID
 		})
 	}
@@ -2422,7 +3179,7 @@ func handlePayerSelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// ユーザー名を取得
 	var userName string = "不明"
 	if userID, err := strconv.Atoi(selectedValue); err == nil {
-		for _, user := range masterUsers {
+		for _, user := range h.masterUsers {
 			if user.ID == userID {
 				userName = user.Name
 				break
@@ -2453,8 +3210,9 @@ func handleCreditDetailSelect(s *discordgo.Session, i *discordgo.InteractionCrea
 	selectedPaymentMethod := i.MessageComponentData().Values[0]
 	
 	// データを更新
-	updateConfirmationData(messageID, func(data *ConfirmationData) {
+	editConfirmationData(i, messageID, func(data *ConfirmationData) {
 		data.PaymentMethod = selectedPaymentMethod
+		resolveUncertainField(data, confidenceFieldPayment)
 	})
 	
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -2501,6 +3259,33 @@ func handleAddToQueue(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "add_to_queue:")
 	
+	addConfirmationToQueue(s, i, messageID)
+}
+
+// newConfirmationExpense は確認データからキューに追加するExpenseを作る（dateはISO形式）
+// 支払い方法（ルールで設定したものを含む）はマスターデータにある場合に支払いIDとして保存する
+func (h *Household) newConfirmationExpense(data *ConfirmationData, date string) Expense {
+	return Expense{
+		ID:            generateUniqueID(),
+		Date:          date,
+		Price:         data.Amount,
+		CategoryID:    data.CategoryID,
+		UserID:        data.UserID,
+		Detail:        data.Detail,
+		GroupID:       data.GroupID,
+		PaymentID:     h.paymentIDByName(data.PaymentMethod),
+		ReceiptKey:    data.ReceiptKey,
+		PromptVersion: strings.Join(data.AIResult.PromptVersions, ","),
+	}
+}
+
+// addConfirmationToQueue は確認データをExpenseとしてキューに保存する
+func addConfirmationToQueue(s *discordgo.Session, i *discordgo.InteractionCreate, messageID string) {
+	h := householdForInteraction(i)
+	// 連打された場合も1件だけ追加されるよう、確認画面ごとに順番に処理する
+	unlock := lockEntry(messageID)
+	defer unlock()
+
 	data := getConfirmationData(messageID)
 	if data == nil {
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -2512,56 +3297,101 @@ func handleAddToQueue(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		})
 		return
 	}
+	// 先に押された分で登録済みになっている場合は何もしない
+	if notice := describeConfirmationStatus(messageID); notice != "" {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: notice,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
 	
-	// 総額チェック：入力金額が総額より少ない場合は分割処理
-	originalAmount := data.Amount
-	if data.AIResult.TotalAmount != nil && *data.AIResult.TotalAmount > 0 {
-		originalAmount = *data.AIResult.TotalAmount
+	// AIの読み取りが不確かな項目は確認済みになるまで追加しない
+	if len(data.UncertainFields) > 0 {
+		var labels []string
+		for _, field := range confidenceFieldOrder {
+			if _, flagged := data.UncertainFields[field]; flagged {
+				labels = append(labels, confidenceFieldLabels[field])
+			}
+		}
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("⚠️ %s の確認が済んでいません。確認画面の「正しい」ボタンを押すか、編集してください。", strings.Join(labels, "・")),
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
 	}
 	
-	// Expenseデータを作成
-	expense := Expense{
-		Date:       data.Date,
-		Price:      data.Amount,
-		CategoryID: data.CategoryID,
-		UserID:     data.UserID,
-		Detail:     data.Detail,
-		GroupID:    data.GroupID,
+	// 重複候補がある場合は「それでも追加」による確認を必須にする
+	if len(data.Duplicates) > 0 && !data.ForceAdd {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "⚠️ キュー内または同期済みのデータに重複の可能性があるものがあります。「既存を表示」で確認し、追加する場合は「それでも追加」をクリックしてください。",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
 	}
 	
-	// Expenseキューファイルに保存
-	err := saveExpenseToQueue(expense)
+	// 日付はISO形式に揃えてから保存する
+	expenseDate, err := normalizeDate(data.Date, nowInTokyo())
 	if err != nil {
-		botErr := NewBotError(ErrorTypeFileIO, "Expenseキューファイル保存エラー", err).
-			WithContext("expense", fmt.Sprintf("%+v", expense))
-		LogBotError(botErr)
-		
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
-				Content: "❌ エラー: キューへの保存に失敗しました。",
+				Content: describeDateError(data.Date, err) + "\n「日付を編集」から修正してください。",
 				Flags:   discordgo.MessageFlagsEphemeral,
 			},
 		})
 		return
 	}
 	
-	log.Printf("キューに追加: %+v", expense)
+	// 総額チェック：入力金額が総額より少ない場合は分割処理
+	originalAmount := data.Amount
+	if data.AIResult.TotalAmount != nil && *data.AIResult.TotalAmount > 0 {
+		originalAmount = *data.AIResult.TotalAmount
+	}
+	
+	expense := h.newConfirmationExpense(data, expenseDate)
+	if !h.submitConfirmation(s, i, messageID, expense) {
+		return
+	}
+	
+	// 次回同じ店舗のレシートで分類を自動選択できるよう学習する（残額分は用途が異なるため除く）
+	if !data.IsPartialEntry {
+		h.recordStoreChoice(receiptStoreName(data.AIResult), StoreChoice{
+			CategoryID:    data.CategoryID,
+			GroupID:       data.GroupID,
+			PaymentMethod: data.PaymentMethod,
+		})
+	}
+	h.recordConfirmedDetail(data.CategoryID, data.Detail, data.DetailEdited)
 	
-	// 分割処理チェック
+	// 分割処理チェック（返金などマイナスの金額は分割しない）
 	remainingAmount := originalAmount - data.Amount
-	if remainingAmount > 0 {
+	if data.Amount > 0 && remainingAmount > 0 {
 		// 残額がある場合、次のエントリ作成を促す
 		handlePartialAmountEntry(s, i, messageID, data, remainingAmount, originalAmount)
 		return
 	}
 	
-	// 通常の完了処理
+	// 通常の完了処理（予算が設定されたカテゴリーは今月の消化状況も表示する）
+	content := fmt.Sprintf("✅ データをキューに追加しました。(ID: `%s`)", expense.ID)
+	if status := h.budgetStatus(expense.CategoryID, expense.Date); status != "" {
+		content += "\n" + status
+	}
 	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: "✅ データをキューに追加しました。",
-			Flags:   discordgo.MessageFlagsEphemeral,
+			Content:    content,
+			Flags:      discordgo.MessageFlagsEphemeral,
+			Components: undoButton(expense.ID),
 		},
 	})
 	if err != nil {
@@ -2570,22 +3400,20 @@ func handleAddToQueue(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		LogBotError(botErr)
 	}
 
-	// 確認データを削除
-	mu.Lock()
-	delete(confirmationData, messageID)
-	mu.Unlock()
-	
 	log.Printf("キュー追加完了: messageID=%s", messageID)
 }
 
 // handlePartialAmountEntry は残額がある場合の次のエントリ作成を処理する
 func handlePartialAmountEntry(s *discordgo.Session, i *discordgo.InteractionCreate, messageID string, originalData *ConfirmationData, remainingAmount, totalAmount int) {
+	h := householdForInteraction(i)
 	// 新しいメッセージIDを生成
 	newMessageID := generateUniqueID()
 	
 	// 残額用の新しい確認データを作成
 	newData := &ConfirmationData{
 		MessageID:       newMessageID,
+		Household:       originalData.Household,
+		PosterID:        originalData.PosterID,
 		Date:            originalData.Date,
 		Amount:          remainingAmount,
 		CategoryID:      1, // デフォルトカテゴリー
@@ -2598,6 +3426,7 @@ func handlePartialAmountEntry(s *discordgo.Session, i *discordgo.InteractionCrea
 		RemainingAmount: &remainingAmount,
 		IsPartialEntry:  true,
 		ParentMessageID: &messageID,
+		ReceiptKey:      originalData.ReceiptKey,
 	}
 	
 	// 新しい確認データを保存
@@ -2620,7 +3449,7 @@ func handlePartialAmountEntry(s *discordgo.Session, i *discordgo.InteractionCrea
 	
 	// カテゴリー選択用のSelectMenuオプションを準備（最初の25件）
 	var categoryOptions []discordgo.SelectMenuOption
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		if len(categoryOptions) >= 25 {
 			break
 		}
@@ -2681,61 +3510,97 @@ func storeConfirmationDataDirect(messageID string, data *ConfirmationData) {
 	confirmationData[messageID] = data
 }
 
-// saveExpenseToQueue はExpenseをキューファイルに保存する
-func saveExpenseToQueue(expense Expense) error {
-	const expenseQueueFile = "../queues/expense_queue.json"
-	
-	// 既存のExpenseキューを読み込み
+// loadExpenseQueue はExpenseキューファイルを読み込む（ファイルがなければ空のキュー）
+func (h *Household) loadExpenseQueue() ([]Expense, error) {
 	var expenseQueue []Expense
-	data, err := os.ReadFile(expenseQueueFile)
+	data, err := os.ReadFile(h.expenseQueuePath())
 	if err != nil {
 		if !os.IsNotExist(err) {
-			return NewBotError(ErrorTypeFileIO, "Expenseキューファイル読み込みエラー", err).
-				WithContext("file_path", expenseQueueFile)
+			return nil, NewBotError(ErrorTypeFileIO, "Expenseキューファイル読み込みエラー", err).
+				WithContext("file_path", h.expenseQueuePath())
 		}
 		// ファイルが存在しない場合は空のキューで開始
-		expenseQueue = []Expense{}
-	} else {
-		// 既存データをパース
-		if err := json.Unmarshal(data, &expenseQueue); err != nil {
-			return NewBotError(ErrorTypeFileIO, "ExpenseキューJSONパースエラー", err).
-				WithContext("file_path", expenseQueueFile)
-		}
+		return []Expense{}, nil
 	}
-	
-	// 新しいExpenseを追加
-	expenseQueue = append(expenseQueue, expense)
-	
-	// ファイルに保存
+
+	// 既存データをパース
+	if err := json.Unmarshal(data, &expenseQueue); err != nil {
+		return nil, NewBotError(ErrorTypeFileIO, "ExpenseキューJSONパースエラー", err).
+			WithContext("file_path", h.expenseQueuePath())
+	}
+	return expenseQueue, nil
+}
+
+// writeExpenseQueue はExpenseキュー全体をファイルに書き込む
+func (h *Household) writeExpenseQueue(expenseQueue []Expense) error {
 	updatedData, err := json.MarshalIndent(expenseQueue, "", "  ")
 	if err != nil {
 		return NewBotError(ErrorTypeFileIO, "ExpenseキューJSON生成エラー", err).
 			WithContext("queue_length", len(expenseQueue))
 	}
-	
-	err = os.WriteFile(expenseQueueFile, updatedData, 0644)
+
+	err = os.WriteFile(h.expenseQueuePath(), updatedData, 0644)
 	if err != nil {
 		return NewBotError(ErrorTypeFileIO, "Expenseキューファイル書き込みエラー", err).
-			WithContext("file_path", expenseQueueFile)
+			WithContext("file_path", h.expenseQueuePath())
 	}
-	
-	log.Printf("Expenseキューに追加完了: %s (total: %d件)", expenseQueueFile, len(expenseQueue))
 	return nil
 }
 
+// saveExpenseToQueue はExpenseをキューファイルに保存する
+func (h *Household) saveExpenseToQueue(expense Expense, actorID string) error {
+	h.expenseQueueMutex.Lock()
+	defer h.expenseQueueMutex.Unlock()
+
+	// 既存のExpenseキューを読み込み
+	expenseQueue, err := h.loadExpenseQueue()
+	if err != nil {
+		return err
+	}
+
+	// 新しいExpenseを追加
+	expenseQueue = append(expenseQueue, expense)
+
+	// ファイルに保存
+	if err := h.writeExpenseQueue(expenseQueue); err != nil {
+		return err
+	}
+	h.recordQueueOperationLocked(actorID, expense)
+
+	log.Printf("Expenseキューに追加完了: %s (total: %d件)", h.expenseQueuePath(), len(expenseQueue))
+	return nil
+}
+
+// findExpenseByID はIDでキュー内のExpenseを検索する（見つからなければnil）
+func (h *Household) findExpenseByID(expenseID string) (*Expense, error) {
+	h.expenseQueueMutex.Lock()
+	defer h.expenseQueueMutex.Unlock()
+
+	expenseQueue, err := h.loadExpenseQueue()
+	if err != nil {
+		return nil, err
+	}
+	for idx := range expenseQueue {
+		if expenseQueue[idx].ID == expenseID {
+			return &expenseQueue[idx], nil
+		}
+	}
+	return nil, nil
+}
+
 // getMasterDataWithQueue は既存マスターデータ + キューを結合して返す
-func getMasterDataWithQueue(masterType string) interface{} {
-		masterQueueMutex.RLock()
-		queueItems := masterDataQueues[masterType]
-		masterQueueMutex.RUnlock()
+func (h *Household) getMasterDataWithQueue(masterType string) interface{} {
+		h.masterQueueMutex.RLock()
+		queueItems := h.masterDataQueues[masterType]
+		h.masterQueueMutex.RUnlock()
 		
 		switch masterType {
 		case "category":
-			result := make([]Category, len(masterCategories))
-			copy(result, masterCategories)
+			result := make([]Category, len(h.masterCategories))
+			copy(result, h.masterCategories)
 			
 			// キューからpendingアイテムを追加
-			nextID := getNextCategoryID()
+			nextID := h.getNextCategoryID()
 			for _, item := range queueItems {
 				if item.Status == "pending" {
 					result = append(result, Category{
@@ -2748,17 +3613,17 @@ func getMasterDataWithQueue(masterType string) interface{} {
 			
 			// ソート
 			sort.Slice(result, func(i, j int) bool {
-				return sortJapaneseFirst(result[i].Name, result[j].Name)
+				return receipt.SortJapaneseFirst(result[i].Name, result[j].Name)
 			})
 			
 			return result
 			
 		case "group":
-			result := make([]Group, len(masterGroups))
-			copy(result, masterGroups)
+			result := make([]Group, len(h.masterGroups))
+			copy(result, h.masterGroups)
 			
 			// キューからpendingアイテムを追加
-			nextID := getNextGroupID()
+			nextID := h.getNextGroupID()
 			for _, item := range queueItems {
 				if item.Status == "pending" {
 					result = append(result, Group{
@@ -2771,17 +3636,17 @@ func getMasterDataWithQueue(masterType string) interface{} {
 			
 			// ソート
 			sort.Slice(result, func(i, j int) bool {
-				return sortJapaneseFirst(result[i].Name, result[j].Name)
+				return receipt.SortJapaneseFirst(result[i].Name, result[j].Name)
 			})
 			
 			return result
 			
 		case "user":
-			result := make([]User, len(masterUsers))
-			copy(result, masterUsers)
+			result := make([]User, len(h.masterUsers))
+			copy(result, h.masterUsers)
 			
 			// キューからpendingアイテムを追加
-			nextID := getNextUserID()
+			nextID := h.getNextUserID()
 			for _, item := range queueItems {
 				if item.Status == "pending" {
 					result = append(result, User{
@@ -2794,17 +3659,17 @@ func getMasterDataWithQueue(masterType string) interface{} {
 			
 			// ソート
 			sort.Slice(result, func(i, j int) bool {
-				return sortJapaneseFirst(result[i].Name, result[j].Name)
+				return receipt.SortJapaneseFirst(result[i].Name, result[j].Name)
 			})
 			
 			return result
 			
 		case "payment_type":
-			result := make([]PaymentType, len(masterPaymentTypes))
-			copy(result, masterPaymentTypes)
+			result := make([]PaymentType, len(h.masterPaymentTypes))
+			copy(result, h.masterPaymentTypes)
 			
 			// キューからpendingアイテムを追加
-			nextID := getNextPaymentID()
+			nextID := h.getNextPaymentID()
 			for _, item := range queueItems {
 				if item.Status == "pending" {
 					result = append(result, PaymentType{
@@ -2818,7 +3683,7 @@ func getMasterDataWithQueue(masterType string) interface{} {
 			
 			// ソート
 			sort.Slice(result, func(i, j int) bool {
-				return sortJapaneseFirst(result[i].PayKind, result[j].PayKind)
+				return receipt.SortJapaneseFirst(result[i].PayKind, result[j].PayKind)
 			})
 			
 			return result
@@ -2828,9 +3693,9 @@ func getMasterDataWithQueue(masterType string) interface{} {
 	}
 
 // getNextCategoryID は次のカテゴリIDを取得する
-func getNextCategoryID() int {
+func (h *Household) getNextCategoryID() int {
 	maxID := 0
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		if category.ID > maxID {
 			maxID = category.ID
 		}
@@ -2839,9 +3704,9 @@ func getNextCategoryID() int {
 }
 
 // getNextGroupID は次のグループIDを取得する
-func getNextGroupID() int {
+func (h *Household) getNextGroupID() int {
 	maxID := 0
-	for _, group := range masterGroups {
+	for _, group := range h.masterGroups {
 		if group.ID > maxID {
 			maxID = group.ID
 		}
@@ -2850,9 +3715,9 @@ func getNextGroupID() int {
 }
 
 // getNextUserID は次のユーザーIDを取得する
-func getNextUserID() int {
+func (h *Household) getNextUserID() int {
 	maxID := 0
-	for _, user := range masterUsers {
+	for _, user := range h.masterUsers {
 		if user.ID > maxID {
 			maxID = user.ID
 		}
@@ -2861,9 +3726,9 @@ func getNextUserID() int {
 }
 
 // getNextPaymentID は次の支払いIDを取得する
-func getNextPaymentID() int {
+func (h *Household) getNextPaymentID() int {
 	maxID := 0
-	for _, payment := range masterPaymentTypes {
+	for _, payment := range h.masterPaymentTypes {
 		if payment.PayID > maxID {
 			maxID = payment.PayID
 		}
@@ -2877,6 +3742,7 @@ func getNextPaymentID() int {
 
 // handleAddMaster は新しいマスターデータの追加を処理する
 func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		h := householdForInteraction(i)
 		options := i.ApplicationCommandData().Options
 		masterType := options[0].StringValue()
 		name := options[1].StringValue()
@@ -2911,7 +3777,7 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		}
 		
 		// 重複チェック（既存マスター + キュー内）
-		if isDuplicateMasterData(masterType, name) {
+		if h.isDuplicateMasterData(masterType, name) {
 			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 				Type: discordgo.InteractionResponseChannelMessageWithSource,
 				Data: &discordgo.InteractionResponseData{
@@ -2925,7 +3791,7 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		// TypeName バリデーション（支払い方法の場合）
 		var typeID string
 		if masterType == "payment_type" && typeName != "" {
-			typeID = findTypeIDByName(typeName)
+			typeID = h.findTypeIDByName(typeName)
 			if typeID == "" {
 				s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 					Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -2950,7 +3816,7 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			UpdatedAt: time.Now(),
 		}
 		
-		err := addToMasterQueue(queueItem)
+		err := h.addToMasterQueue(queueItem)
 		if err != nil {
 			log.Printf("マスターデータキューへの追加エラー: %v", err)
 			s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -2963,6 +3829,17 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			return
 		}
 		
+		h.recordAudit(AuditEntry{
+			ActorID:    auditActorID(i),
+			Action:     auditActionMasterAdd,
+			EntityType: "master:" + masterType,
+			EntityID:   queueItem.ID,
+			Changes: diffAuditValues(map[string]string{}, map[string]string{
+				"name":      name,
+				"type_name": typeName,
+			}),
+		})
+		
 		// 成功応答
 		successMsg := fmt.Sprintf("✅ %s「%s」をキューに追加しました。", getMasterTypeName(masterType), name)
 		if masterType == "payment_type" && typeName != "" {
@@ -2980,29 +3857,29 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 
 	// isDuplicateMasterData は重複チェックを行う（既存マスター + キュー内）
-	func isDuplicateMasterData(masterType, name string) bool {
+	func (h *Household) isDuplicateMasterData(masterType, name string) bool {
 		// 既存マスターデータをチェック
 		switch masterType {
 		case "category":
-			for _, item := range masterCategories {
+			for _, item := range h.masterCategories {
 				if item.Name == name {
 					return true
 				}
 			}
 		case "group":
-			for _, item := range masterGroups {
+			for _, item := range h.masterGroups {
 				if item.Name == name {
 					return true
 				}
 			}
 		case "user":
-			for _, item := range masterUsers {
+			for _, item := range h.masterUsers {
 				if item.Name == name {
 					return true
 				}
 			}
 		case "payment_type":
-			for _, item := range masterPaymentTypes {
+			for _, item := range h.masterPaymentTypes {
 				if item.PayKind == name {
 					return true
 				}
@@ -3010,10 +3887,10 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		}
 		
 		// キュー内データもチェック
-		masterQueueMutex.RLock()
-		defer masterQueueMutex.RUnlock()
+		h.masterQueueMutex.RLock()
+		defer h.masterQueueMutex.RUnlock()
 		
-		if queueItems, exists := masterDataQueues[masterType]; exists {
+		if queueItems, exists := h.masterDataQueues[masterType]; exists {
 			for _, item := range queueItems {
 				if item.Name == name && item.Status != "error" {
 					return true
@@ -3025,8 +3902,8 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 
 	// findTypeIDByName はTypeNameからTypeIDを検索する
-	func findTypeIDByName(typeName string) string {
-		for _, item := range masterTypeList {
+	func (h *Household) findTypeIDByName(typeName string) string {
+		for _, item := range h.masterTypeList {
 			if item.TypeName == typeName {
 				return item.ID
 			}
@@ -3035,12 +3912,12 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 
 	// addToMasterQueue はマスターデータをキューに追加する
-	func addToMasterQueue(item MasterQueueItem) error {
-		masterQueueMutex.Lock()
-		defer masterQueueMutex.Unlock()
+	func (h *Household) addToMasterQueue(item MasterQueueItem) error {
+		h.masterQueueMutex.Lock()
+		defer h.masterQueueMutex.Unlock()
 		
 		// キューファイルを読み込み
-		queueFilePath := "master_queue.json"
+		queueFilePath := h.dataPath(masterQueueFile)
 		var queues map[string][]MasterQueueItem
 		
 		data, err := os.ReadFile(queueFilePath)
@@ -3075,10 +3952,10 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		}
 		
 		// メモリ内キューも更新
-		if masterDataQueues == nil {
-			masterDataQueues = make(map[string][]MasterQueueItem)
+		if h.masterDataQueues == nil {
+			h.masterDataQueues = make(map[string][]MasterQueueItem)
 		}
-		masterDataQueues[item.Type] = queues[item.Type]
+		h.masterDataQueues[item.Type] = queues[item.Type]
 		
 		return nil
 	}
@@ -3105,20 +3982,20 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 
 	// loadMasterQueueFromFile は起動時にキューファイルを読み込む
-	func loadMasterQueueFromFile() error {
-		queueFilePath := "master_queue.json"
+	func (h *Household) loadMasterQueueFromFile() error {
+		queueFilePath := h.dataPath(masterQueueFile)
 		data, err := os.ReadFile(queueFilePath)
 		if err != nil {
 			if os.IsNotExist(err) {
-				masterDataQueues = make(map[string][]MasterQueueItem)
+				h.masterDataQueues = make(map[string][]MasterQueueItem)
 				return nil
 			}
 			return err
 		}
 		
-		err = json.Unmarshal(data, &masterDataQueues)
+		err = json.Unmarshal(data, &h.masterDataQueues)
 		if err != nil {
-			masterDataQueues = make(map[string][]MasterQueueItem)
+			h.masterDataQueues = make(map[string][]MasterQueueItem)
 			return err
 		}
 		
@@ -3130,6 +4007,12 @@ func handleAddMaster(s *discordgo.Session, i *discordgo.InteractionCreate) {
 func handleCancelEntry(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "cancel_entry:")
+	// キューへの追加と同時に押された場合は、先に処理された方を優先する
+	unlock := lockEntry(messageID)
+	defer unlock()
+	if rejectLockedEntry(s, i) {
+		return
+	}
 	
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -3146,12 +4029,14 @@ func handleCancelEntry(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	mu.Lock()
 	delete(confirmationData, messageID)
 	mu.Unlock()
+	householdForInteraction(i).recordEntryCancelled(i, messageID)
 	
 	log.Printf("エントリキャンセル: messageID=%s", messageID)
 }
 
 // handleRemainingCategorySelect は残額分のカテゴリー選択を処理する
 func handleRemainingCategorySelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "remaining_category_select:")
 	
@@ -3171,13 +4056,13 @@ func handleRemainingCategorySelect(s *discordgo.Session, i *discordgo.Interactio
 	}
 	
 	// データを更新
-	updateConfirmationData(messageID, func(data *ConfirmationData) {
+	editConfirmationData(i, messageID, func(data *ConfirmationData) {
 		data.CategoryID = categoryID
 	})
 	
 	// カテゴリー名を取得
 	var categoryName string = "不明"
-	for _, category := range masterCategories {
+	for _, category := range h.masterCategories {
 		if category.ID == categoryID {
 			categoryName = category.Name
 			break
@@ -3256,8 +4141,12 @@ func handleRemainingDetails(s *discordgo.Session, i *discordgo.InteractionCreate
 
 // handleAddRemainingToQueue は残額分をキューに追加する処理
 func handleAddRemainingToQueue(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "add_remaining_to_queue:")
+	// 連打された場合も1件だけ追加されるよう、確認画面ごとに順番に処理する
+	unlock := lockEntry(messageID)
+	defer unlock()
 	
 	data := getConfirmationData(messageID)
 	if data == nil {
@@ -3270,52 +4159,48 @@ func handleAddRemainingToQueue(s *discordgo.Session, i *discordgo.InteractionCre
 		})
 		return
 	}
-	
-	// Expenseデータを作成
-	expense := Expense{
-		Date:       data.Date,
-		Price:      data.Amount,
-		CategoryID: data.CategoryID,
-		UserID:     data.UserID,
-		Detail:     data.Detail,
-		GroupID:    data.GroupID,
+	if notice := describeConfirmationStatus(messageID); notice != "" {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: notice,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
 	}
 	
-	// Expenseキューファイルに保存
-	err := saveExpenseToQueue(expense)
+	// 日付はISO形式に揃えてから保存する
+	expenseDate, err := normalizeDate(data.Date, nowInTokyo())
 	if err != nil {
-		botErr := NewBotError(ErrorTypeFileIO, "残額分Expenseキューファイル保存エラー", err).
-			WithContext("expense", fmt.Sprintf("%+v", expense))
-		LogBotError(botErr)
-		
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
-				Content: "❌ エラー: 残額分のキューへの保存に失敗しました。",
+				Content: describeDateError(data.Date, err) + "\n「日付を編集」から修正してください。",
 				Flags:   discordgo.MessageFlagsEphemeral,
 			},
 		})
 		return
 	}
 	
-	log.Printf("残額分をキューに追加: %+v", expense)
+	expense := h.newConfirmationExpense(data, expenseDate)
+	if !h.submitConfirmation(s, i, messageID, expense) {
+		return
+	}
+	h.recordConfirmedDetail(data.CategoryID, data.Detail, data.DetailEdited)
 	
 	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: "✅ 残額分をキューに追加しました。処理を完了します。",
-			Flags:   discordgo.MessageFlagsEphemeral,
+			Content:    fmt.Sprintf("✅ 残額分をキューに追加しました。(ID: `%s`) 処理を完了します。", expense.ID),
+			Flags:      discordgo.MessageFlagsEphemeral,
+			Components: undoButton(expense.ID),
 		},
 	})
 	if err != nil {
 		log.Printf("残額分キュー追加応答エラー: %v", err)
 	}
 	
-	// 確認データを削除
-	mu.Lock()
-	delete(confirmationData, messageID)
-	mu.Unlock()
-	
 	log.Printf("残額分キュー追加完了: messageID=%s", messageID)
 }
 
@@ -3323,6 +4208,11 @@ func handleAddRemainingToQueue(s *discordgo.Session, i *discordgo.InteractionCre
 func handleSkipRemaining(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	customID := i.MessageComponentData().CustomID
 	messageID := strings.TrimPrefix(customID, "skip_remaining:")
+	unlock := lockEntry(messageID)
+	defer unlock()
+	if rejectLockedEntry(s, i) {
+		return
+	}
 	
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -3339,6 +4229,7 @@ func handleSkipRemaining(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	mu.Lock()
 	delete(confirmationData, messageID)
 	mu.Unlock()
+	householdForInteraction(i).recordEntryCancelled(i, messageID)
 	
 	log.Printf("残額分をスキップ: messageID=%s", messageID)
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// メッセージのコンテキストメニュー「支出として登録」
+// =================================================================================
+
+const registerExpenseCommandName = "支出として登録"
+
+// isAllowedChannel はコンテキストメニューから支出を登録できるチャンネルかを判定する
+// allowed_channel_idsを指定した場合は、それらと家計のチャンネルのみを許可する（未指定なら家計のサーバーのすべてのチャンネル）
+func (h *Household) isAllowedChannel(channelID string) bool {
+	if len(h.AllowedChannelIDs) == 0 || channelID == h.ChannelID {
+		return true
+	}
+	return slices.Contains(h.AllowedChannelIDs, channelID)
+}
+
+// interactionUser はインタラクションを実行したユーザーを返す（サーバー内ではMember、DMではUserに入る）
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// handleRegisterExpense は「支出として登録」の処理
+// 対象のメッセージをチャンネルへの投稿と同じ流れ（画像はレシート解析、テキストは1行の支出メモ）で解析し、
+// 補足情報の入力ボタンや確認画面を家計のチャンネルに表示する
+func handleRegisterExpense(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	data := i.ApplicationCommandData()
+	var message *discordgo.Message
+	if data.Resolved != nil {
+		message = data.Resolved.Messages[data.TargetID]
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		log.Printf("支出として登録の応答エラー: %v", err)
+		return
+	}
+
+	content := registerMessageAsExpense(s, h, i, message)
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+		log.Printf("支出として登録の応答の更新エラー: %v", err)
+	}
+}
+
+// registerMessageAsExpense はメッセージの解析を開始し、実行したユーザーへの応答メッセージを返す
+func registerMessageAsExpense(s *discordgo.Session, h *Household, i *discordgo.InteractionCreate, message *discordgo.Message) string {
+	if message == nil {
+		return "❌ 対象のメッセージを取得できませんでした。"
+	}
+	if !h.isAllowedChannel(i.ChannelID) {
+		return "❌ このチャンネルのメッセージは支出として登録できません。"
+	}
+
+	if status, exists := confirmationStatus(message.ID); exists && status == ConfirmationSubmitted {
+		return describeConfirmationStatus(message.ID)
+	}
+	mu.Lock()
+	_, running := transactions[message.ID]
+	_, confirming := confirmationData[message.ID]
+	mu.Unlock()
+	if running || confirming {
+		return fmt.Sprintf("🔄 このメッセージは既に登録の途中です。<#%s> の確認画面から操作してください。", h.ChannelID)
+	}
+
+	// 補足情報の入力ボタンや通知は、元のチャンネルではなく登録用のチャンネルに実行したユーザー宛てで表示する
+	forwarded := *message
+	forwarded.ChannelID = h.ChannelID
+	if user := interactionUser(i); user != nil {
+		forwarded.Author = user
+	}
+	m := &discordgo.MessageCreate{Message: &forwarded}
+
+	if attachments := receiptAttachments(message); len(attachments) > 0 {
+		log.Printf("支出として登録（画像）: messageID=%s, channelID=%s (%d件)", message.ID, message.ChannelID, len(attachments))
+		startReceiptTransactions(s, h, m, attachments)
+		return fmt.Sprintf("📥 レシートを解析しています。<#%s> に表示されるボタンから補足情報を入力できます。", h.ChannelID)
+	}
+
+	text := strings.TrimSpace(message.Content)
+	entry := h.parseTextEntry(text, nowInTokyo())
+	if entry.AmountCount == 0 {
+		return "❌ 金額が見つかりませんでした。レシート画像か「Amazon 3,480円」のような金額を含むメッセージを選んでください。"
+	}
+	log.Printf("支出として登録（テキスト）: messageID=%s, channelID=%s, text=%s", message.ID, message.ChannelID, text)
+	if err := registerTextEntry(s, h, message.ID, forwarded.Author.ID, entry); err != nil {
+		return fmt.Sprintf("❌ %s", describeAnalysisError(err))
+	}
+	return fmt.Sprintf("✅ <#%s> に確認画面を表示しました。", h.ChannelID)
+}
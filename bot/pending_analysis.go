@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// AIが利用できない間のレシート解析の保留
+// =================================================================================
+
+const pendingAnalysisFile = "pending_analysis.json"
+const pendingAnalysisCheckInterval = time.Minute       // 保留中の解析を再試行するか確認する間隔
+const pendingAnalysisBaseDelay = 2 * time.Minute       // 保留後の初回の再試行までの時間（再試行ごとに倍増）
+const pendingAnalysisMaxDelay = 30 * time.Minute       // 再試行の間隔の上限
+const pendingAnalysisMaxAge = unlinkedReceiptRetention // これを過ぎると画像がアーカイブから削除されるため解析を諦める
+
+var (
+	pendingAnalyses      map[string]*PendingAnalysis // トランザクションID -> 保留中の解析
+	pendingAnalysisMutex sync.Mutex                  // pendingAnalysesの同期
+)
+
+// PendingAnalysis はAIが利用できなかったため保留しているレシート解析1件
+// 画像はアーカイブに保存済みのため、再起動後もキーから解析をやり直せる
+type PendingAnalysis struct {
+	// トランザクションID（補足情報入力ボタンなどのcustomIDに使われているため、再起動後も同じIDで復元する）
+	ID              string            `json:"id"`
+	HouseholdID     string            `json:"household_id"`
+	ChannelID       string            `json:"channel_id"`
+	PromptMessageID string            `json:"prompt_message_id"` // 「詳細情報を入力」ボタンを表示しているメッセージのID
+	AuthorID        string            `json:"author_id"`
+	ReceiptKey      string            `json:"receipt_key"` // レシートアーカイブの画像キー（続きの画像はアーカイブに記録済み）
+	AttachmentLabel string            `json:"attachment_label,omitempty"`
+	Stitched        bool              `json:"stitched,omitempty"`
+	UserInput       map[string]string `json:"user_input,omitempty"` // 入力済みの補足情報（未入力ならnil）
+	Attempts        int               `json:"attempts"`
+	LastError       string            `json:"last_error"`
+	CreatedAt       time.Time         `json:"created_at"`
+	NextAttemptAt   time.Time         `json:"next_attempt_at"`
+}
+
+// loadPendingAnalyses は起動時に保留中の解析を読み込み、ボタンから操作できるようトランザクションを復元する
+func loadPendingAnalyses() error {
+	pendingAnalysisMutex.Lock()
+	defer pendingAnalysisMutex.Unlock()
+
+	pendingAnalyses = make(map[string]*PendingAnalysis)
+	data, err := os.ReadFile(pendingAnalysisFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return NewBotError(ErrorTypeFileIO, "保留中の解析の読み込みに失敗", err).
+			WithContext("file_path", pendingAnalysisFile)
+	}
+	if err := json.Unmarshal(data, &pendingAnalyses); err != nil {
+		pendingAnalyses = make(map[string]*PendingAnalysis)
+		return NewBotError(ErrorTypeFileIO, "保留中の解析のJSONパースエラー", err).
+			WithContext("file_path", pendingAnalysisFile)
+	}
+
+	mu.Lock()
+	for id, job := range pendingAnalyses {
+		// 以前の形式で保存された保留にはIDがないため、チャンネルから家計を探す
+		h := householdByID(job.HouseholdID)
+		if h == nil {
+			h = householdByChannel(job.ChannelID)
+		}
+		if h == nil {
+			log.Printf("家計が見つからないため保留中の解析を破棄します: messageID=%s, household=%s", id, job.HouseholdID)
+			delete(pendingAnalyses, id)
+			continue
+		}
+		job.HouseholdID = h.ID
+		transactions[id] = &TransactionState{
+			InitialMessageID: id,
+			Household:        h,
+			ChannelID:        job.ChannelID,
+			PromptMessageID:  job.PromptMessageID,
+			Message: &discordgo.Message{
+				ID:        id,
+				ChannelID: job.ChannelID,
+				Author:    &discordgo.User{ID: job.AuthorID},
+			},
+			AttachmentLabel: job.AttachmentLabel,
+			Stitched:        job.Stitched,
+			ReceiptKey:      job.ReceiptKey,
+			UserInput:       job.UserInput,
+			AIResultChan:    make(chan AnalysisOutcome, 1),
+			Status:          AnalysisStatusPending,
+			LastError:       NewBotError(ErrorTypeAIService, job.LastError, nil),
+			NextAttemptAt:   job.NextAttemptAt,
+		}
+	}
+	mu.Unlock()
+
+	log.Printf("-> 保留中の解析を%d件読み込みました。", len(pendingAnalyses))
+	return nil
+}
+
+// savePendingAnalysesLocked は保留中の解析をファイルに保存する（pendingAnalysisMutexを保持して呼ぶこと）
+func savePendingAnalysesLocked() error {
+	data, err := json.MarshalIndent(pendingAnalyses, "", "  ")
+	if err != nil {
+		return NewBotError(ErrorTypeFileIO, "保留中の解析のシリアライズに失敗", err)
+	}
+	if err := os.WriteFile(pendingAnalysisFile, data, 0644); err != nil {
+		return NewBotError(ErrorTypeFileIO, "保留中の解析の保存に失敗", err).
+			WithContext("file_path", pendingAnalysisFile)
+	}
+	return nil
+}
+
+// pendingAnalysisDelay は保留した解析を次に再試行するまでの時間を返す
+func pendingAnalysisDelay(attempts int) time.Duration {
+	delay := pendingAnalysisBaseDelay << min(attempts, 10)
+	return min(delay, pendingAnalysisMaxDelay)
+}
+
+// deferAnalysis はAIが利用できなかった解析を保留として保存し、次に再試行する時刻を決める
+func deferAnalysis(state *TransactionState, cause error) {
+	mu.Lock()
+	job := &PendingAnalysis{
+		ID:              state.InitialMessageID,
+		HouseholdID:     state.Household.ID,
+		ChannelID:       state.ChannelID,
+		PromptMessageID: state.PromptMessageID,
+		ReceiptKey:      state.ReceiptKey,
+		AttachmentLabel: state.AttachmentLabel,
+		Stitched:        state.Stitched,
+		UserInput:       maps.Clone(state.UserInput),
+	}
+	if state.Message != nil && state.Message.Author != nil {
+		job.AuthorID = state.Message.Author.ID
+	}
+	mu.Unlock()
+
+	now := time.Now()
+	pendingAnalysisMutex.Lock()
+	if existing, exists := pendingAnalyses[job.ID]; exists {
+		job.Attempts = existing.Attempts + 1
+		job.CreatedAt = existing.CreatedAt
+	} else {
+		job.CreatedAt = now
+	}
+	job.LastError = describeAnalysisError(cause)
+	job.NextAttemptAt = now.Add(pendingAnalysisDelay(job.Attempts))
+	pendingAnalyses[job.ID] = job
+	if err := savePendingAnalysesLocked(); err != nil {
+		HandleError(err, nil)
+	}
+	pendingAnalysisMutex.Unlock()
+
+	log.Printf("AIが利用できないため解析を保留しました: messageID=%s, 再試行=%s, err=%v",
+		job.ID, job.NextAttemptAt.In(tokyoLocation).Format("15:04"), cause)
+
+	mu.Lock()
+	state.NextAttemptAt = job.NextAttemptAt
+	mu.Unlock()
+}
+
+// setPendingUserInput は保留中の解析に補足情報を保存する（解析が成功したら確認画面まで進めるため）
+func setPendingUserInput(id string, userInput map[string]string) {
+	pendingAnalysisMutex.Lock()
+	defer pendingAnalysisMutex.Unlock()
+
+	job, exists := pendingAnalyses[id]
+	if !exists {
+		return
+	}
+	job.UserInput = maps.Clone(userInput)
+	if err := savePendingAnalysesLocked(); err != nil {
+		HandleError(err, nil)
+	}
+}
+
+// removePendingAnalysis は保留中の解析を削除し、保留されていたかを返す
+func removePendingAnalysis(id string) bool {
+	pendingAnalysisMutex.Lock()
+	defer pendingAnalysisMutex.Unlock()
+
+	if _, exists := pendingAnalyses[id]; !exists {
+		return false
+	}
+	delete(pendingAnalyses, id)
+	if err := savePendingAnalysesLocked(); err != nil {
+		HandleError(err, nil)
+	}
+	return true
+}
+
+// loadArchivedReceiptImages はアーカイブ済みの画像（続きの画像を含む）をAIに送れる形式で読み込む
+func loadArchivedReceiptImages(key string) ([]string, []PreparedImage, string, error) {
+	keys := receiptImageKeys(key)
+	var images []PreparedImage
+	var imgPath string
+	for _, imageKey := range keys {
+		entry, data, err := readArchivedReceipt(imageKey)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if imgPath == "" {
+			imgPath = filepath.Join(receiptArchiveDir, entry.FileName)
+		}
+		prepared, err := prepareReceiptImages(data, entry.ContentType)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		images = append(images, prepared...)
+	}
+	return keys, images, imgPath, nil
+}
+
+// postPendingNotice は保留していた解析の結果を、投稿者にメンションしてボタンのメッセージへ返信する
+// 数時間後に完了することもあり、メッセージの書き換えだけでは気付きにくいため返信で知らせる
+func postPendingNotice(s *discordgo.Session, state *TransactionState, content string) {
+	mu.Lock()
+	channelID := state.ChannelID
+	promptMessageID := state.PromptMessageID
+	var authorID string
+	if state.Message != nil && state.Message.Author != nil {
+		authorID = state.Message.Author.ID
+	}
+	mu.Unlock()
+
+	message := &discordgo.MessageSend{Content: content}
+	if authorID != "" {
+		message.Content = fmt.Sprintf("<@%s> %s", authorID, content)
+	}
+	if promptMessageID != "" {
+		message.Reference = &discordgo.MessageReference{MessageID: promptMessageID, ChannelID: channelID}
+	}
+	if _, err := s.ChannelMessageSendComplex(channelID, message); err != nil {
+		botErr := NewBotError(ErrorTypeDiscordAPI, "保留していた解析の通知に失敗", err).
+			WithContext("message_id", state.InitialMessageID)
+		LogBotError(botErr)
+	}
+}
+
+// runDuePendingAnalyses は再試行の時刻を過ぎた保留中の解析を順に実行する
+func runDuePendingAnalyses(s *discordgo.Session, now time.Time) {
+	var due, expired []string
+	pendingAnalysisMutex.Lock()
+	for id, job := range pendingAnalyses {
+		switch {
+		case now.Sub(job.CreatedAt) > pendingAnalysisMaxAge:
+			expired = append(expired, id)
+		case !now.Before(job.NextAttemptAt):
+			due = append(due, id)
+		}
+	}
+	pendingAnalysisMutex.Unlock()
+
+	for _, id := range expired {
+		removePendingAnalysis(id)
+		mu.Lock()
+		state, exists := transactions[id]
+		if exists {
+			state.Status = AnalysisStatusFailed
+			state.LastError = NewBotError(ErrorTypeAIService, "AIが長時間利用できなかったため解析を中止しました", nil)
+		}
+		mu.Unlock()
+		log.Printf("保留中の解析が期限切れになりました: messageID=%s", id)
+		if exists {
+			updateReceiptPrompt(s, state)
+			postPendingNotice(s, state, "⌛ AIが長時間利用できなかったため、レシートの解析を中止しました。「手入力で続行」から登録してください。")
+		}
+	}
+
+	for _, id := range due {
+		mu.Lock()
+		state, exists := transactions[id]
+		ready := exists && state.Status == AnalysisStatusPending
+		var resultChan chan AnalysisOutcome
+		if ready {
+			state.Status = AnalysisStatusRunning
+			state.LastError = nil
+			state.AIResultChan = make(chan AnalysisOutcome, 1)
+			resultChan = state.AIResultChan
+		}
+		mu.Unlock()
+
+		if !exists {
+			// 手入力で続行された場合など、トランザクションが残っていなければ保留も不要
+			removePendingAnalysis(id)
+			continue
+		}
+		if !ready {
+			continue
+		}
+
+		log.Printf("保留中の解析を再試行します: messageID=%s", id)
+		updateReceiptPrompt(s, state)
+		result, err := runReceiptAnalysis(state)
+		finishAnalysis(s, state, resultChan, result, err)
+	}
+}
+
+// startPendingAnalysisWorker は保留中の解析を定期的に再試行する
+func startPendingAnalysisWorker(s *discordgo.Session) {
+	go func() {
+		runDuePendingAnalyses(s, time.Now())
+		ticker := time.NewTicker(pendingAnalysisCheckInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runDuePendingAnalyses(s, now)
+		}
+	}()
+}
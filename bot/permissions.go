@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 権限（閲覧者・記録者・管理者）
+// =================================================================================
+
+// Role は家計に対する操作の権限（値が大きいほど強い）
+type Role int
+
+const (
+	RoleNone     Role = iota // 何も操作できない
+	RoleViewer               // マスターデータやキューの閲覧のみ
+	RoleRecorder             // 支出の登録・確認画面の操作
+	RoleAdmin                // マスターデータ・ルールの変更、他のユーザーの確認画面の操作
+)
+
+var roleNames = map[string]Role{
+	"none":     RoleNone,
+	"viewer":   RoleViewer,
+	"recorder": RoleRecorder,
+	"admin":    RoleAdmin,
+}
+
+var roleLabels = map[Role]string{
+	RoleNone:     "権限なし",
+	RoleViewer:   "閲覧者",
+	RoleRecorder: "記録者",
+	RoleAdmin:    "管理者",
+}
+
+// RoleMembers は権限を与えるDiscordのロールとユーザー
+type RoleMembers struct {
+	RoleIDs []string `json:"role_ids"`
+	UserIDs []string `json:"user_ids"`
+}
+
+// PermissionConfig は家計の権限設定
+type PermissionConfig struct {
+	Admin       RoleMembers `json:"admin"`
+	Recorder    RoleMembers `json:"recorder"`
+	Viewer      RoleMembers `json:"viewer"`
+	DefaultRole string      `json:"default_role"` // どれにも該当しないユーザーの権限（省略時はviewer）
+}
+
+// commandRoles はスラッシュコマンドの実行に必要な権限（「コマンド名 サブコマンド名」の指定を優先する）
+var commandRoles = map[string]Role{
+	"check_master":             RoleViewer,
+	"show_master":              RoleViewer,
+	"receipt":                  RoleViewer,
+	"ask":                      RoleViewer,
+	"add":                      RoleRecorder,
+	"fix":                      RoleAdmin,
+	"add_master":               RoleAdmin,
+	"rule":                     RoleAdmin,
+	"rule list":                RoleViewer,
+	"rule dryrun":              RoleViewer,
+	"export_samples":           RoleAdmin,
+	"cache":                    RoleAdmin,
+	"reload":                   RoleAdmin,
+	"audit":                    RoleAdmin,
+	"undo":                     RoleRecorder,
+	"recurring":                RoleAdmin,
+	"recurring list":           RoleViewer,
+	registerExpenseCommandName: RoleRecorder,
+}
+
+// viewerComponentPrefixes は閲覧者でも使えるボタン・セレクトメニュー（それ以外は記録者以上）
+var viewerComponentPrefixes = []string{"paginate:", "show_duplicates:"}
+
+// ownedComponentPrefixes は投稿者本人（または管理者）しか操作できない確認画面のボタン・モーダル
+// customIDは「prefix:元のメッセージID[:...]」の形式
+var ownedComponentPrefixes = []string{
+	"receipt_info_button:", "receipt_stitch_done:", "receipt_retry:", "receipt_manual:", "receipt_info_modal:",
+	"category_select:", "category_search:", "category_search_modal:",
+	"edit_date:", "edit_amount:", "edit_payment:", "edit_group:", "edit_payer:", "edit_detail:",
+	"edit_date_modal:", "edit_amount_modal:", "edit_payment_modal:", "edit_detail_modal:",
+	"group_select:", "payer_select:", "credit_detail_select:", "payment_manual_input:",
+	"add_to_queue:", "confirm_field:", "force_add_to_queue:", "cancel_entry:",
+	"remaining_category_select:", "remaining_details:", "remaining_detail_modal:", "skip_remaining:", "add_remaining_to_queue:",
+}
+
+// parseRole は設定ファイルの権限名を変換する
+func parseRole(name string) (Role, error) {
+	role, ok := roleNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return RoleNone, fmt.Errorf("不明な権限です: %s", name)
+	}
+	return role, nil
+}
+
+// defaultPermissionConfig は環境変数から既定の家計の権限設定を作る（管理者の指定がなければnil）
+func defaultPermissionConfig() *PermissionConfig {
+	admin := RoleMembers{
+		RoleIDs: splitEnvList(os.Getenv("ADMIN_ROLE_IDS")),
+		UserIDs: splitEnvList(os.Getenv("ADMIN_USER_IDS")),
+	}
+	if len(admin.RoleIDs) == 0 && len(admin.UserIDs) == 0 {
+		return nil
+	}
+	// 従来どおりチャンネルの全員が支出を登録できるようにする
+	return &PermissionConfig{Admin: admin, DefaultRole: "recorder"}
+}
+
+// matches はユーザーが指定のロール・ユーザーに含まれるかを判定する
+func (m RoleMembers) matches(userID string, memberRoles []string) bool {
+	if slices.Contains(m.UserIDs, userID) {
+		return true
+	}
+	for _, roleID := range memberRoles {
+		if slices.Contains(m.RoleIDs, roleID) {
+			return true
+		}
+	}
+	return false
+}
+
+// roleFor はインタラクションを実行したユーザーの権限を返す
+// 権限が設定されていない家計では、従来どおり全員を管理者として扱う
+func (h *Household) roleFor(i *discordgo.InteractionCreate) Role {
+	config := h.Permissions
+	if config == nil {
+		return RoleAdmin
+	}
+	user := interactionUser(i)
+	if user == nil {
+		return RoleNone
+	}
+	var memberRoles []string
+	if i.Member != nil {
+		memberRoles = i.Member.Roles
+	}
+
+	switch {
+	case config.Admin.matches(user.ID, memberRoles):
+		return RoleAdmin
+	case config.Recorder.matches(user.ID, memberRoles):
+		return RoleRecorder
+	case config.Viewer.matches(user.ID, memberRoles):
+		return RoleViewer
+	}
+	if config.DefaultRole == "" {
+		return RoleViewer
+	}
+	role, _ := parseRole(config.DefaultRole) // 読み込み時に検証済み
+	return role
+}
+
+// requiredRole はインタラクションに必要な権限を返す
+func requiredRole(i *discordgo.InteractionCreate) Role {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		if len(data.Options) > 0 && data.Options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+			if role, ok := commandRoles[data.Name+" "+data.Options[0].Name]; ok {
+				return role
+			}
+		}
+		if role, ok := commandRoles[data.Name]; ok {
+			return role
+		}
+		return RoleAdmin
+	case discordgo.InteractionMessageComponent:
+		customID := i.MessageComponentData().CustomID
+		for _, prefix := range viewerComponentPrefixes {
+			if strings.HasPrefix(customID, prefix) {
+				return RoleViewer
+			}
+		}
+	}
+	return RoleRecorder
+}
+
+// entryMessageID は確認画面のボタン・モーダルのcustomIDから元のメッセージIDを取り出す（確認画面以外は空）
+func entryMessageID(customID string) string {
+	for _, prefix := range ownedComponentPrefixes {
+		if strings.HasPrefix(customID, prefix) {
+			messageID, _, _ := strings.Cut(strings.TrimPrefix(customID, prefix), ":")
+			return messageID
+		}
+	}
+	return ""
+}
+
+// entryOwnerID は確認画面・解析中のレシートを投稿したユーザーのIDを返す（不明な場合は空）
+func entryOwnerID(customID string) string {
+	messageID := entryMessageID(customID)
+	if messageID == "" {
+		return ""
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if data, exists := confirmationData[messageID]; exists {
+		return data.PosterID
+	}
+	if state, exists := transactions[messageID]; exists {
+		return state.posterID()
+	}
+	return ""
+}
+
+// interactionCustomID はボタン・セレクトメニュー・モーダルのcustomIDを返す（コマンドの場合は空）
+func interactionCustomID(i *discordgo.InteractionCreate) string {
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		return i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		return i.ModalSubmitData().CustomID
+	}
+	return ""
+}
+
+// authorizeInteraction は実行したユーザーの権限を確認し、足りない場合は拒否を応答してfalseを返す
+func authorizeInteraction(s *discordgo.Session, h *Household, i *discordgo.InteractionCreate) bool {
+	role := h.roleFor(i)
+	required := requiredRole(i)
+
+	var content string
+	if role < required {
+		content = fmt.Sprintf("🔒 この操作には%s以上の権限が必要です（あなたの権限: %s）。", roleLabels[required], roleLabels[role])
+	} else if role < RoleAdmin {
+		// 確認画面は投稿した本人と管理者だけが操作できる
+		if ownerID := entryOwnerID(interactionCustomID(i)); ownerID != "" {
+			if user := interactionUser(i); user == nil || user.ID != ownerID {
+				content = fmt.Sprintf("🔒 この確認画面は<@%s>さんの投稿です。投稿者本人か管理者のみ操作できます。", ownerID)
+			}
+		}
+	}
+	if content == "" {
+		return true
+	}
+
+	userID := ""
+	if user := interactionUser(i); user != nil {
+		userID = user.ID
+	}
+	log.Printf("権限不足のため操作を拒否しました: household=%s, user=%s, role=%s, custom_id=%s",
+		h.ID, userID, roleLabels[role], interactionCustomID(i))
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("権限エラーの応答に失敗: %v", err)
+	}
+	return false
+}
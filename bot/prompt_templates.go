@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// プロンプトテンプレート
+// =================================================================================
+
+const promptTemplatesDir = "./prompts" // 既定のプロンプトを上書きするテンプレートのディレクトリ
+
+var (
+	promptTemplates     *receipt.PromptSet
+	promptTemplateMutex sync.RWMutex // promptTemplatesの同期（再読み込み中の参照に備える）
+)
+
+// loadPromptTemplates は既定のプロンプトと./promptsのテンプレートを読み込む
+func loadPromptTemplates() error {
+	prompts, err := receipt.LoadPrompts(promptTemplatesDir)
+	if err != nil {
+		return NewBotError(ErrorTypeConfiguration, "プロンプトテンプレートの読み込みに失敗", err).
+			WithContext("dir", promptTemplatesDir)
+	}
+
+	promptTemplateMutex.Lock()
+	promptTemplates = prompts
+	promptTemplateMutex.Unlock()
+
+	for _, promptTemplate := range prompts.Templates() {
+		log.Printf("-> プロンプト %s を読み込みました (%s)", promptTemplate.Version, promptTemplate.Source)
+	}
+	return nil
+}
+
+// renderPrompt はプロンプトを生成し、使用したテンプレートのバージョンとともに返す
+func renderPrompt(name string, data any) (string, string, error) {
+	promptTemplateMutex.RLock()
+	prompts := promptTemplates
+	promptTemplateMutex.RUnlock()
+
+	if prompts == nil {
+		return "", "", NewBotError(ErrorTypeConfiguration, "プロンプトテンプレートが読み込まれていません", nil).
+			WithContext("prompt", name)
+	}
+	text, version, err := prompts.Render(name, data)
+	if err != nil {
+		return "", "", NewBotError(ErrorTypeConfiguration, "プロンプトの生成に失敗", err).
+			WithContext("prompt", name)
+	}
+	return text, version, nil
+}
+
+// promptVersionsSummary は使用中のプロンプトのバージョンを表示用にまとめる
+func promptVersionsSummary() string {
+	promptTemplateMutex.RLock()
+	defer promptTemplateMutex.RUnlock()
+
+	if promptTemplates == nil {
+		return "未読み込み"
+	}
+	var versions []string
+	for _, promptTemplate := range promptTemplates.Templates() {
+		versions = append(versions, promptTemplate.Version)
+	}
+	return strings.Join(versions, ", ")
+}
+
+// categoryNames はプロンプトに渡すカテゴリー名の一覧を返す
+func (h *Household) categoryNames() []string {
+	names := make([]string, 0, len(h.masterCategories))
+	for _, category := range h.masterCategories {
+		names = append(names, category.Name)
+	}
+	return names
+}
+
+// handleReload は /reload コマンドの処理（マスターデータ・詳細説明サンプル・プロンプトを再読み込みする）
+func handleReload(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	var failures []string
+	if err := h.loadMasterData(h.MasterDataPath); err != nil {
+		HandleError(err, nil)
+		failures = append(failures, "マスターデータ")
+	} else {
+		h.recordMasterSync(auditActorID(i))
+	}
+	if err := h.loadDetailSamples(h.dataPath(detailSamplesDir)); err != nil {
+		HandleError(err, nil)
+		failures = append(failures, "詳細説明サンプル")
+	}
+	if err := loadPromptTemplates(); err != nil {
+		HandleError(err, nil)
+		failures = append(failures, "プロンプト")
+	}
+
+	content := fmt.Sprintf("🔄 マスターデータ・詳細説明サンプル・プロンプトを再読み込みしました。\nプロンプト: %s", promptVersionsSummary())
+	if len(failures) > 0 {
+		content = fmt.Sprintf("❌ %s の再読み込みに失敗しました。ログを確認してください。", strings.Join(failures, "・"))
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("再読み込み応答エラー: %v", err)
+	}
+}
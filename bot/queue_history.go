@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// Expenseキューの操作履歴と取り消し
+// =================================================================================
+
+const queueHistoryFile = "queue_history.json" // 取り消せる期間内のキュー操作（家計のデータディレクトリ）
+const defaultUndoWindow = 30 * time.Minute    // 取り消せる期間の既定値（undo_window_minutesで変更可）
+
+// QueueOperation はExpenseキューへの1回分の追加（Botはキューの支出を修正・削除しないため追加のみ）
+type QueueOperation struct {
+	ID        string    `json:"id"`
+	ActorID   string    `json:"actor_id"` // 操作したユーザーのDiscord ID（自動登録などは空）
+	ExpenseID string    `json:"expense_id"`
+	Expense   Expense   `json:"expense"` // 追加した支出
+	Time      time.Time `json:"time"`
+}
+
+// undoWindow は家計の操作を取り消せる期間を返す
+func (h *Household) undoWindow() time.Duration {
+	if h.UndoWindowMinutes > 0 {
+		return time.Duration(h.UndoWindowMinutes) * time.Minute
+	}
+	return defaultUndoWindow
+}
+
+// loadQueueHistoryLocked は取り消せる期間内の操作履歴を読み込む（expenseQueueMutexを保持して呼ぶ）
+func (h *Household) loadQueueHistoryLocked() ([]QueueOperation, error) {
+	path := h.dataPath(queueHistoryFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, NewBotError(ErrorTypeFileIO, "キュー操作履歴の読み込みエラー", err).
+			WithContext("file_path", path)
+	}
+	var operations []QueueOperation
+	if err := json.Unmarshal(data, &operations); err != nil {
+		return nil, NewBotError(ErrorTypeFileIO, "キュー操作履歴のJSONパースエラー", err).
+			WithContext("file_path", path)
+	}
+
+	// 期間を過ぎた操作は取り消せないため読み飛ばす
+	cutoff := time.Now().Add(-h.undoWindow())
+	var recent []QueueOperation
+	for _, op := range operations {
+		if op.Time.After(cutoff) {
+			recent = append(recent, op)
+		}
+	}
+	return recent, nil
+}
+
+// writeQueueHistoryLocked は操作履歴を保存する（expenseQueueMutexを保持して呼ぶ）
+func (h *Household) writeQueueHistoryLocked(operations []QueueOperation) error {
+	path := h.dataPath(queueHistoryFile)
+	data, err := json.MarshalIndent(operations, "", "  ")
+	if err != nil {
+		return NewBotError(ErrorTypeFileIO, "キュー操作履歴のJSON生成エラー", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return NewBotError(ErrorTypeFileIO, "キュー操作履歴の書き込みエラー", err).
+			WithContext("file_path", path)
+	}
+	return nil
+}
+
+// recordQueueOperationLocked は取り消しできるよう追加した支出を履歴に残す（expenseQueueMutexを保持して呼ぶ）
+// 履歴を保存できなくてもキューの操作自体は成功しているため、エラーはログに残すだけにする
+func (h *Household) recordQueueOperationLocked(actorID string, expense Expense) {
+	op := QueueOperation{
+		ID:        generateUniqueID(),
+		ActorID:   actorID,
+		ExpenseID: expense.ID,
+		Expense:   expense,
+		Time:      time.Now(),
+	}
+
+	operations, err := h.loadQueueHistoryLocked()
+	if err == nil {
+		err = h.writeQueueHistoryLocked(append(operations, op))
+	}
+	if err != nil {
+		HandleError(err, nil)
+	}
+}
+
+// undoQueueOperation はユーザーの直近の追加（expenseIDを指定した場合はその支出の追加）を取り消す
+func (h *Household) undoQueueOperation(actorID, expenseID string) (QueueOperation, error) {
+	h.expenseQueueMutex.Lock()
+	defer h.expenseQueueMutex.Unlock()
+
+	operations, err := h.loadQueueHistoryLocked()
+	if err != nil {
+		return QueueOperation{}, err
+	}
+	target := -1
+	for idx := len(operations) - 1; idx >= 0; idx-- {
+		op := operations[idx]
+		if op.ActorID == actorID && (expenseID == "" || op.ExpenseID == expenseID) {
+			target = idx
+			break
+		}
+	}
+	if target < 0 {
+		return QueueOperation{}, NewBotError(ErrorTypeValidation,
+			fmt.Sprintf("直近%d分以内に取り消せる操作がありません", int(h.undoWindow().Minutes())), nil).
+			WithContext("actor_id", actorID).
+			WithContext("expense_id", expenseID)
+	}
+	op := operations[target]
+
+	expenseQueue, err := h.loadExpenseQueue()
+	if err != nil {
+		return QueueOperation{}, err
+	}
+	current := -1
+	for idx, expense := range expenseQueue {
+		if expense.ID == op.ExpenseID {
+			current = idx
+			break
+		}
+	}
+
+	// 同期処理がキューから取り込んだ支出はマスターデータベースに反映済みのため取り消さない
+	if current < 0 {
+		return QueueOperation{}, NewBotError(ErrorTypeValidation, "この支出は既に同期済みのため取り消せません", nil).
+			WithContext("expense_id", op.ExpenseID)
+	}
+
+	expenseQueue = append(expenseQueue[:current], expenseQueue[current+1:]...)
+	if err := h.writeExpenseQueue(expenseQueue); err != nil {
+		return QueueOperation{}, err
+	}
+
+	operations = append(operations[:target], operations[target+1:]...)
+	if err := h.writeQueueHistoryLocked(operations); err != nil {
+		HandleError(err, nil)
+	}
+	log.Printf("キューへの追加を取り消しました: household=%s, expense=%s", h.ID, op.ExpenseID)
+	return op, nil
+}
+
+// undoButton はキューへの追加の完了メッセージに表示する「取り消し」ボタン
+func undoButton(expenseID string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "↩️ 取り消し",
+					Style:    discordgo.DangerButton,
+					CustomID: "undo_expense:" + expenseID,
+				},
+			},
+		},
+	}
+}
+
+// handleUndo は /undo コマンドの処理（実行したユーザーの直近の操作を取り消す）
+func handleUndo(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	respondUndo(s, i, discordgo.InteractionResponseChannelMessageWithSource, "")
+}
+
+// handleUndoButton は「取り消し」ボタンの処理（ボタンを表示した追加の操作を取り消す）
+func handleUndoButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	expenseID := strings.TrimPrefix(i.MessageComponentData().CustomID, "undo_expense:")
+	respondUndo(s, i, discordgo.InteractionResponseUpdateMessage, expenseID)
+}
+
+// respondUndo は操作を取り消し、結果を応答する
+func respondUndo(s *discordgo.Session, i *discordgo.InteractionCreate, responseType discordgo.InteractionResponseType, expenseID string) {
+	h := householdForInteraction(i)
+	actorID := auditActorID(i)
+
+	var content string
+	op, err := h.undoQueueOperation(actorID, expenseID)
+	if err != nil {
+		HandleError(err, nil)
+		content = fmt.Sprintf("❌ %s", describeAnalysisError(err))
+		if responseType == discordgo.InteractionResponseUpdateMessage {
+			// 完了メッセージは残し、結果は別のメッセージで伝える
+			responseType = discordgo.InteractionResponseChannelMessageWithSource
+		}
+	} else {
+		h.recordAudit(AuditEntry{
+			ActorID:    actorID,
+			Action:     auditActionExpenseUndo,
+			EntityType: "expense",
+			EntityID:   op.ExpenseID,
+			RelatedID:  op.ID,
+			Changes:    diffAuditValues(h.expenseAuditValues(op.Expense), nil),
+		})
+		// 取り消した支出のメッセージを「支出として登録」から登録し直せるようにする
+		forgetSubmittedEntry(op.ExpenseID)
+		unlinkReceiptFromExpense(op.Expense.ReceiptKey, op.ExpenseID)
+		content = fmt.Sprintf("↩️ 支出 `%s` の追加を取り消しました。", op.ExpenseID)
+	}
+
+	data := &discordgo.InteractionResponseData{
+		Content: content,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	}
+	if responseType == discordgo.InteractionResponseUpdateMessage {
+		data.Components = []discordgo.MessageComponent{}
+	}
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: responseType, Data: data})
+	if err != nil {
+		log.Printf("取り消し応答エラー: %v", err)
+	}
+}
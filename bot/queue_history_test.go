@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUndoQueueOperationRemovesOwnAddition(t *testing.T) {
+	dir := t.TempDir()
+	h := &Household{HouseholdConfig: HouseholdConfig{ID: "home", ChannelID: "C1", DataDir: dir, QueueDir: dir}}
+	for _, add := range []struct {
+		actorID string
+		expense Expense
+	}{
+		{"alice", Expense{ID: "E1", Date: "2025-08-20", Price: 500}},
+		{"bob", Expense{ID: "E2", Date: "2025-08-20", Price: 800}},
+		{"alice", Expense{ID: "E3", Date: "2025-08-21", Price: 1200}},
+	} {
+		if err := h.saveExpenseToQueue(add.expense, add.actorID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 指定がなければ本人の直近の追加、指定があればその支出の追加を取り消す
+	for _, tt := range []struct {
+		actorID, expenseID string
+		want               string
+		remaining          []string
+	}{
+		{"alice", "", "E3", []string{"E1", "E2"}},
+		{"alice", "E1", "E1", []string{"E2"}},
+	} {
+		op, err := h.undoQueueOperation(tt.actorID, tt.expenseID)
+		if err != nil {
+			t.Fatalf("undoQueueOperation(%s, %q) returned error: %v", tt.actorID, tt.expenseID, err)
+		}
+		if op.ExpenseID != tt.want || op.Expense.ID != tt.want {
+			t.Errorf("undoQueueOperation(%s, %q) = %s, want %s", tt.actorID, tt.expenseID, op.ExpenseID, tt.want)
+		}
+		queue, err := h.loadExpenseQueue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, expense := range queue {
+			ids = append(ids, expense.ID)
+		}
+		if len(ids) != len(tt.remaining) || ids[0] != tt.remaining[0] {
+			t.Errorf("キュー = %v, want %v", ids, tt.remaining)
+		}
+	}
+
+	// 取り消した操作と他人の操作は取り消せない
+	if _, err := h.undoQueueOperation("alice", ""); err == nil {
+		t.Error("取り消し済みの操作を再度取り消しています")
+	}
+	if _, err := h.undoQueueOperation("alice", "E2"); err == nil {
+		t.Error("他のユーザーの追加を取り消しています")
+	}
+}
+
+func TestUndoQueueOperationRejectsSyncedExpense(t *testing.T) {
+	dir := t.TempDir()
+	h := &Household{HouseholdConfig: HouseholdConfig{ID: "home", ChannelID: "C1", DataDir: dir, QueueDir: dir}}
+	if err := h.saveExpenseToQueue(Expense{ID: "E1", Date: "2025-08-20", Price: 500}, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	// 同期処理がキューから取り込んだ状態
+	if err := h.writeExpenseQueue(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.undoQueueOperation("alice", ""); err == nil {
+		t.Error("同期済みの支出の追加を取り消しています")
+	}
+}
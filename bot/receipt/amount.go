@@ -0,0 +1,126 @@
+package receipt
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// =================================================================================
+// 金額の解析
+// =================================================================================
+
+const MaxAmount = 100_000_000 // 1件の支出として受け付ける金額の上限（桁の読み間違い対策）
+
+// amountNumberPattern は単位を除いた数値部分（整数または小数）にマッチする
+var amountNumberPattern = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+// amountTaxNotePattern は金額の後に括弧書きで付く税の補足（「(税込)」「（内税 116円）」など）にマッチする
+var amountTaxNotePattern = regexp.MustCompile(`\((?:税込み?|税抜き?|税別|内税|外税)[^()]*\)`)
+
+// amountNoiseReplacer は金額の前後に付く通貨記号・区切り文字・補足語を取り除く
+var amountNoiseReplacer = strings.NewReplacer(
+	"¥", "", "￥", "", "\\", "", "円", "", "jpy", "", "yen", "",
+	",", "", "，", "", "、", "", "_", "",
+	"税込み", "", "税込", "", "合計", "", "約", "",
+	" ", "", "　", "", "\t", "",
+)
+
+// ToHalfWidth は全角英数字・記号を半角にする
+func ToHalfWidth(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '！' && r <= '～' {
+			return r - 0xFEE0
+		}
+		if r == '　' {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// ParseAmount は「1,280円」「￥１２８０」「1280.0」「1.2万」「▲500」などの表記を円単位の整数にする
+// 負の値は返金・値引きとして扱い、小数は1円未満を四捨五入する
+// レシートの「¥1,280-」のような末尾の「-」や「(税込)」のような税の補足は金額の一部として扱わない
+func ParseAmount(input string) (int, error) {
+	text := strings.ToLower(ToHalfWidth(strings.TrimSpace(input)))
+	text = amountTaxNotePattern.ReplaceAllString(text, "")
+	text = amountNoiseReplacer.Replace(text)
+	text = strings.TrimRight(text, "-−")
+	if text == "" || text == "不明" {
+		return 0, &ValidationError{Message: "金額が入力されていません", Input: input}
+	}
+
+	// 負の値（-、−、レシートで使われる▲・△、括弧書き、「マイナス」）
+	negative := false
+	for _, prefix := range []string{"-", "−", "ー", "▲", "△", "マイナス"} {
+		if strings.HasPrefix(text, prefix) {
+			negative = true
+			text = strings.TrimPrefix(text, prefix)
+			break
+		}
+	}
+	if strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		negative = true
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "("), ")")
+	}
+	if text == "" {
+		return 0, &ValidationError{Message: "金額の数字がありません", Input: input}
+	}
+
+	value, err := parseAmountWithUnits(text)
+	if err != nil {
+		return 0, &ValidationError{Message: "金額として解釈できません", Input: input, Err: err}
+	}
+
+	amount := math.Round(value)
+	if amount > MaxAmount {
+		return 0, &ValidationError{Message: "金額が大きすぎます", Input: input}
+	}
+	if negative {
+		amount = -amount
+	}
+	return int(amount), nil
+}
+
+// parseAmountWithUnits は「1万2千」「1.2万」「3千500」のような万・千の単位を含む数値を解析する
+func parseAmountWithUnits(text string) (float64, error) {
+	var total float64
+	for _, unit := range []struct {
+		symbol     string
+		multiplier float64
+	}{
+		{"万", 10000},
+		{"千", 1000},
+	} {
+		before, after, found := strings.Cut(text, unit.symbol)
+		if !found {
+			continue
+		}
+		value, err := parseAmountNumber(before)
+		if err != nil {
+			return 0, err
+		}
+		total += value * unit.multiplier
+		text = after
+	}
+	if text == "" {
+		return total, nil
+	}
+
+	value, err := parseAmountNumber(text)
+	if err != nil {
+		return 0, err
+	}
+	return total + value, nil
+}
+
+// parseAmountNumber は数字と小数点だけからなる文字列を数値にする（指数表記などは受け付けない）
+func parseAmountNumber(text string) (float64, error) {
+	if !amountNumberPattern.MatchString(text) {
+		return 0, fmt.Errorf("invalid amount number: %q", text)
+	}
+	return strconv.ParseFloat(text, 64)
+}
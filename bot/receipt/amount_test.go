@@ -0,0 +1,67 @@
+package receipt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"1280", 1280},
+		{"1,280円", 1280},
+		{"￥１２８０", 1280},
+		{"¥1,280", 1280},
+		{"1280.0", 1280},
+		{"1280.5", 1281},
+		{"1.2万", 12000},
+		{"1万2千", 12000},
+		{"3千500", 3500},
+		{"税込1,280円", 1280},
+		{"約1,000円", 1000},
+
+		// レシートの末尾の「-」と税の補足
+		{"¥1,280-", 1280},
+		{"￥１，２８０－", 1280},
+		{"1280円(税込)", 1280},
+		{"1,280円（税込）", 1280},
+		{"1280円(税込み)", 1280},
+		{"1280(税抜)", 1280},
+		{"1280円(内税 116円)", 1280},
+		{"¥1,280-(税込)", 1280},
+
+		// 返金・値引き
+		{"-500", -500},
+		{"−500", -500},
+		{"▲500", -500},
+		{"△500", -500},
+		{"(500)", -500},
+		{"マイナス500", -500},
+		{"-500円(税込)", -500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseAmount(tt.input)
+			if err != nil {
+				t.Fatalf("ParseAmount(%q) returned error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAmount(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAmountRejectsInvalid(t *testing.T) {
+	for _, input := range []string{"", "不明", "円", "-", "abc", "1e5", "1.2.3", "100000001", "(税込)"} {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseAmount(input)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Errorf("ParseAmount(%q) error = %v, want *ValidationError", input, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package receipt
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// =================================================================================
+// AI応答のパース
+// =================================================================================
+
+// Analysis はレシート画像のAI解析結果
+type Analysis struct {
+	IsReceipt      bool           `json:"is_receipt"`
+	StoreName      *string        `json:"store_name"`
+	Date           *string        `json:"date"`
+	TotalAmount    *int           `json:"total_amount"`
+	PaymentMethod  *string        `json:"payment_method"`
+	Items          *string        `json:"items"`
+	ItemsTotal     *int           `json:"items_total,omitempty"`     // 明細の金額の合計（合計金額との突き合わせ用）
+	Confidence     map[string]int `json:"confidence,omitempty"`      // 項目 -> AIが返した確信度（0〜100）
+	PromptVersions []string       `json:"prompt_versions,omitempty"` // この結果を得るのに使ったプロンプトのバージョン
+}
+
+// 確信度・評価の対象となる項目
+const (
+	FieldDate    = "date"
+	FieldAmount  = "amount"
+	FieldPayment = "payment"
+	FieldStore   = "store"
+)
+
+// FieldLabels は項目の表示名（AIの応答の「確信度:」行でも同じ名前を使う）
+var FieldLabels = map[string]string{
+	FieldDate:    "日付",
+	FieldAmount:  "金額",
+	FieldPayment: "支払い方法",
+	FieldStore:   "店舗名",
+}
+
+// confidenceEntryPattern は「確信度:」行の「日付=90」のような項目ごとの値にマッチする
+var confidenceEntryPattern = regexp.MustCompile(`(日付|金額|支払い方法|店舗名)\s*[=＝:：]\s*(\d{1,3})`)
+
+// ParseConfidence は「確信度: 日付=90 金額=85 …」の行を項目 -> 確信度にする
+func ParseConfidence(line string) map[string]int {
+	confidence := make(map[string]int)
+	for _, match := range confidenceEntryPattern.FindAllStringSubmatch(ToHalfWidth(line), -1) {
+		value, err := strconv.Atoi(match[2])
+		if err != nil || value > 100 {
+			continue
+		}
+		for field, label := range FieldLabels {
+			if label == match[1] {
+				confidence[field] = value
+			}
+		}
+	}
+	return confidence
+}
+
+// ParseResponse はAIの応答テキストから各項目を読み取る
+// enhancePayment は読み取った支払い方法をマスターデータの名称に寄せる処理（不要な場合はnil）
+func ParseResponse(text string, enhancePayment func(string) string) Analysis {
+	var analysisResult Analysis
+	// 簡易的なパース（実際のレスポンス形式に応じて調整が必要）
+	lines := strings.Split(text, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		// 確信度・明細合計の行は項目名を含むため、他の項目より先に判定する
+		if strings.HasPrefix(line, "確信度:") {
+			analysisResult.Confidence = ParseConfidence(strings.TrimPrefix(line, "確信度:"))
+			continue
+		}
+		if strings.HasPrefix(line, "明細合計:") {
+			if itemsTotal, err := ParseAmount(strings.TrimPrefix(line, "明細合計:")); err == nil {
+				analysisResult.ItemsTotal = &itemsTotal
+			}
+			continue
+		}
+		if strings.Contains(line, "日付:") {
+			dateStr := strings.TrimSpace(strings.Split(line, ":")[1])
+			if dateStr != "" && dateStr != "不明" {
+				analysisResult.Date = &dateStr
+			}
+		}
+		if strings.Contains(line, "金額:") {
+			amountStr := strings.TrimSpace(strings.Split(line, ":")[1])
+			if amount, err := ParseAmount(amountStr); err == nil {
+				analysisResult.TotalAmount = &amount
+			} else {
+				log.Printf("AIが読み取った金額を解釈できません: %s (%v)", amountStr, err)
+			}
+		}
+		if strings.Contains(line, "店舗名:") {
+			storeStr := strings.TrimSpace(strings.Split(line, ":")[1])
+			if storeStr != "" && storeStr != "不明" {
+				analysisResult.StoreName = &storeStr
+			}
+		}
+		if strings.Contains(line, "支払い方法:") {
+			paymentStr := strings.TrimSpace(strings.Split(line, ":")[1])
+			if paymentStr != "" && paymentStr != "不明" {
+				// クレジット系の場合、より詳細な分類を試みる
+				if enhancePayment != nil {
+					paymentStr = enhancePayment(paymentStr)
+				}
+				analysisResult.PaymentMethod = &paymentStr
+			}
+		}
+		if strings.Contains(line, "詳細:") {
+			itemsStr := strings.TrimSpace(strings.Split(line, ":")[1])
+			if itemsStr != "" && itemsStr != "不明" {
+				analysisResult.Items = &itemsStr
+			}
+		}
+	}
+
+	// レシート判定：日付と金額が解析できた場合にtrue
+	analysisResult.IsReceipt = (analysisResult.Date != nil && analysisResult.TotalAmount != nil)
+	return analysisResult
+}
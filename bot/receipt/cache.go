@@ -0,0 +1,133 @@
+package receipt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// =================================================================================
+// AI応答のキャッシュ
+// =================================================================================
+
+// CacheEntry はキャッシュしたAIの応答1件
+type CacheEntry struct {
+	Response      string    `json:"response"`       // AIの応答テキスト（パース前のまま保存し、パーサーの変更も反映できるようにする）
+	Model         string    `json:"model"`          // 応答したモデル
+	PromptVersion string    `json:"prompt_version"` // 使用したプロンプトのバージョン
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ResponseCache は画像・モデル・プロンプトのバージョンごとにAIの応答をファイルに保存するキャッシュ
+type ResponseCache struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	entries map[string]CacheEntry
+}
+
+// CacheKey は画像の内容・モデル名・プロンプトのバージョンと本文からキャッシュのキーを作る
+// 本文には家計ごとのカテゴリー一覧が入るため、家計やカテゴリーが違えば別のキーになる
+func CacheKey(model, promptVersion, prompt string, images ...[]byte) string {
+	hash := sha256.New()
+	promptHash := sha256.Sum256([]byte(prompt))
+	fmt.Fprintf(hash, "%s\x00%s\x00%x\x00%d", model, promptVersion, promptHash, len(images))
+	for _, image := range images {
+		imageHash := sha256.Sum256(image)
+		hash.Write([]byte{0})
+		hash.Write(imageHash[:])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// NewResponseCache はファイルに保存するキャッシュを作る（ttlが0以下の場合は期限なし）
+func NewResponseCache(path string, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{path: path, ttl: ttl, entries: make(map[string]CacheEntry)}
+}
+
+// Load はキャッシュファイルを読み込み、期限切れの項目を除く（ファイルがない場合は空のまま）
+func (c *ResponseCache) Load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]CacheEntry)
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		c.entries = make(map[string]CacheEntry)
+		return err
+	}
+	c.pruneLocked(time.Now())
+	return nil
+}
+
+// Get は期限内のキャッシュがあれば応答を返す
+func (c *ResponseCache) Get(key string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.expired(entry, now) {
+		return "", false
+	}
+	return entry.Response, true
+}
+
+// Put は応答を保存してファイルに書き出す
+func (c *ResponseCache) Put(key string, entry CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = entry
+	c.pruneLocked(time.Now())
+	return c.saveLocked()
+}
+
+// Clear はすべての応答を削除し、削除した件数を返す
+func (c *ResponseCache) Clear() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := len(c.entries)
+	c.entries = make(map[string]CacheEntry)
+	return count, c.saveLocked()
+}
+
+// Len は保存している応答の件数を返す
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// expired は項目が期限切れかを返す
+func (c *ResponseCache) expired(entry CacheEntry, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(entry.CreatedAt) > c.ttl
+}
+
+// pruneLocked は期限切れの項目を削除する（muを保持して呼ぶこと）
+func (c *ResponseCache) pruneLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if c.expired(entry, now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// saveLocked はキャッシュをファイルに書き出す（muを保持して呼ぶこと）
+func (c *ResponseCache) saveLocked() error {
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
@@ -0,0 +1,28 @@
+package receipt
+
+import "testing"
+
+func TestCacheKey(t *testing.T) {
+	image := []byte("receipt")
+	base := CacheKey("gemini-2.5-flash", "receipt.v1", "カテゴリー: 食費, 日用品", image)
+
+	tests := []struct {
+		name string
+		key  string
+		same bool
+	}{
+		{"同じ入力", CacheKey("gemini-2.5-flash", "receipt.v1", "カテゴリー: 食費, 日用品", image), true},
+		{"カテゴリーが違う", CacheKey("gemini-2.5-flash", "receipt.v1", "カテゴリー: 食費", image), false},
+		{"プロンプトのバージョンが違う", CacheKey("gemini-2.5-flash", "receipt.v2", "カテゴリー: 食費, 日用品", image), false},
+		{"モデルが違う", CacheKey("gemini-2.5-pro", "receipt.v1", "カテゴリー: 食費, 日用品", image), false},
+		{"画像が違う", CacheKey("gemini-2.5-flash", "receipt.v1", "カテゴリー: 食費, 日用品", []byte("other")), false},
+		{"画像の枚数が違う", CacheKey("gemini-2.5-flash", "receipt.v1", "カテゴリー: 食費, 日用品", image, image), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.key == base) != tt.same {
+				t.Errorf("CacheKey() same = %t, want %t", tt.key == base, tt.same)
+			}
+		})
+	}
+}
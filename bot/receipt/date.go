@@ -0,0 +1,160 @@
+package receipt
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =================================================================================
+// 日付の正規化
+// =================================================================================
+
+const ISODateLayout = "2006-01-02"     // キューに保存する日付の形式
+const maxFutureDaysForInferredYear = 7 // 年を補った日付がこれより先の未来になる場合は前年とみなす
+
+// TokyoLocation は日付の解釈に使うタイムゾーン（サーバーのタイムゾーンに依存させない）
+var TokyoLocation = loadTokyoLocation()
+
+// eraStartYears は元号ごとの元年の西暦
+var eraStartYears = map[string]int{
+	"令和": 2019, "r": 2019,
+	"平成": 1989, "h": 1989,
+	"昭和": 1926, "s": 1926,
+}
+
+// weekdayNames は曜日の漢字表記（月曜始まりの週で数えるためのオフセット順）
+var weekdayNames = map[string]int{
+	"月": 0, "火": 1, "水": 2, "木": 3, "金": 4, "土": 5, "日": 6,
+}
+
+var (
+	eraDatePattern      = regexp.MustCompile(`^(令和|平成|昭和|r|h|s)(元|\d{1,2})[年./-](\d{1,2})[月./-](\d{1,2})日?$`)
+	fullDatePattern     = regexp.MustCompile(`^(\d{4}|\d{2})[年./-](\d{1,2})[月./-](\d{1,2})日?$`)
+	compactDatePattern  = regexp.MustCompile(`^(\d{4})(\d{2})(\d{2})$`)
+	partialDatePattern  = regexp.MustCompile(`^(\d{1,2})[月./-](\d{1,2})日?$`)
+	relativeWeekPattern = regexp.MustCompile(`^(先々週|先週|今週)?([月火水木金土日])曜日?$`)
+	weekdaySuffix       = regexp.MustCompile(`[(（][月火水木金土日](曜日?)?[)）]$`)
+	timeSuffix          = regexp.MustCompile(`\d{1,2}:\d{2}(:\d{2})?$`)
+)
+
+// loadTokyoLocation はAsia/Tokyoを読み込む（タイムゾーンデータがない環境では固定のJSTを使う）
+func loadTokyoLocation() *time.Location {
+	location, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		log.Printf("Asia/Tokyoの読み込みに失敗したため固定のJSTを使用します: %v", err)
+		return time.FixedZone("JST", 9*60*60)
+	}
+	return location
+}
+
+// normalizeDateText は全角文字・空白・曜日・時刻を取り除いて解析しやすい形にする
+func normalizeDateText(input string) string {
+	text := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t':
+			return -1
+		case 'ー', '―', '‐':
+			return '-'
+		}
+		return r
+	}, ToHalfWidth(strings.TrimSpace(input)))
+
+	text = strings.ToLower(text)
+	text = timeSuffix.ReplaceAllString(text, "")
+	text = weekdaySuffix.ReplaceAllString(text, "")
+	return text
+}
+
+// NormalizeDate は様々な表記の日付をISO形式（YYYY-MM-DD）に変換する
+// 和暦（R7.8.19、令和7年8月19日）、年の省略（8/19）、昨日・先週金曜などの相対表現に対応する
+func NormalizeDate(input string, now time.Time) (string, error) {
+	now = now.In(TokyoLocation)
+	text := normalizeDateText(input)
+	if text == "" || text == "不明" {
+		return "", &ValidationError{Message: "日付が入力されていません", Input: input}
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, TokyoLocation)
+
+	// 1. 相対表現
+	switch text {
+	case "今日", "本日":
+		return today.Format(ISODateLayout), nil
+	case "昨日":
+		return today.AddDate(0, 0, -1).Format(ISODateLayout), nil
+	case "一昨日", "おととい":
+		return today.AddDate(0, 0, -2).Format(ISODateLayout), nil
+	}
+	if match := relativeWeekPattern.FindStringSubmatch(text); match != nil {
+		// 「金曜」だけの場合は直近（今日を含む）のその曜日とする
+		if match[1] == "" {
+			daysBack := ((int(today.Weekday())+6)%7 - weekdayNames[match[2]] + 7) % 7
+			return today.AddDate(0, 0, -daysBack).Format(ISODateLayout), nil
+		}
+		// 週は月曜始まりで数える
+		mondayOffset := (int(today.Weekday()) + 6) % 7
+		weekStart := today.AddDate(0, 0, -mondayOffset)
+		switch match[1] {
+		case "先週":
+			weekStart = weekStart.AddDate(0, 0, -7)
+		case "先々週":
+			weekStart = weekStart.AddDate(0, 0, -14)
+		}
+		return weekStart.AddDate(0, 0, weekdayNames[match[2]]).Format(ISODateLayout), nil
+	}
+
+	// 2. 和暦
+	if match := eraDatePattern.FindStringSubmatch(text); match != nil {
+		eraYear := 1
+		if match[2] != "元" {
+			eraYear, _ = strconv.Atoi(match[2])
+		}
+		if eraYear < 1 {
+			return "", &ValidationError{Message: "和暦の年が正しくありません", Input: input}
+		}
+		return buildISODate(input, eraStartYears[match[1]]+eraYear-1, match[3], match[4])
+	}
+
+	// 3. 年を含む西暦（2025/8/19、2025.08.19、2025年8月19日、25/8/19）
+	if match := fullDatePattern.FindStringSubmatch(text); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		if len(match[1]) == 2 {
+			year += 2000
+		}
+		return buildISODate(input, year, match[2], match[3])
+	}
+	if match := compactDatePattern.FindStringSubmatch(text); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		return buildISODate(input, year, match[2], match[3])
+	}
+
+	// 4. 年を省略した日付（今年として扱い、未来になりすぎる場合は前年とする）
+	if match := partialDatePattern.FindStringSubmatch(text); match != nil {
+		date, err := buildISODate(input, today.Year(), match[1], match[2])
+		if err != nil {
+			return "", err
+		}
+		parsed, _ := time.ParseInLocation(ISODateLayout, date, TokyoLocation)
+		if parsed.After(today.AddDate(0, 0, maxFutureDaysForInferredYear)) {
+			return buildISODate(input, today.Year()-1, match[1], match[2])
+		}
+		return date, nil
+	}
+
+	return "", &ValidationError{Message: "日付の形式を解釈できません", Input: input}
+}
+
+// buildISODate は年月日から存在する日付かを確認してISO形式にする
+func buildISODate(input string, year int, monthStr, dayStr string) (string, error) {
+	month, _ := strconv.Atoi(monthStr)
+	day, _ := strconv.Atoi(dayStr)
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, TokyoLocation)
+	// 2月30日のような存在しない日付はtime.Dateが繰り上げるため、元の値と比較して検出する
+	if month < 1 || month > 12 || date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return "", &ValidationError{Message: "存在しない日付です", Input: input}
+	}
+	return date.Format(ISODateLayout), nil
+}
@@ -0,0 +1,90 @@
+package receipt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeDate(t *testing.T) {
+	// 2025-08-20（水）の正午を基準にする
+	now := time.Date(2025, 8, 20, 12, 0, 0, 0, TokyoLocation)
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		// 相対表現
+		{"今日", "2025-08-20"},
+		{"本日", "2025-08-20"},
+		{"昨日", "2025-08-19"},
+		{"一昨日", "2025-08-18"},
+		{"おととい", "2025-08-18"},
+		{"水曜", "2025-08-20"},
+		{"金曜", "2025-08-15"},
+		{"今週月曜", "2025-08-18"},
+		{"先週金曜", "2025-08-15"},
+		{"先週金曜日", "2025-08-15"},
+		{"先々週日曜", "2025-08-10"},
+
+		// 和暦
+		{"R7.8.19", "2025-08-19"},
+		{"令和7年8月19日", "2025-08-19"},
+		{"令和元年5月1日", "2019-05-01"},
+		{"H31.4.30", "2019-04-30"},
+		{"昭和64年1月7日", "1989-01-07"},
+
+		// 年を含む西暦
+		{"2025/8/19", "2025-08-19"},
+		{"2025.08.19", "2025-08-19"},
+		{"2025-08-19", "2025-08-19"},
+		{"2025年8月19日", "2025-08-19"},
+		{"25/8/19", "2025-08-19"},
+		{"20250819", "2025-08-19"},
+		{"２０２５／８／１９", "2025-08-19"},
+		{"2025/08/19(火)", "2025-08-19"},
+		{"2025/08/19（火曜日）", "2025-08-19"},
+		{"2025/08/19 14:32", "2025-08-19"},
+		{"2024/2/29", "2024-02-29"},
+
+		// 年を省略した日付
+		{"8/19", "2025-08-19"},
+		{"8月19日", "2025-08-19"},
+		{"8/27", "2025-08-27"},
+		{"8/28", "2024-08-28"},
+		{"12/31", "2024-12-31"},
+		{"1/5", "2025-01-05"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := NormalizeDate(tt.input, now)
+			if err != nil {
+				t.Fatalf("NormalizeDate(%q) returned error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeDate(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeDateUsesTokyoDate(t *testing.T) {
+	// UTCでは前日でも日本時間では翌日になっている
+	now := time.Date(2025, 8, 19, 16, 0, 0, 0, time.UTC)
+	if got, err := NormalizeDate("今日", now); err != nil || got != "2025-08-20" {
+		t.Errorf("NormalizeDate(今日) = %s, %v, want 2025-08-20", got, err)
+	}
+}
+
+func TestNormalizeDateRejectsInvalid(t *testing.T) {
+	now := time.Date(2025, 8, 20, 12, 0, 0, 0, TokyoLocation)
+	for _, input := range []string{"", "不明", "2025/2/30", "2025/13/1", "2025/2/29", "令和0年1月1日", "2/30", "来週金曜", "abc"} {
+		t.Run(input, func(t *testing.T) {
+			_, err := NormalizeDate(input, now)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Errorf("NormalizeDate(%q) error = %v, want *ValidationError", input, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// Package receipt はレシート解析のプロンプト・AI応答のパース・金額と日付の正規化をまとめたもの
+// Botと評価ツール（cmd/receipt-eval）で同じ処理を使うために分けている
+package receipt
+
+import "fmt"
+
+// ValidationError は入力を解釈できなかった理由（ユーザー向けの文章）を持つエラー
+type ValidationError struct {
+	Message string // ユーザー向けの理由
+	Input   string // 解釈しようとした入力
+	Err     error  // 原因となったエラー（ない場合はnil）
+}
+
+func (e *ValidationError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %q (%v)", e.Message, e.Input, e.Err)
+	}
+	return fmt.Sprintf("%s: %q", e.Message, e.Input)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,294 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// =================================================================================
+// 画像形式の判定・変換
+// =================================================================================
+
+const maxImageDimension = 2048 // AIに送る画像の長辺の上限（ピクセル）
+const maxImageBytes = 4 << 20  // これを超える画像は再圧縮する
+const resizedJPEGQuality = 85  // 縮小・再圧縮時のJPEG品質
+const maxPDFPages = 5          // 電子レシートPDFから変換する最大ページ数
+const pdfRenderDPI = "150"     // PDFを画像に変換する際の解像度
+
+// PreparedImage はAIに送信できる形式に変換済みの画像
+type PreparedImage struct {
+	MIMEType string
+	Data     []byte
+}
+
+// Format はgenai.ImageDataに渡す形式名（"jpeg"、"png"など）を返す
+func (p PreparedImage) Format() string {
+	return strings.TrimPrefix(p.MIMEType, "image/")
+}
+
+// ImageErrorKind は画像を変換できなかった理由の分類
+type ImageErrorKind int
+
+const (
+	ImageUnsupported      ImageErrorKind = iota // 対応していない形式・壊れた画像
+	ImageToolMissing                            // 変換に使う外部ツールがない
+	ImageConversionFailed                       // 変換処理の失敗
+)
+
+// ImageError は画像を変換できなかった理由を持つエラー
+type ImageError struct {
+	Kind    ImageErrorKind
+	Message string // ユーザー向けの理由
+	Detail  string // MIMEタイプやツールの出力など（ない場合は空）
+	Err     error  // 原因となったエラー（ない場合はnil）
+}
+
+func (e *ImageError) Error() string {
+	message := e.Message
+	if e.Detail != "" {
+		message += fmt.Sprintf(" (%s)", e.Detail)
+	}
+	if e.Err != nil {
+		message += fmt.Sprintf(": %v", e.Err)
+	}
+	return message
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+// DetectImageMIME は内容から実際のMIMEタイプを判定する（判定できなければ宣言値を使う）
+func DetectImageMIME(data []byte, declared string) string {
+	// HEIC/HEIFはISO BMFFのftypボックスのブランドで判定する
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch string(data[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis":
+			return "image/heic"
+		case "mif1", "msf1", "heif":
+			return "image/heif"
+		}
+	}
+
+	detected := http.DetectContentType(data)
+	if detected != "application/octet-stream" {
+		return strings.SplitN(detected, ";", 2)[0]
+	}
+	return declared
+}
+
+// PrepareImages は受信したファイルをAIに送れる画像に変換する（PDFは複数ページになりうる）
+// Botと評価ツールで同じ画像をAIに送るため、変換・縮小はここにまとめる
+func PrepareImages(data []byte, declared string) ([]PreparedImage, error) {
+	mimeType := DetectImageMIME(data, declared)
+	log.Printf("画像形式を判定: %s (宣言: %s, %d bytes)", mimeType, declared, len(data))
+
+	var images []PreparedImage
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/webp":
+		images = []PreparedImage{{MIMEType: mimeType, Data: data}}
+	case "image/gif":
+		// GIFはAIが対応していないため1フレーム目をJPEGに変換する
+		converted, err := reencodeAsJPEG(data)
+		if err != nil {
+			return nil, err
+		}
+		images = []PreparedImage{{MIMEType: "image/jpeg", Data: converted}}
+	case "image/heic", "image/heif":
+		converted, err := convertHEICToJPEG(data)
+		if err != nil {
+			return nil, err
+		}
+		images = []PreparedImage{{MIMEType: "image/jpeg", Data: converted}}
+	case "application/pdf":
+		pages, err := convertPDFToJPEGs(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			images = append(images, PreparedImage{MIMEType: "image/jpeg", Data: page})
+		}
+	default:
+		return nil, &ImageError{Kind: ImageUnsupported, Message: "対応していないファイル形式です", Detail: mimeType}
+	}
+
+	for idx := range images {
+		images[idx] = downscaleIfNeeded(images[idx])
+	}
+	return images, nil
+}
+
+// reencodeAsJPEG は標準ライブラリでデコードできる画像をJPEGに変換する
+func reencodeAsJPEG(data []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &ImageError{Kind: ImageUnsupported, Message: "画像のデコードに失敗", Err: err}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: resizedJPEGQuality}); err != nil {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "JPEGへの変換に失敗", Err: err}
+	}
+	return buf.Bytes(), nil
+}
+
+// downscaleIfNeeded は大きすぎる写真を縮小・再圧縮する（失敗時は元の画像をそのまま使う）
+func downscaleIfNeeded(img PreparedImage) PreparedImage {
+	config, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		// WebPなど標準ライブラリで扱えない形式はそのまま送る
+		return img
+	}
+	if config.Width <= maxImageDimension && config.Height <= maxImageDimension && len(img.Data) <= maxImageBytes {
+		return img
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		log.Printf("縮小用の画像デコードに失敗したため元画像を使用します: %v", err)
+		return img
+	}
+
+	resized := resizeToFit(decoded, maxImageDimension)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: resizedJPEGQuality}); err != nil {
+		log.Printf("縮小画像のエンコードに失敗したため元画像を使用します: %v", err)
+		return img
+	}
+
+	log.Printf("画像を縮小しました: %dx%d (%d bytes) -> %dx%d (%d bytes)",
+		config.Width, config.Height, len(img.Data), resized.Bounds().Dx(), resized.Bounds().Dy(), buf.Len())
+	return PreparedImage{MIMEType: "image/jpeg", Data: buf.Bytes()}
+}
+
+// resizeToFit は長辺がmaxSide以下になるよう面積平均で縮小する
+func resizeToFit(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return src
+	}
+
+	newWidth, newHeight := maxSide, maxSide
+	if width > height {
+		newHeight = max(1, height*maxSide/width)
+	} else {
+		newWidth = max(1, width*maxSide/height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		sy0 := bounds.Min.Y + y*height/newHeight
+		sy1 := max(sy0+1, bounds.Min.Y+(y+1)*height/newHeight)
+		for x := 0; x < newWidth; x++ {
+			sx0 := bounds.Min.X + x*width/newWidth
+			sx1 := max(sx0+1, bounds.Min.X+(x+1)*width/newWidth)
+
+			var r, g, b, a, count uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					count++
+				}
+			}
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / count >> 8)
+			dst.Pix[offset+1] = uint8(g / count >> 8)
+			dst.Pix[offset+2] = uint8(b / count >> 8)
+			dst.Pix[offset+3] = uint8(a / count >> 8)
+		}
+	}
+	return dst
+}
+
+// convertHEICToJPEG はローカルの変換ツール（heif-convert または ImageMagick）でHEICをJPEGにする
+func convertHEICToJPEG(data []byte) ([]byte, error) {
+	workDir, err := os.MkdirTemp("", "yarikuri-heic-")
+	if err != nil {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "HEIC変換用の一時ディレクトリ作成に失敗", Err: err}
+	}
+	defer os.RemoveAll(workDir)
+
+	inputPath := filepath.Join(workDir, "input.heic")
+	outputPath := filepath.Join(workDir, "output.jpg")
+	if err := os.WriteFile(inputPath, data, 0600); err != nil {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "HEIC変換用の一時ファイル作成に失敗", Err: err}
+	}
+
+	var cmd *exec.Cmd
+	if path, err := exec.LookPath("heif-convert"); err == nil {
+		cmd = exec.Command(path, "-q", "90", inputPath, outputPath)
+	} else if path, err := exec.LookPath("magick"); err == nil {
+		cmd = exec.Command(path, inputPath, outputPath)
+	} else if path, err := exec.LookPath("convert"); err == nil {
+		cmd = exec.Command(path, inputPath, outputPath)
+	} else {
+		return nil, &ImageError{Kind: ImageToolMissing, Message: "HEIC変換ツールが見つかりません（heif-convert または ImageMagick をインストールしてください）"}
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "HEICからJPEGへの変換に失敗", Err: err,
+			Detail: cmd.Path + ": " + string(output)}
+	}
+
+	converted, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "変換後のJPEGの読み込みに失敗", Err: err}
+	}
+	return converted, nil
+}
+
+// convertPDFToJPEGs はpdftoppmで電子レシートPDFの各ページをJPEGにする
+func convertPDFToJPEGs(data []byte) ([][]byte, error) {
+	pdftoppm, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		return nil, &ImageError{Kind: ImageToolMissing, Message: "PDF変換ツールが見つかりません（poppler-utils をインストールしてください）", Err: err}
+	}
+
+	workDir, err := os.MkdirTemp("", "yarikuri-pdf-")
+	if err != nil {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "PDF変換用の一時ディレクトリ作成に失敗", Err: err}
+	}
+	defer os.RemoveAll(workDir)
+
+	inputPath := filepath.Join(workDir, "input.pdf")
+	if err := os.WriteFile(inputPath, data, 0600); err != nil {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "PDF変換用の一時ファイル作成に失敗", Err: err}
+	}
+
+	cmd := exec.Command(pdftoppm, "-jpeg", "-r", pdfRenderDPI, "-l", strconv.Itoa(maxPDFPages), inputPath, filepath.Join(workDir, "page"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "PDFから画像への変換に失敗", Err: err, Detail: string(output)}
+	}
+
+	pagePaths, err := filepath.Glob(filepath.Join(workDir, "page*.jpg"))
+	if err != nil || len(pagePaths) == 0 {
+		return nil, &ImageError{Kind: ImageConversionFailed, Message: "PDFから画像が生成されませんでした", Err: err}
+	}
+	// pdftoppmはページ番号をゼロ埋めして出力するため、名前順がページ順になる
+	sort.Strings(pagePaths)
+
+	var pages [][]byte
+	for _, pagePath := range pagePaths {
+		page, err := os.ReadFile(pagePath)
+		if err != nil {
+			return nil, &ImageError{Kind: ImageConversionFailed, Message: "変換後のページ画像の読み込みに失敗", Err: err, Detail: pagePath}
+		}
+		pages = append(pages, page)
+	}
+	log.Printf("PDFを%dページの画像に変換しました", len(pages))
+	return pages, nil
+}
@@ -0,0 +1,38 @@
+package receipt
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"os"
+	"testing"
+)
+
+func TestPrepareImagesKeepsReceiptPhotosWithinLimits(t *testing.T) {
+	data, err := os.ReadFile("../img/20250831_110948.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := PrepareImages(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("PrepareImages() returned error: %v", err)
+	}
+	if len(images) != 1 || images[0].Format() != "jpeg" {
+		t.Fatalf("PrepareImages() = %d枚 (%v), want 1枚のjpeg", len(images), images)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(images[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width > maxImageDimension || config.Height > maxImageDimension || len(images[0].Data) > maxImageBytes {
+		t.Errorf("変換後の画像 %dx%d (%d bytes) が上限を超えています", config.Width, config.Height, len(images[0].Data))
+	}
+}
+
+func TestPrepareImagesRejectsUnsupported(t *testing.T) {
+	_, err := PrepareImages([]byte("レシートではありません"), "text/plain")
+	var imageErr *ImageError
+	if !errors.As(err, &imageErr) || imageErr.Kind != ImageUnsupported {
+		t.Errorf("PrepareImages() error = %v, want ImageUnsupported", err)
+	}
+}
@@ -0,0 +1,81 @@
+package receipt
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// =================================================================================
+// マスターデータ（PostgreSQLのダンプファイル）
+// =================================================================================
+
+// MasterCategory はマスターデータのカテゴリー
+type MasterCategory struct {
+	ID   int
+	Name string
+}
+
+// ParseTableData はダンプファイルから指定したテーブルのCOPYブロックを行・列に分けて返す
+func ParseTableData(sqlContent, tableName string) ([][]string, error) {
+	startMarker := "COPY public." + tableName
+	endMarker := "\\."
+	startIndex := strings.Index(sqlContent, startMarker)
+	if startIndex == -1 {
+		return nil, nil
+	}
+	dataStartIndex := strings.Index(sqlContent[startIndex:], ";")
+	if dataStartIndex == -1 {
+		return nil, nil
+	}
+	dataBlockStartIndex := startIndex + dataStartIndex + 1
+	endIndex := strings.Index(sqlContent[dataBlockStartIndex:], endMarker)
+	if endIndex == -1 {
+		return nil, nil
+	}
+	dataBlock := sqlContent[dataBlockStartIndex : dataBlockStartIndex+endIndex]
+	lines := strings.Split(strings.TrimSpace(dataBlock), "\n")
+	var records [][]string
+	for _, line := range lines {
+		if line != "" {
+			records = append(records, strings.Split(line, "\t"))
+		}
+	}
+	return records, nil
+}
+
+// ParseMasterCategories はダンプファイルのカテゴリーを表示順（日本語の名前が先）で返す
+// プロンプトに渡すカテゴリーの順序をBotと評価ツールで揃えるため、読み込みはここにまとめる
+func ParseMasterCategories(sqlContent string) []MasterCategory {
+	records, _ := ParseTableData(sqlContent, "category_list")
+	categories := make([]MasterCategory, 0, len(records))
+	for _, rec := range records {
+		if len(rec) < 2 {
+			continue
+		}
+		id, _ := strconv.Atoi(strings.TrimSpace(rec[0]))
+		categories = append(categories, MasterCategory{ID: id, Name: strings.TrimSpace(rec[1])})
+	}
+	sort.Slice(categories, func(i, j int) bool { return SortJapaneseFirst(categories[i].Name, categories[j].Name) })
+	return categories
+}
+
+// isJapanese はひらがな・カタカナ・漢字を含むかを返す
+func isJapanese(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han) {
+			return true
+		}
+	}
+	return false
+}
+
+// SortJapaneseFirst は日本語の名前を先に、同じ種類どうしは文字列順に並べる比較関数
+func SortJapaneseFirst(s1, s2 string) bool {
+	isJp1, isJp2 := isJapanese(s1), isJapanese(s2)
+	if isJp1 != isJp2 {
+		return isJp1
+	}
+	return s1 < s2
+}
@@ -0,0 +1,18 @@
+package receipt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMasterCategories(t *testing.T) {
+	dump := "COPY public.group_list (id, name) FROM stdin;\n1\t家族\n\\.\n" +
+		"COPY public.category_list (id, name) FROM stdin;\n3\tAmazon\n2\t食費\n1\t日用品\n\n\\.\n"
+	want := []MasterCategory{{ID: 1, Name: "日用品"}, {ID: 2, Name: "食費"}, {ID: 3, Name: "Amazon"}}
+	if got := ParseMasterCategories(dump); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMasterCategories() = %+v, want %+v", got, want)
+	}
+	if got := ParseMasterCategories("COPY public.group_list (id, name) FROM stdin;\n1\t家族\n\\.\n"); len(got) != 0 {
+		t.Errorf("ParseMasterCategories() without category_list = %+v, want none", got)
+	}
+}
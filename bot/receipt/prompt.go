@@ -0,0 +1,218 @@
+package receipt
+
+import (
+	"crypto/sha256"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// =================================================================================
+// プロンプトテンプレート
+// =================================================================================
+
+// Model はレシート解析に使うGeminiのモデル
+const Model = "gemini-1.5-flash-latest"
+
+// プロンプトの種類（テンプレートファイル名の先頭）
+const (
+	ReceiptPrompt = "receipt" // レシート画像の読み取り
+	DetailPrompt  = "detail"  // 詳細説明の生成
+	AskPrompt     = "ask"     // 支出についての質問の検索条件への変換
+	EntryPrompt   = "entry"   // 1行のテキスト入力の項目への分解
+)
+
+// defaultPromptFiles はビルドに埋め込む既定のテンプレート
+//
+//go:embed prompts/*.tmpl
+var defaultPromptFiles embed.FS
+
+const overrideHashLength = 8 // 上書きしたテンプレートのバージョンに付ける内容のハッシュの桁数
+
+// promptFilePattern は「<種類>.v<番号>.tmpl」形式のテンプレートファイル名にマッチする
+var promptFilePattern = regexp.MustCompile(`^([a-z_]+)\.v(\d+)\.tmpl$`)
+
+// promptFuncs はテンプレートで使える関数
+var promptFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// ReceiptPromptData はレシート読み取りのテンプレートに渡す値
+type ReceiptPromptData struct {
+	Categories   []string // マスターデータのカテゴリー名
+	Stitched     bool     // 長いレシートを分割撮影した複数画像か
+	ImageCount   int      // 画像の枚数
+	ImageNumbers []int    // 1からImageCountまでの番号（画像ごとの商品の書き出し用）
+}
+
+// NewReceiptPromptData はレシート読み取りのテンプレートに渡す値を作る
+func NewReceiptPromptData(categories []string, imageCount int, stitched bool) ReceiptPromptData {
+	data := ReceiptPromptData{
+		Categories: categories,
+		Stitched:   stitched && imageCount > 1,
+		ImageCount: imageCount,
+	}
+	for idx := 1; idx <= imageCount; idx++ {
+		data.ImageNumbers = append(data.ImageNumbers, idx)
+	}
+	return data
+}
+
+// DetailPromptData は詳細説明生成のテンプレートに渡す値
+type DetailPromptData struct {
+	Category      string   // カテゴリー名
+	Analysis      Analysis // レシートの解析結果
+	StoreName     string
+	Items         string
+	PaymentMethod string
+	Samples       string // このカテゴリーの詳細説明のサンプル（1行1件）
+}
+
+// NewDetailPromptData は解析結果とカテゴリーから詳細説明生成のテンプレートに渡す値を作る
+func NewDetailPromptData(category string, analysis Analysis, samples string) DetailPromptData {
+	data := DetailPromptData{Category: category, Analysis: analysis, Samples: samples}
+	if analysis.StoreName != nil {
+		data.StoreName = *analysis.StoreName
+	}
+	if analysis.Items != nil {
+		data.Items = *analysis.Items
+	}
+	if analysis.PaymentMethod != nil {
+		data.PaymentMethod = *analysis.PaymentMethod
+	}
+	return data
+}
+
+// AskPromptData は支出についての質問を検索条件に変換するテンプレートに渡す値
+type AskPromptData struct {
+	Question   string   // ユーザーの質問
+	Today      string   // 今日の日付（「先月」などの相対表現の基準）
+	Weekday    string   // 今日の曜日
+	Categories []string // マスターデータのカテゴリー名
+	Groups     []string // マスターデータのグループ名
+	Users      []string // マスターデータのユーザー名
+}
+
+// EntryPromptData は1行のテキスト入力を項目に分解するテンプレートに渡す値
+type EntryPromptData struct {
+	Text           string   // ユーザーが投稿したテキスト
+	Today          string   // 今日の日付（「昨日」などの相対表現の基準）
+	Weekday        string   // 今日の曜日
+	Categories     []string // マスターデータのカテゴリー名
+	Groups         []string // マスターデータのグループ名
+	Users          []string // マスターデータのユーザー名
+	PaymentMethods []string // マスターデータの支払い方法
+}
+
+// PromptTemplate はバージョン付きのテンプレート1件
+type PromptTemplate struct {
+	Name    string // 種類（receipt、detail）
+	Version string // 「receipt.v1」のような種類とバージョン（支出やキャッシュに記録する。上書きしたものは「receipt.v1+1a2b3c4d」のように内容のハッシュ付き）
+	Source  string // 読み込んだファイル（埋め込みの場合は「embedded:」付き）
+	number  int
+	tmpl    *template.Template
+}
+
+// PromptSet は種類ごとに使用するテンプレート
+type PromptSet struct {
+	templates map[string]*PromptTemplate
+}
+
+// LoadPrompts は埋め込みの既定テンプレートを読み込み、dirにあるテンプレートで上書きする
+// 種類ごとに番号が最も大きいバージョンを使う（dirが空または存在しない場合は既定のみ）
+func LoadPrompts(dir string) (*PromptSet, error) {
+	set := &PromptSet{templates: make(map[string]*PromptTemplate)}
+	if err := set.addFrom(defaultPromptFiles, "prompts", "embedded:"); err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return set, nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return set, nil
+	}
+	if err := set.addFrom(os.DirFS(dir), ".", ""); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// addFrom はファイルシステムのテンプレートを読み込み、同じ種類の既存のものより番号が大きければ置き換える
+// 同じ番号の場合は後から読み込んだもの（プロンプトのディレクトリ）を優先する
+// 埋め込み以外のテンプレートはバージョンに内容のハッシュを付け、番号を変えずに文面を変えても区別できるようにする
+func (p *PromptSet) addFrom(fsys fs.FS, dir, sourcePrefix string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("プロンプトのディレクトリを読み込めません: %w", err)
+	}
+	for _, entry := range entries {
+		match := promptFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(match[2])
+		if current, exists := p.templates[match[1]]; exists && current.number > number {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, filepath.ToSlash(filepath.Join(dir, entry.Name())))
+		if err != nil {
+			return fmt.Errorf("プロンプトを読み込めません (%s): %w", entry.Name(), err)
+		}
+		tmpl, err := template.New(entry.Name()).Funcs(promptFuncs).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("プロンプトの構文エラー (%s): %w", entry.Name(), err)
+		}
+		version := fmt.Sprintf("%s.v%d", match[1], number)
+		if sourcePrefix == "" {
+			hash := sha256.Sum256(content)
+			version += fmt.Sprintf("+%x", hash[:overrideHashLength/2])
+		}
+		p.templates[match[1]] = &PromptTemplate{
+			Name:    match[1],
+			Version: version,
+			Source:  sourcePrefix + entry.Name(),
+			number:  number,
+			tmpl:    tmpl,
+		}
+	}
+	return nil
+}
+
+// Render はテンプレートに値を当てはめ、プロンプトと使用したバージョンを返す
+func (p *PromptSet) Render(name string, data any) (string, string, error) {
+	promptTemplate, exists := p.templates[name]
+	if !exists {
+		return "", "", fmt.Errorf("プロンプト「%s」がありません", name)
+	}
+	var builder strings.Builder
+	if err := promptTemplate.tmpl.Execute(&builder, data); err != nil {
+		return "", "", fmt.Errorf("プロンプト「%s」の生成に失敗: %w", promptTemplate.Version, err)
+	}
+	return builder.String(), promptTemplate.Version, nil
+}
+
+// Version は種類ごとに使用するテンプレートのバージョンを返す（ない場合は空）
+func (p *PromptSet) Version(name string) string {
+	if promptTemplate, exists := p.templates[name]; exists {
+		return promptTemplate.Version
+	}
+	return ""
+}
+
+// Templates は読み込んだテンプレートを種類の名前順に返す
+func (p *PromptSet) Templates() []*PromptTemplate {
+	var templates []*PromptTemplate
+	for _, promptTemplate := range p.templates {
+		templates = append(templates, promptTemplate)
+	}
+	sort.Slice(templates, func(a, b int) bool { return templates[a].Name < templates[b].Name })
+	return templates
+}
@@ -0,0 +1,44 @@
+package receipt
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestLoadPromptsVersionsOverrides(t *testing.T) {
+	embedded, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := embedded.Version(ReceiptPrompt); got != "receipt.v1" {
+		t.Errorf("埋め込みのバージョン = %s, want receipt.v1", got)
+	}
+
+	overridePattern := regexp.MustCompile(`^receipt\.v1\+[0-9a-f]{8}$`)
+	versionOf := func(content string) string {
+		t.Helper()
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "receipt.v1.tmpl"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		prompts, err := LoadPrompts(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version := prompts.Version(ReceiptPrompt)
+		if !overridePattern.MatchString(version) {
+			t.Errorf("上書きしたバージョン = %s, want receipt.v1+<hash>", version)
+		}
+		return version
+	}
+
+	first := versionOf("レシートを読み取ってください。")
+	if again := versionOf("レシートを読み取ってください。"); again != first {
+		t.Errorf("同じ内容のバージョンが変わりました: %s, %s", first, again)
+	}
+	if changed := versionOf("レシートの合計金額を読み取ってください。"); changed == first {
+		t.Errorf("文面を変えてもバージョンが同じです: %s", changed)
+	}
+}
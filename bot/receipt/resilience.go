@@ -0,0 +1,287 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+)
+
+// =================================================================================
+// Gemini APIの呼び出し（再試行・流量制限・サーキットブレーカー・モデルのフォールバック）
+// =================================================================================
+
+// ErrCircuitOpen は失敗が続いたためAIの呼び出しを一時停止している場合のエラー
+var ErrCircuitOpen = errors.New("AIの呼び出しを一時停止しています")
+
+// ResilienceConfig はGemini APIの呼び出し方の設定
+type ResilienceConfig struct {
+	Models            []string      // 使用するモデル（先頭を通常使い、失敗した場合に順に切り替える）
+	RequestsPerMinute float64       // 1分あたりの最大リクエスト数（0以下で無制限）
+	Burst             int           // 連続して送れるリクエスト数
+	MaxAttempts       int           // モデルごとの最大試行回数
+	BaseDelay         time.Duration // 再試行の初回待機時間（試行ごとに倍増）
+	MaxDelay          time.Duration // 再試行の待機時間の上限
+	CallTimeout       time.Duration // 1回の呼び出しのタイムアウト
+	FailureThreshold  int           // 連続してこの回数失敗したら呼び出しを一時停止する
+	Cooldown          time.Duration // 一時停止する時間
+}
+
+// DefaultResilienceConfig は既定の設定を返す（無料枠の流量制限に合わせている）
+func DefaultResilienceConfig() ResilienceConfig {
+	return ResilienceConfig{
+		Models:            []string{Model},
+		RequestsPerMinute: 15,
+		Burst:             3,
+		MaxAttempts:       3,
+		BaseDelay:         2 * time.Second,
+		MaxDelay:          20 * time.Second,
+		CallTimeout:       30 * time.Second, // 503はクライアント内部でも再試行されるため、その待ち時間も含めた上限
+		FailureThreshold:  3,
+		Cooldown:          2 * time.Minute,
+	}
+}
+
+// ResilientModel は再試行・流量制限・サーキットブレーカー・モデルのフォールバックを備えたGeminiのモデル
+type ResilientModel struct {
+	config  ResilienceConfig
+	models  []*genai.GenerativeModel
+	limiter *rate.Limiter
+
+	mu        sync.Mutex
+	failures  int       // 連続して失敗した呼び出しの回数
+	openUntil time.Time // 呼び出しを一時停止している期限
+	probing   bool      // 一時停止明けの試し呼び出し中か
+	degraded  bool      // 一時停止中またはフォールバック先のモデルで応答しているか
+
+	// OnDegraded は通常の状態から縮退した状態になったときに1度だけ呼ばれる
+	OnDegraded func(reason string)
+	// OnRecovered は縮退した状態から先頭のモデルで応答できる状態に戻ったときに呼ばれる
+	OnRecovered func()
+}
+
+// NewResilientModel はクライアントと設定から呼び出し用のモデルを作る
+func NewResilientModel(client *genai.Client, config ResilienceConfig) *ResilientModel {
+	if len(config.Models) == 0 {
+		config.Models = []string{Model}
+	}
+	config.MaxAttempts = max(config.MaxAttempts, 1)
+
+	limit := rate.Inf
+	if config.RequestsPerMinute > 0 {
+		limit = rate.Limit(config.RequestsPerMinute / 60)
+	}
+	r := &ResilientModel{
+		config:  config,
+		limiter: rate.NewLimiter(limit, max(config.Burst, 1)),
+	}
+	for _, name := range config.Models {
+		r.models = append(r.models, client.GenerativeModel(name))
+	}
+	return r
+}
+
+// Models は使用するモデル名を順に返す
+func (r *ResilientModel) Models() []string {
+	return r.config.Models
+}
+
+// PrimaryModel は通常使うモデル名を返す
+func (r *ResilientModel) PrimaryModel() string {
+	return r.config.Models[0]
+}
+
+// GenerateContent はモデルを順に試して応答を返す（応答したモデル名も返す）
+// 一時的なエラーは同じモデルで待機しながら再試行し、再試行しても失敗した場合や
+// モデルが使えない場合は次のモデルに切り替える
+func (r *ResilientModel) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, string, error) {
+	if err := r.allow(time.Now()); err != nil {
+		return nil, "", err
+	}
+
+	var lastErr error
+	for idx, model := range r.models {
+		name := r.config.Models[idx]
+		resp, err := r.generateWithRetry(ctx, model, name, parts)
+		if err == nil {
+			r.recordSuccess(idx, name)
+			return resp, name, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil || !shouldFallback(err) {
+			break
+		}
+		if idx+1 < len(r.models) {
+			log.Printf("Geminiモデル %s が失敗したため %s に切り替えます: %v", name, r.config.Models[idx+1], err)
+		}
+	}
+
+	r.recordFailure(lastErr)
+	return nil, "", lastErr
+}
+
+// generateWithRetry は1つのモデルで一時的なエラーを指数バックオフで再試行する
+func (r *ResilientModel) generateWithRetry(ctx context.Context, model *genai.GenerativeModel, name string, parts []genai.Part) (*genai.GenerateContentResponse, error) {
+	var err error
+	for attempt := 0; attempt < r.config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := r.backoff(attempt)
+			log.Printf("Gemini API (%s) を%v後に再試行します (%d/%d): %v", name, delay, attempt+1, r.config.MaxAttempts, err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if waitErr := r.limiter.Wait(ctx); waitErr != nil {
+			return nil, fmt.Errorf("流量制限の待機中に中断されました: %w", waitErr)
+		}
+
+		var resp *genai.GenerateContentResponse
+		resp, err = r.call(ctx, model, parts)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil || !IsRetryable(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// call は1回の呼び出しをタイムアウト付きで行う
+func (r *ResilientModel) call(ctx context.Context, model *genai.GenerativeModel, parts []genai.Part) (*genai.GenerateContentResponse, error) {
+	if r.config.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.CallTimeout)
+		defer cancel()
+	}
+	return model.GenerateContent(ctx, parts...)
+}
+
+// backoff は再試行までの待機時間を返す（同時に再試行が集中しないよう揺らぎを加える）
+func (r *ResilientModel) backoff(attempt int) time.Duration {
+	delay := r.config.BaseDelay << (attempt - 1)
+	if r.config.MaxDelay > 0 && (delay > r.config.MaxDelay || delay <= 0) {
+		delay = r.config.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// allow は呼び出しの一時停止中でないかを確認する
+// 一時停止の期限が過ぎた後は、試し呼び出しを1件だけ通す
+func (r *ResilientModel) allow(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.openUntil.IsZero() {
+		return nil
+	}
+	if now.Before(r.openUntil) || r.probing {
+		return fmt.Errorf("%w（%sまで）", ErrCircuitOpen, r.openUntil.In(TokyoLocation).Format("15:04:05"))
+	}
+	r.probing = true
+	log.Printf("Gemini APIの一時停止期間が過ぎたため試し呼び出しを行います")
+	return nil
+}
+
+// recordSuccess は成功した呼び出しを記録する
+func (r *ResilientModel) recordSuccess(modelIndex int, name string) {
+	r.mu.Lock()
+	r.failures = 0
+	r.openUntil = time.Time{}
+	r.probing = false
+	var notifyDegraded, notifyRecovered bool
+	if modelIndex > 0 {
+		notifyDegraded = !r.degraded
+		r.degraded = true
+	} else {
+		notifyRecovered = r.degraded
+		r.degraded = false
+	}
+	onDegraded, onRecovered := r.OnDegraded, r.OnRecovered
+	r.mu.Unlock()
+
+	if notifyDegraded && onDegraded != nil {
+		onDegraded(fmt.Sprintf("%s が応答しないため、代わりに %s を使用しています", r.PrimaryModel(), name))
+	}
+	if notifyRecovered {
+		log.Printf("Gemini APIが復旧しました: %s", name)
+		if onRecovered != nil {
+			onRecovered()
+		}
+	}
+}
+
+// recordFailure は失敗した呼び出しを記録し、連続した失敗が閾値に達したら呼び出しを一時停止する
+func (r *ResilientModel) recordFailure(err error) {
+	if !countsAsFailure(err) {
+		// 試し呼び出しの結果が判断できない場合は、次の呼び出しを改めて試し呼び出しにする
+		r.mu.Lock()
+		r.probing = false
+		r.mu.Unlock()
+		return
+	}
+
+	r.mu.Lock()
+	r.failures++
+	var notifyDegraded bool
+	var reason string
+	if r.probing || (r.config.FailureThreshold > 0 && r.failures >= r.config.FailureThreshold) {
+		r.openUntil = time.Now().Add(r.config.Cooldown)
+		r.probing = false
+		reason = fmt.Sprintf("Gemini APIの呼び出しが%d回続けて失敗したため、%sまで呼び出しを停止しています", r.failures, r.openUntil.In(TokyoLocation).Format("15:04"))
+		log.Printf("%s: %v", reason, err)
+		notifyDegraded = !r.degraded
+		r.degraded = true
+	}
+	onDegraded := r.OnDegraded
+	r.mu.Unlock()
+
+	if notifyDegraded && onDegraded != nil {
+		onDegraded(reason)
+	}
+}
+
+// IsRetryable は時間をおいて再試行すれば成功する可能性があるエラーかを返す
+// （流量制限・サーバーエラー・タイムアウト）
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code == http.StatusRequestTimeout || apiErr.Code >= 500
+	}
+	return false
+}
+
+// shouldFallback は別のモデルなら成功する可能性があるエラーかを返す
+// （再試行しても解消しなかった一時的なエラーや、モデルが見つからない・使えない場合）
+func shouldFallback(err error) bool {
+	if IsRetryable(err) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusForbidden
+	}
+	return false
+}
+
+// countsAsFailure はサーキットブレーカーの失敗として数えるエラーかを返す
+// リクエストの内容が原因のエラー（画像が不正など）はAPIの障害ではないため数えない
+func countsAsFailure(err error) bool {
+	return err != nil && shouldFallback(err)
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// レシート画像アーカイブ
+// =================================================================================
+
+// 画像はSHA-256をキーとして保存するため、同じ画像を何度受信しても1ファイルにまとまる
+var receiptArchiveDir = filepath.Join(tempImageDir, "archive")
+
+const receiptArchiveIndexFile = "index.json"
+const maxReceiptImageSize = 25 << 20                 // ダウンロードする画像の上限（Discordの添付上限に合わせる）
+const defaultReceiptRetentionDays = 365              // 支出に紐付いた画像の保持日数（RECEIPT_RETENTION_DAYSで変更可）
+const unlinkedReceiptRetention = 72 * time.Hour      // キューに追加されなかった画像の保持期間
+const receiptArchiveCleanupInterval = 24 * time.Hour // クリーンアップの実行間隔
+
+var (
+	receiptArchive      map[string]*ReceiptArchiveEntry // 画像キー -> アーカイブ情報
+	receiptArchiveMutex sync.Mutex                      // receiptArchiveの同期
+)
+
+// ReceiptArchiveEntry はアーカイブ済み画像1件の情報
+type ReceiptArchiveEntry struct {
+	Key         string `json:"key"`          // 画像内容のSHA-256（16進）
+	FileName    string `json:"file_name"`    // アーカイブディレクトリ内のファイル名
+	ContentType string `json:"content_type"` // 検出したMIMEタイプ
+	Size        int    `json:"size"`
+	// 撮り直した同じレシートを検出するための知覚ハッシュ（16進、デコードできない形式では空）
+	PerceptualHash string   `json:"perceptual_hash,omitempty"`
+	ExpenseIDs     []string `json:"expense_ids,omitempty"` // この画像から作成された支出のID
+	// 支出を追加した家計のID（家計ごとに重複を判定するため。記録がない画像は既定の家計のもの）
+	HouseholdIDs []string `json:"household_ids,omitempty"`
+	// 長いレシートを分割撮影した場合の続きの画像のキー（先頭の画像にのみ記録する）
+	Continuations []string  `json:"continuations,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastUsedAt    time.Time `json:"last_used_at"`
+}
+
+// loadReceiptArchive は起動時にアーカイブのインデックスを読み込む
+func loadReceiptArchive() error {
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	receiptArchive = make(map[string]*ReceiptArchiveEntry)
+	indexPath := filepath.Join(receiptArchiveDir, receiptArchiveIndexFile)
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return NewBotError(ErrorTypeFileIO, "レシートアーカイブの読み込みに失敗", err).
+			WithContext("file_path", indexPath)
+	}
+
+	if err := json.Unmarshal(data, &receiptArchive); err != nil {
+		receiptArchive = make(map[string]*ReceiptArchiveEntry)
+		return NewBotError(ErrorTypeFileIO, "レシートアーカイブのJSONパースエラー", err).
+			WithContext("file_path", indexPath)
+	}
+
+	log.Printf("-> %d件のレシート画像をアーカイブから読み込みました。", len(receiptArchive))
+	return nil
+}
+
+// saveReceiptArchiveLocked はインデックスをファイルに保存する（receiptArchiveMutexを保持して呼ぶこと）
+func saveReceiptArchiveLocked() error {
+	if err := os.MkdirAll(receiptArchiveDir, 0755); err != nil {
+		return NewBotError(ErrorTypeFileIO, "レシートアーカイブディレクトリの作成に失敗", err).
+			WithContext("dir", receiptArchiveDir)
+	}
+
+	data, err := json.MarshalIndent(receiptArchive, "", "  ")
+	if err != nil {
+		return NewBotError(ErrorTypeFileIO, "レシートアーカイブJSON生成エラー", err)
+	}
+
+	indexPath := filepath.Join(receiptArchiveDir, receiptArchiveIndexFile)
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		return NewBotError(ErrorTypeFileIO, "レシートアーカイブの書き込みに失敗", err).
+			WithContext("file_path", indexPath)
+	}
+	return nil
+}
+
+// receiptImageKey は画像内容からアーカイブキーを計算する
+func receiptImageKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// receiptImageExtension はMIMEタイプに対応する拡張子を返す
+func receiptImageExtension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/heic":
+		return ".heic"
+	case "image/heif":
+		return ".heif"
+	case "application/pdf":
+		return ".pdf"
+	default:
+		return ".bin"
+	}
+}
+
+// downloadImage は画像をダウンロードしてアーカイブに保存し、キーと保存先パスを返す
+func downloadImage(url string) (string, string, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return "", "", NewBotError(ErrorTypeNetwork, "画像URLへのHTTPリクエストに失敗", err).
+			WithContext("url", url)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", "", NewBotError(ErrorTypeNetwork, "画像のダウンロードに失敗", nil).
+			WithContext("url", url).
+			WithContext("status", response.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxReceiptImageSize+1))
+	if err != nil {
+		return "", "", NewBotError(ErrorTypeNetwork, "画像データの受信に失敗", err).
+			WithContext("url", url)
+	}
+	if len(data) > maxReceiptImageSize {
+		return "", "", NewBotError(ErrorTypeValidation, "画像サイズが上限を超えています", nil).
+			WithContext("url", url).
+			WithContext("limit", maxReceiptImageSize)
+	}
+
+	return archiveReceiptImage(data)
+}
+
+// archiveReceiptImage は画像データをアーカイブに保存する（同じ内容なら既存ファイルを再利用）
+func archiveReceiptImage(data []byte) (string, string, error) {
+	key := receiptImageKey(data)
+
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	if receiptArchive == nil {
+		receiptArchive = make(map[string]*ReceiptArchiveEntry)
+	}
+
+	if entry, exists := receiptArchive[key]; exists {
+		filePath := filepath.Join(receiptArchiveDir, entry.FileName)
+		if _, err := os.Stat(filePath); err == nil {
+			entry.LastUsedAt = time.Now()
+			if entry.PerceptualHash == "" {
+				if hash, ok := computePerceptualHash(data); ok {
+					entry.PerceptualHash = strconv.FormatUint(hash, 16)
+				}
+			}
+			if err := saveReceiptArchiveLocked(); err != nil {
+				HandleError(err, nil)
+			}
+			log.Printf("アーカイブ済みの画像を再利用: %s", key)
+			return key, filePath, nil
+		}
+	}
+
+	if err := os.MkdirAll(receiptArchiveDir, 0755); err != nil {
+		return "", "", NewBotError(ErrorTypeFileIO, "レシートアーカイブディレクトリの作成に失敗", err).
+			WithContext("dir", receiptArchiveDir)
+	}
+
+	contentType := receipt.DetectImageMIME(data, "application/octet-stream")
+	fileName := key + receiptImageExtension(contentType)
+	filePath := filepath.Join(receiptArchiveDir, fileName)
+
+	// 書き込み途中のファイルが残らないよう一時ファイル経由で配置する
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return "", "", NewBotError(ErrorTypeFileIO, "画像データの書き込みに失敗", err).
+			WithContext("file_path", tmpPath)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return "", "", NewBotError(ErrorTypeFileIO, "画像ファイルの配置に失敗", err).
+			WithContext("file_path", filePath)
+	}
+
+	var perceptualHash string
+	if hash, ok := computePerceptualHash(data); ok {
+		perceptualHash = strconv.FormatUint(hash, 16)
+	}
+
+	now := time.Now()
+	receiptArchive[key] = &ReceiptArchiveEntry{
+		Key:            key,
+		FileName:       fileName,
+		ContentType:    contentType,
+		Size:           len(data),
+		PerceptualHash: perceptualHash,
+		CreatedAt:      now,
+		LastUsedAt:     now,
+	}
+	if err := saveReceiptArchiveLocked(); err != nil {
+		return "", "", err
+	}
+
+	log.Printf("レシート画像をアーカイブしました: %s (%d bytes)", key, len(data))
+	return key, filePath, nil
+}
+
+// setReceiptContinuations は先頭の画像に分割撮影した続きの画像を記録する
+func setReceiptContinuations(key string, continuations []string) {
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	entry, exists := receiptArchive[key]
+	if !exists {
+		log.Printf("続きの画像を記録する先頭画像が見つかりません: %s", key)
+		return
+	}
+	entry.Continuations = append([]string(nil), continuations...)
+	if err := saveReceiptArchiveLocked(); err != nil {
+		HandleError(err, nil)
+	}
+}
+
+// receiptImageKeys は先頭の画像と続きの画像のキーを順番に返す
+func receiptImageKeys(key string) []string {
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	keys := []string{key}
+	if entry, exists := receiptArchive[key]; exists {
+		keys = append(keys, entry.Continuations...)
+	}
+	return keys
+}
+
+// linkReceiptToExpense はアーカイブ済み画像（続きの画像を含む）に家計の支出IDを紐付ける
+func linkReceiptToExpense(key, expenseID, householdID string) {
+	if key == "" || expenseID == "" {
+		return
+	}
+
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	entry, exists := receiptArchive[key]
+	if !exists {
+		log.Printf("紐付け対象のレシート画像が見つかりません: %s", key)
+		return
+	}
+
+	changed := false
+	for _, targetKey := range append([]string{key}, entry.Continuations...) {
+		target, exists := receiptArchive[targetKey]
+		if !exists || slices.Contains(target.ExpenseIDs, expenseID) {
+			continue
+		}
+		target.ExpenseIDs = append(target.ExpenseIDs, expenseID)
+		if !slices.Contains(target.HouseholdIDs, householdID) {
+			target.HouseholdIDs = append(target.HouseholdIDs, householdID)
+		}
+		target.LastUsedAt = time.Now()
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	if err := saveReceiptArchiveLocked(); err != nil {
+		HandleError(err, nil)
+	}
+}
+
+// unlinkReceiptFromExpense は取り消された支出とアーカイブ済み画像（続きの画像を含む）の紐付けを外す
+// 紐付けが残ると、キューにない支出として同期済みのレシートと同じ扱いで重複を警告してしまう
+func unlinkReceiptFromExpense(key, expenseID string) {
+	if key == "" || expenseID == "" {
+		return
+	}
+
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	entry, exists := receiptArchive[key]
+	if !exists {
+		return
+	}
+	changed := false
+	for _, targetKey := range append([]string{key}, entry.Continuations...) {
+		target, exists := receiptArchive[targetKey]
+		if !exists || !slices.Contains(target.ExpenseIDs, expenseID) {
+			continue
+		}
+		target.ExpenseIDs = slices.DeleteFunc(target.ExpenseIDs, func(id string) bool { return id == expenseID })
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	if err := saveReceiptArchiveLocked(); err != nil {
+		HandleError(err, nil)
+	}
+}
+
+// readArchivedReceipt はアーカイブ済み画像の情報と内容を読み込む
+func readArchivedReceipt(key string) (*ReceiptArchiveEntry, []byte, error) {
+	receiptArchiveMutex.Lock()
+	entry, exists := receiptArchive[key]
+	var entryCopy ReceiptArchiveEntry
+	if exists {
+		entryCopy = *entry
+	}
+	receiptArchiveMutex.Unlock()
+
+	if !exists {
+		return nil, nil, NewBotError(ErrorTypeDataAccess, "レシート画像がアーカイブに見つかりません", nil).
+			WithContext("key", key)
+	}
+
+	filePath := filepath.Join(receiptArchiveDir, entryCopy.FileName)
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, NewBotError(ErrorTypeFileIO, "レシート画像ファイルの読み込みに失敗", err).
+			WithContext("file_path", filePath)
+	}
+	return &entryCopy, data, nil
+}
+
+// receiptRetention は支出に紐付いた画像の保持期間を環境変数から決定する
+func receiptRetention() time.Duration {
+	days := defaultReceiptRetentionDays
+	if value := os.Getenv("RECEIPT_RETENTION_DAYS"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			days = parsed
+		} else {
+			log.Printf("RECEIPT_RETENTION_DAYSの値が不正なため既定値を使用します: %s", value)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// cleanupReceiptArchive は保持期間を過ぎた画像を削除し、削除件数を返す
+func cleanupReceiptArchive(now time.Time) int {
+	linkedRetention := receiptRetention()
+
+	receiptArchiveMutex.Lock()
+	defer receiptArchiveMutex.Unlock()
+
+	removed := 0
+	for key, entry := range receiptArchive {
+		retention := unlinkedReceiptRetention
+		if len(entry.ExpenseIDs) > 0 {
+			retention = linkedRetention
+		}
+		if now.Sub(entry.LastUsedAt) < retention {
+			continue
+		}
+
+		filePath := filepath.Join(receiptArchiveDir, entry.FileName)
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			botErr := NewBotError(ErrorTypeFileIO, "期限切れレシート画像の削除に失敗", err).
+				WithContext("file_path", filePath)
+			LogBotError(botErr)
+			continue
+		}
+		delete(receiptArchive, key)
+		removed++
+	}
+
+	// インデックスに存在しないファイル（書き込み途中で終了した一時ファイル等）も片付ける
+	files, err := os.ReadDir(receiptArchiveDir)
+	if err == nil {
+		known := make(map[string]bool, len(receiptArchive)+1)
+		known[receiptArchiveIndexFile] = true
+		for _, entry := range receiptArchive {
+			known[entry.FileName] = true
+		}
+		for _, file := range files {
+			if file.IsDir() || known[file.Name()] {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil || now.Sub(info.ModTime()) < unlinkedReceiptRetention {
+				continue
+			}
+			if err := os.Remove(filepath.Join(receiptArchiveDir, file.Name())); err == nil {
+				removed++
+			}
+		}
+	}
+
+	if removed > 0 {
+		if err := saveReceiptArchiveLocked(); err != nil {
+			HandleError(err, nil)
+		}
+		log.Printf("レシートアーカイブのクリーンアップ: %d件を削除しました", removed)
+	}
+	return removed
+}
+
+// startReceiptArchiveCleanup は定期クリーンアップを開始する
+func startReceiptArchiveCleanup() {
+	go func() {
+		cleanupReceiptArchive(time.Now())
+		ticker := time.NewTicker(receiptArchiveCleanupInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			cleanupReceiptArchive(now)
+		}
+	}()
+}
+
+// handleReceipt は /receipt コマンドの処理（支出の元画像を再投稿する）
+func handleReceipt(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	expenseID := strings.TrimSpace(i.ApplicationCommandData().Options[0].StringValue())
+
+	respondError := func(content string) {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+	}
+
+	expense, err := h.findExpenseByID(expenseID)
+	if err != nil {
+		HandleError(err, nil)
+		respondError("❌ エラー: キューの読み込みに失敗しました。")
+		return
+	}
+	if expense == nil {
+		respondError(fmt.Sprintf("❌ ID「%s」の支出が見つかりません。", expenseID))
+		return
+	}
+	if expense.ReceiptKey == "" {
+		respondError(fmt.Sprintf("📭 ID「%s」の支出にはレシート画像が紐付いていません。", expenseID))
+		return
+	}
+
+	// 長いレシートは分割撮影したすべての画像を順番に添付する
+	keys := receiptImageKeys(expense.ReceiptKey)
+	var files []*discordgo.File
+	for idx, key := range keys {
+		entry, data, err := readArchivedReceipt(key)
+		if err != nil {
+			HandleError(err, nil)
+			respondError("❌ レシート画像が見つかりません。保持期間を過ぎて削除された可能性があります。")
+			return
+		}
+		name := "receipt_" + expense.ID
+		if len(keys) > 1 {
+			name = fmt.Sprintf("%s_%d", name, idx+1)
+		}
+		files = append(files, &discordgo.File{
+			Name:        name + receiptImageExtension(entry.ContentType),
+			ContentType: entry.ContentType,
+			Reader:      bytes.NewReader(data),
+		})
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("🧾 %s ¥%d %s", expense.Date, expense.Price, expense.Detail),
+			Files:   files,
+		},
+	})
+	if err != nil {
+		botErr := NewBotError(ErrorTypeDiscordAPI, "レシート画像の送信に失敗", err).
+			WithContext("expense_id", expense.ID)
+		LogBotError(botErr)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 長いレシートの分割画像をまとめて解析
+// =================================================================================
+
+const defaultStitchWindowSeconds = 20 // 続きの画像を受け付ける秒数（RECEIPT_STITCH_WINDOW_SECONDSで変更可）
+
+// 画像をまとめて解析するモード（RECEIPT_STITCH_MODE）
+// auto・alwaysでは画像1枚の投稿も続きの画像を待つため、解析の開始が受付時間の分だけ遅れる
+const (
+	stitchModeAuto   = "auto"   // 画像1枚の投稿に同じユーザーが続けて投稿した画像をまとめる
+	stitchModeAlways = "always" // 上記に加えて、1件の投稿の複数の添付も常にまとめる
+	stitchModeOff    = "off"    // キーワードを含む投稿だけをまとめる（既定、画像1枚はすぐに解析する）
+)
+
+// stitchKeywords は投稿本文に含まれていれば添付画像を1枚のレシートとして扱うキーワード
+var stitchKeywords = []string{"まとめて", "長いレシート", "連結"}
+
+// stitchedItemsPattern は「画像1の商品: A、B」形式の行にマッチする
+var stitchedItemsPattern = regexp.MustCompile(`^画像\s*(\d+)\s*の商品\s*[:：]\s*(.*)$`)
+
+var (
+	stitchGroups = make(map[string]*TransactionState) // "チャンネルID:ユーザーID" -> 続きの画像を受付中のトランザクション（muで保護）
+	stitchTimers = make(map[string]*time.Timer)       // 受付終了のタイマー（muで保護）
+)
+
+// stitchWindow は続きの画像を受け付ける時間を環境変数から決定する（0の場合は投稿1件の添付のみをまとめる）
+func stitchWindow() time.Duration {
+	seconds := defaultStitchWindowSeconds
+	if value := os.Getenv("RECEIPT_STITCH_WINDOW_SECONDS"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			seconds = parsed
+		} else {
+			log.Printf("RECEIPT_STITCH_WINDOW_SECONDSの値が不正なため既定値を使用します: %s", value)
+		}
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// stitchMode は画像をまとめて解析するモードを環境変数から決定する
+func stitchMode() string {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("RECEIPT_STITCH_MODE")))
+	switch value {
+	case stitchModeAuto, stitchModeAlways, stitchModeOff:
+		return value
+	case "":
+		return stitchModeOff
+	}
+	log.Printf("RECEIPT_STITCH_MODEの値が不正なため既定値を使用します: %s", value)
+	return stitchModeOff
+}
+
+// isStitchRequested は1件の投稿の添付をすべて長いレシートとしてまとめて解析するかを判定する
+func isStitchRequested(m *discordgo.MessageCreate) bool {
+	// RECEIPT_STITCH_MODE=always の場合は常にまとめて解析する
+	if stitchMode() == stitchModeAlways {
+		return true
+	}
+	for _, keyword := range stitchKeywords {
+		if strings.Contains(m.Content, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// stitchGroupKey は続きの画像を受け付ける単位（同じチャンネルの同じユーザー）のキーを返す
+func stitchGroupKey(channelID, userID string) string {
+	return channelID + ":" + userID
+}
+
+// openStitchGroupLocked は続きの画像の受付を開始する（muを保持して呼ぶこと）
+func openStitchGroupLocked(s *discordgo.Session, m *discordgo.MessageCreate, state *TransactionState) {
+	key := stitchGroupKey(m.ChannelID, m.Author.ID)
+	state.Status = AnalysisStatusCollecting
+	state.stitchDeadline = time.Now().Add(stitchWindow())
+	stitchGroups[key] = state
+	stitchTimers[key] = time.AfterFunc(stitchWindow(), func() {
+		closeStitchGroup(s, key, state)
+	})
+	log.Printf("長いレシートの受付を開始: messageID=%s, 画像%d件", state.InitialMessageID, len(state.Attachments))
+}
+
+// joinStitchGroup は受付中の長いレシートに続きの画像を追加する（追加した場合はtrue）
+func joinStitchGroup(s *discordgo.Session, m *discordgo.MessageCreate, attachments []*discordgo.MessageAttachment) bool {
+	key := stitchGroupKey(m.ChannelID, m.Author.ID)
+
+	mu.Lock()
+	state, exists := stitchGroups[key]
+	if !exists || state.Status != AnalysisStatusCollecting {
+		mu.Unlock()
+		return false
+	}
+	state.Attachments = append(state.Attachments, attachments...)
+	// 画像が届くたびに受付時間を延長する（解析結果を待つ期限もここから数え直す）
+	if timer, ok := stitchTimers[key]; ok {
+		timer.Reset(stitchWindow())
+	}
+	state.stitchDeadline = time.Now().Add(stitchWindow())
+	count := len(state.Attachments)
+	mu.Unlock()
+
+	log.Printf("長いレシートに続きの画像を追加: messageID=%s, 画像%d件", state.InitialMessageID, count)
+	updateReceiptPrompt(s, state)
+	return true
+}
+
+// closeStitchGroup は続きの画像の受付を終了し、まとめて解析を開始する
+func closeStitchGroup(s *discordgo.Session, key string, state *TransactionState) {
+	mu.Lock()
+	if stitchGroups[key] == state {
+		delete(stitchGroups, key)
+		if timer, ok := stitchTimers[key]; ok {
+			timer.Stop()
+			delete(stitchTimers, key)
+		}
+	}
+	// 既に受付を終了している場合や、手入力で続行済みの場合は何もしない
+	if state.Status != AnalysisStatusCollecting || transactions[state.InitialMessageID] != state {
+		mu.Unlock()
+		return
+	}
+	state.Status = AnalysisStatusRunning
+	state.stitchDeadline = time.Now()
+	count := len(state.Attachments)
+	mu.Unlock()
+
+	log.Printf("長いレシートをまとめて解析します: messageID=%s, 画像%d件", state.InitialMessageID, count)
+	updateReceiptPrompt(s, state)
+	go analyzeReceiptInBackground(s, state)
+}
+
+// handleStitchDone は「今すぐ解析」ボタンの処理（受付時間を待たずに解析を開始する）
+func handleStitchDone(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	customID := i.MessageComponentData().CustomID
+	messageID := strings.TrimPrefix(customID, "receipt_stitch_done:")
+
+	mu.Lock()
+	state, exists := transactions[messageID]
+	collecting := exists && state.Status == AnalysisStatusCollecting
+	var key string
+	if collecting {
+		key = stitchGroupKey(state.ChannelID, state.Message.Author.ID)
+	}
+	mu.Unlock()
+
+	if !collecting {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "🔄 既に解析を開始しています。しばらくお待ちください。",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		log.Printf("今すぐ解析応答エラー: %v", err)
+	}
+	closeStitchGroup(s, key, state)
+}
+
+// parseStitchedItems はAIの応答から画像ごとの商品リストを画像の順に取り出す
+func parseStitchedItems(text string) [][]string {
+	itemsByImage := make(map[int][]string)
+	maxIndex := 0
+	for _, line := range strings.Split(text, "\n") {
+		match := stitchedItemsPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		index, err := strconv.Atoi(match[1])
+		if err != nil || index <= 0 {
+			continue
+		}
+
+		var items []string
+		for _, item := range strings.FieldsFunc(match[2], func(r rune) bool {
+			return r == '、' || r == ',' || r == '，'
+		}) {
+			item = strings.TrimSpace(item)
+			if item != "" && item != "不明" {
+				items = append(items, item)
+			}
+		}
+		itemsByImage[index] = items
+		maxIndex = max(maxIndex, index)
+	}
+
+	var parts [][]string
+	for index := 1; index <= maxIndex; index++ {
+		if items, ok := itemsByImage[index]; ok {
+			parts = append(parts, items)
+		}
+	}
+	return parts
+}
+
+// mergeItemsAtSeams は画像ごとの商品リストを連結し、境目で重なって写った商品を1回分にまとめる
+func mergeItemsAtSeams(parts [][]string) []string {
+	var merged []string
+	for _, part := range parts {
+		overlap := seamOverlap(merged, part)
+		if overlap > 0 {
+			log.Printf("画像の境目で重複した商品を除外: %s", strings.Join(part[:overlap], "、"))
+		}
+		merged = append(merged, part[overlap:]...)
+	}
+	return merged
+}
+
+// seamOverlap は前の画像の末尾と次の画像の先頭で一致する商品数（最長）を返す
+// 同じ商品を続けて購入した場合と区別できないため、前後で連続して一致する部分のみを重なりとみなす
+func seamOverlap(previous, next []string) int {
+	for length := min(len(previous), len(next)); length > 0; length-- {
+		matched := true
+		for offset := 0; offset < length; offset++ {
+			if normalizeForDuplicateCheck(previous[len(previous)-length+offset]) != normalizeForDuplicateCheck(next[offset]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return length
+		}
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStitchedItems(t *testing.T) {
+	response := `{"total_amount": 2480}
+画像1の商品: 牛乳、食パン、卵
+画像 3 の商品：りんご
+画像2の商品: 卵、バナナ, 不明`
+
+	want := [][]string{{"牛乳", "食パン", "卵"}, {"卵", "バナナ"}, {"りんご"}}
+	if got := parseStitchedItems(response); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseStitchedItems() = %v, want %v", got, want)
+	}
+}
+
+func TestMergeItemsAtSeams(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts [][]string
+		want  []string
+	}{
+		{"重なりなし", [][]string{{"牛乳", "食パン"}, {"卵", "バナナ"}}, []string{"牛乳", "食パン", "卵", "バナナ"}},
+		{"境目の1品が重複", [][]string{{"牛乳", "食パン", "卵"}, {"卵", "バナナ"}}, []string{"牛乳", "食パン", "卵", "バナナ"}},
+		{"境目の2品が重複", [][]string{{"牛乳", "食パン", "卵"}, {"食パン", "卵", "バナナ"}}, []string{"牛乳", "食パン", "卵", "バナナ"}},
+		{"境目以外の同じ商品は残す", [][]string{{"卵", "牛乳"}, {"卵", "バナナ"}}, []string{"卵", "牛乳", "卵", "バナナ"}},
+		{"3枚", [][]string{{"A", "B"}, {"B", "C"}, {"C", "D"}}, []string{"A", "B", "C", "D"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeItemsAtSeams(tt.parts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeItemsAtSeams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalysisDeadlineFollowsStitchWindow(t *testing.T) {
+	waitStart := time.Date(2025, 8, 24, 12, 0, 0, 0, tokyoLocation)
+
+	state := &TransactionState{}
+	if got, want := state.analysisDeadlineLocked(waitStart), waitStart.Add(analysisTimeout); !got.Equal(want) {
+		t.Errorf("単独の画像の期限 = %v, want %v", got, want)
+	}
+
+	// 続きの画像で受付が延びた場合は、受付の終了から解析の待ち時間を数える
+	state.stitchDeadline = waitStart.Add(2 * time.Minute)
+	if got, want := state.analysisDeadlineLocked(waitStart), waitStart.Add(2*time.Minute+analysisTimeout); !got.Equal(want) {
+		t.Errorf("受付中の期限 = %v, want %v", got, want)
+	}
+
+	// 受付が入力より前に終わっていれば、入力からの待ち時間のみ
+	state.stitchDeadline = waitStart.Add(-time.Minute)
+	if got, want := state.analysisDeadlineLocked(waitStart), waitStart.Add(analysisTimeout); !got.Equal(want) {
+		t.Errorf("受付終了後の期限 = %v, want %v", got, want)
+	}
+}
+
+func TestStitchModeDefaultsToOff(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", stitchModeOff},
+		{"AUTO", stitchModeAuto},
+		{" always ", stitchModeAlways},
+		{"off", stitchModeOff},
+		{"sometimes", stitchModeOff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("RECEIPT_STITCH_MODE", tt.value)
+			if got := stitchMode(); got != tt.want {
+				t.Errorf("stitchMode() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 定期支出（家賃・光熱費・サブスクリプションなど）
+// =================================================================================
+
+const recurringFile = "recurring.json"
+const recurringCheckInterval = time.Hour // 予定日を迎えた定期支出があるか確認する間隔
+const maxRecurringCatchUp = 24           // 停止中に過ぎた予定日を1回の確認で処理する上限（残りは次回）
+const maxRecurringMessageLength = 1900   // Discordのメッセージ上限（2000文字）に収めるための上限
+
+// 定期支出の周期
+const (
+	recurringMonthly         = "monthly"           // 毎月N日（月末を超える場合は月末）
+	recurringYearly          = "yearly"            // 毎年M月N日
+	recurringWeekly          = "weekly"            // 開始日からN週間ごと
+	recurringLastBusinessDay = "last_business_day" // 毎月最終営業日（土日を除く。祝日は考慮しない）
+)
+
+// RecurringSchedule は定期支出の周期
+type RecurringSchedule struct {
+	Kind          string `json:"kind"`
+	Day           int    `json:"day,omitempty"`            // monthly・yearlyの日
+	Month         int    `json:"month,omitempty"`          // yearlyの月
+	IntervalWeeks int    `json:"interval_weeks,omitempty"` // weeklyの間隔
+	StartDate     string `json:"start_date"`               // この日以降の予定日から登録する（weeklyの起点）
+}
+
+// RecurringExpense は定期支出1件
+type RecurringExpense struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`   // 詳細として登録する名前
+	Amount         int               `json:"amount"` // 金額（毎回変わる場合は確認画面の初期値）
+	VariableAmount bool              `json:"variable_amount"`
+	CategoryID     int               `json:"category_id"`
+	GroupID        *int              `json:"group_id,omitempty"`
+	UserID         int               `json:"user_id"`
+	PaymentMethod  string            `json:"payment_method"`
+	Schedule       RecurringSchedule `json:"schedule"`
+	LastRunDate    string            `json:"last_run_date,omitempty"` // 登録済みの直近の予定日（停止中に過ぎた予定日を判定する）
+	PostedDate     string            `json:"posted_date,omitempty"`   // 確認画面を表示した直近の予定日（金額が変わる場合。再起動後の二重表示を防ぐ）
+	CreatedBy      string            `json:"created_by"`              // 定義したユーザー（金額が変わる場合の確認画面を操作できる）
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// RecurringSet は定期支出ファイルの内容
+type RecurringSet struct {
+	NextID int                `json:"next_id"`
+	Items  []RecurringExpense `json:"items"`
+}
+
+// loadRecurring は定期支出を読み込む（ファイルがなければ空）
+func (h *Household) loadRecurring() error {
+	h.recurringMutex.Lock()
+	defer h.recurringMutex.Unlock()
+
+	path := h.dataPath(recurringFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			h.recurringSet = RecurringSet{NextID: 1}
+			return nil
+		}
+		return NewBotError(ErrorTypeFileIO, "定期支出ファイルの読み込みエラー", err).
+			WithContext("file_path", path)
+	}
+	var set RecurringSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return NewBotError(ErrorTypeFileIO, "定期支出ファイルのJSONパースエラー", err).
+			WithContext("file_path", path)
+	}
+	h.recurringSet = set
+	log.Printf("-> %d件の定期支出を読み込みました。", len(set.Items))
+	return nil
+}
+
+// saveRecurringLocked は定期支出をファイルに保存する（recurringMutexを保持して呼ぶ）
+func (h *Household) saveRecurringLocked() error {
+	path := h.dataPath(recurringFile)
+	data, err := json.MarshalIndent(h.recurringSet, "", "  ")
+	if err != nil {
+		return NewBotError(ErrorTypeFileIO, "定期支出のJSON生成エラー", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return NewBotError(ErrorTypeFileIO, "定期支出ファイルの書き込みエラー", err).
+			WithContext("file_path", path)
+	}
+	return nil
+}
+
+// =================================================================================
+// 予定日の計算
+// =================================================================================
+
+// daysInMonth は月の日数を返す
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, tokyoLocation).Day()
+}
+
+// dayInMonth は月のN日（月末を超える場合は月末）を返す
+func dayInMonth(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, min(day, daysInMonth(year, month)), 0, 0, 0, 0, tokyoLocation)
+}
+
+// lastBusinessDay は月の最後の平日を返す
+func lastBusinessDay(year int, month time.Month) time.Time {
+	date := dayInMonth(year, month, 31)
+	for date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		date = date.AddDate(0, 0, -1)
+	}
+	return date
+}
+
+// nextDue は指定した日より後（当日を含まない）で最初の予定日を返す
+func (schedule RecurringSchedule) nextDue(after time.Time) (time.Time, error) {
+	start, err := time.ParseInLocation(isoDateLayout, schedule.StartDate, tokyoLocation)
+	if err != nil {
+		return time.Time{}, NewBotError(ErrorTypeValidation, "定期支出の開始日が不正です", err).
+			WithContext("start_date", schedule.StartDate)
+	}
+	from := time.Date(after.Year(), after.Month(), after.Day()+1, 0, 0, 0, 0, tokyoLocation)
+	if from.Before(start) {
+		from = start
+	}
+
+	switch schedule.Kind {
+	case recurringMonthly, recurringLastBusinessDay:
+		for month := 0; month < 2; month++ {
+			year, mon := from.Year(), from.Month()+time.Month(month)
+			candidate := dayInMonth(year, mon, schedule.Day)
+			if schedule.Kind == recurringLastBusinessDay {
+				candidate = lastBusinessDay(year, mon)
+			}
+			if !candidate.Before(from) {
+				return candidate, nil
+			}
+		}
+	case recurringYearly:
+		for year := from.Year(); year <= from.Year()+1; year++ {
+			if candidate := dayInMonth(year, time.Month(schedule.Month), schedule.Day); !candidate.Before(from) {
+				return candidate, nil
+			}
+		}
+	case recurringWeekly:
+		interval := max(schedule.IntervalWeeks, 1) * 7
+		elapsed := int(from.Sub(start).Hours() / 24)
+		periods := (elapsed + interval - 1) / interval
+		return start.AddDate(0, 0, periods*interval), nil
+	}
+	return time.Time{}, NewBotError(ErrorTypeValidation, "定期支出の周期が不正です", nil).
+		WithContext("kind", schedule.Kind)
+}
+
+// describe は周期を表示用の文字列にする
+func (schedule RecurringSchedule) describe() string {
+	switch schedule.Kind {
+	case recurringMonthly:
+		return fmt.Sprintf("毎月%d日", schedule.Day)
+	case recurringYearly:
+		return fmt.Sprintf("毎年%d月%d日", schedule.Month, schedule.Day)
+	case recurringWeekly:
+		return fmt.Sprintf("%d週間ごと（%s起点）", max(schedule.IntervalWeeks, 1), schedule.StartDate)
+	case recurringLastBusinessDay:
+		return "毎月最終営業日"
+	}
+	return schedule.Kind
+}
+
+// describe は定期支出を一覧表示用の1行にする
+func (item RecurringExpense) describe(h *Household) string {
+	amount := fmt.Sprintf("¥%d", item.Amount)
+	if item.VariableAmount {
+		amount += "（毎回確認）"
+	}
+	line := fmt.Sprintf("`%s` %s %s %s・%s・%s", item.ID, item.Name, amount, item.Schedule.describe(),
+		h.categoryNameByID(item.CategoryID), item.PaymentMethod)
+	if item.GroupID != nil {
+		line += "・" + h.groupNameByID(item.GroupID)
+	}
+	return line
+}
+
+// =================================================================================
+// スケジューラー
+// =================================================================================
+
+// startRecurringScheduler は起動時に停止中に過ぎた予定日を処理し、以後は定期的に予定日を確認する
+func startRecurringScheduler(s *discordgo.Session) {
+	go func() {
+		runDueRecurring(s, time.Now())
+		ticker := time.NewTicker(recurringCheckInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runDueRecurring(s, now)
+		}
+	}()
+}
+
+// runDueRecurring はすべての家計で予定日を迎えた定期支出を登録する
+func runDueRecurring(s *discordgo.Session, now time.Time) {
+	for _, h := range households {
+		h.runDueRecurring(s, now)
+	}
+}
+
+// runDueRecurring は予定日を迎えた定期支出を古い順に登録し、登録した予定日を記録する
+// 登録してから記録するまでの間に停止しても、同じ予定日のExpenseはIDで判別して二重に登録しない
+func (h *Household) runDueRecurring(s *discordgo.Session, now time.Time) {
+	h.recurringMutex.Lock()
+	items := append([]RecurringExpense(nil), h.recurringSet.Items...)
+	h.recurringMutex.Unlock()
+
+	today := now.In(tokyoLocation)
+	for _, item := range items {
+		last := time.Time{}
+		if item.LastRunDate != "" {
+			parsed, err := time.ParseInLocation(isoDateLayout, item.LastRunDate, tokyoLocation)
+			if err != nil {
+				HandleError(NewBotError(ErrorTypeValidation, "定期支出の登録済みの予定日が不正です", err).
+					WithContext("recurring_id", item.ID), nil)
+				continue
+			}
+			last = parsed
+		}
+
+		for count := 0; count < maxRecurringCatchUp; count++ {
+			due, err := item.Schedule.nextDue(last)
+			if err != nil {
+				HandleError(err, nil)
+				break
+			}
+			if due.After(today) {
+				break
+			}
+			if err := h.postRecurring(s, item, due.Format(isoDateLayout)); err != nil {
+				HandleError(err, nil)
+				break
+			}
+			last = due
+			if !h.markRecurringRun(item.ID, due.Format(isoDateLayout)) {
+				break
+			}
+		}
+	}
+}
+
+// markRecurringRun は定期支出の登録済みの予定日を保存する（削除されていた場合はfalse）
+func (h *Household) markRecurringRun(recurringID, date string) bool {
+	h.recurringMutex.Lock()
+	defer h.recurringMutex.Unlock()
+
+	for idx := range h.recurringSet.Items {
+		if h.recurringSet.Items[idx].ID == recurringID {
+			h.recurringSet.Items[idx].LastRunDate = date
+			if err := h.saveRecurringLocked(); err != nil {
+				HandleError(err, nil)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// markRecurringPosted は金額が変わる定期支出の確認画面を表示する予定日を送信前に保存する
+// 既に表示済み・削除済み・保存に失敗した場合はfalse（送信しない）
+func (h *Household) markRecurringPosted(recurringID, date string) (bool, error) {
+	h.recurringMutex.Lock()
+	defer h.recurringMutex.Unlock()
+
+	for idx := range h.recurringSet.Items {
+		item := &h.recurringSet.Items[idx]
+		if item.ID != recurringID {
+			continue
+		}
+		if item.PostedDate == date {
+			return false, nil
+		}
+		previous := item.PostedDate
+		item.PostedDate = date
+		if err := h.saveRecurringLocked(); err != nil {
+			item.PostedDate = previous
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// recurringEntryID は定期支出の予定日ごとに一意なID（ExpenseのIDと確認画面のIDに使う）
+func recurringEntryID(recurringID, date string) string {
+	return fmt.Sprintf("recurring-%s-%s", recurringID, strings.ReplaceAll(date, "-", ""))
+}
+
+// postRecurring は予定日の定期支出をキューに追加する（金額が変わる場合は確認画面を表示する）
+func (h *Household) postRecurring(s *discordgo.Session, item RecurringExpense, date string) error {
+	entryID := recurringEntryID(item.ID, date)
+
+	if item.VariableAmount {
+		// 確認画面はメモリ上にしか残らないため、表示したことを先に保存してから送信する
+		// （送信前に停止した場合はその予定日の確認画面は表示されず、ログから判別する）
+		posted, err := h.markRecurringPosted(item.ID, date)
+		if err != nil || !posted {
+			return err
+		}
+		_, err = s.ChannelMessageSend(h.ChannelID, fmt.Sprintf("🔁 定期支出「%s」（%s分）の金額を確認してください。前回の金額: ¥%d", item.Name, date, item.Amount))
+		if err != nil {
+			return NewBotError(ErrorTypeDiscordAPI, "定期支出の通知に失敗", err).
+				WithContext("recurring_id", item.ID)
+		}
+		paymentMethod := item.PaymentMethod
+		aiResult := ReceiptAnalysis{IsReceipt: true, StoreName: &item.Name, Date: &date, PaymentMethod: &paymentMethod}
+		sendProcessingResult(s, h, entryID, item.CreatedBy, item.Amount, item.CategoryID, item.GroupID, item.UserID, item.Name, aiResult, "",
+			RuleFixedFields{Category: true, Group: true, PaymentMethod: item.PaymentMethod != ""})
+		log.Printf("定期支出の確認画面を表示しました: %s (%s)", item.ID, date)
+		return nil
+	}
+
+	expense := Expense{
+		ID:         entryID,
+		Date:       date,
+		Price:      item.Amount,
+		CategoryID: item.CategoryID,
+		UserID:     item.UserID,
+		Detail:     item.Name,
+		GroupID:    item.GroupID,
+		PaymentID:  h.paymentIDByName(item.PaymentMethod),
+	}
+	added, err := h.saveRecurringExpense(expense)
+	if err != nil || !added {
+		return err
+	}
+	h.recordAudit(AuditEntry{
+		Action:     auditActionExpenseAdd,
+		EntityType: "expense",
+		EntityID:   expense.ID,
+		RelatedID:  item.ID,
+		Changes:    diffAuditValues(map[string]string{}, h.expenseAuditValues(expense)),
+	})
+
+	content := fmt.Sprintf("🔁 定期支出「%s」¥%d（%s分）をキューに追加しました。(ID: `%s`)", item.Name, item.Amount, date, expense.ID)
+	if status := h.budgetStatus(expense.CategoryID, expense.Date); status != "" {
+		content += "\n" + status
+	}
+	if _, err := s.ChannelMessageSend(h.ChannelID, content); err != nil {
+		log.Printf("定期支出の追加通知に失敗: %v", err)
+	}
+	return nil
+}
+
+// saveRecurringExpense は定期支出のExpenseをキューに追加する（同じIDが既にあれば追加せずfalse）
+func (h *Household) saveRecurringExpense(expense Expense) (bool, error) {
+	h.expenseQueueMutex.Lock()
+	defer h.expenseQueueMutex.Unlock()
+
+	expenseQueue, err := h.loadExpenseQueue()
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range expenseQueue {
+		if existing.ID == expense.ID {
+			log.Printf("定期支出は登録済みのためスキップします: %s", expense.ID)
+			return false, nil
+		}
+	}
+	if err := h.writeExpenseQueue(append(expenseQueue, expense)); err != nil {
+		return false, err
+	}
+	h.recordQueueOperationLocked("", expense)
+	log.Printf("定期支出をキューに追加: %+v", expense)
+	return true, nil
+}
+
+// paymentIDByName は支払い方法名から支払いIDを返す（見つからなければnil）
+func (h *Household) paymentIDByName(name string) *int {
+	for _, payment := range h.masterPaymentTypes {
+		if payment.PayKind == name {
+			payID := payment.PayID
+			return &payID
+		}
+	}
+	return nil
+}
+
+// =================================================================================
+// /recurring コマンド
+// =================================================================================
+
+// handleRecurring は /recurring コマンドの処理
+func handleRecurring(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	subcommand := i.ApplicationCommandData().Options[0]
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range subcommand.Options {
+		options[option.Name] = option
+	}
+
+	var content string
+	switch subcommand.Name {
+	case "add":
+		content = h.addRecurringFromOptions(options, auditActorID(i))
+	case "list":
+		content = h.listRecurring()
+	case "delete":
+		content = h.deleteRecurring(options["id"].StringValue())
+	}
+
+	if runes := []rune(content); len(runes) > maxRecurringMessageLength {
+		content = string(runes[:maxRecurringMessageLength]) + "\n…（省略されました）"
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("定期支出コマンド応答エラー: %v", err)
+	}
+}
+
+// addRecurringFromOptions はコマンドのオプションから定期支出を追加し、応答メッセージを返す
+func (h *Household) addRecurringFromOptions(options map[string]*discordgo.ApplicationCommandInteractionDataOption, actorID string) string {
+	item := RecurringExpense{
+		Name:          strings.TrimSpace(options["name"].StringValue()),
+		Amount:        int(options["amount"].IntValue()),
+		PaymentMethod: strings.TrimSpace(options["payment"].StringValue()),
+		Schedule:      RecurringSchedule{Kind: options["schedule"].StringValue()},
+		CreatedBy:     actorID,
+		CreatedAt:     time.Now(),
+	}
+	if option, ok := options["variable"]; ok {
+		item.VariableAmount = option.BoolValue()
+	}
+	if item.Name == "" {
+		return "❌ 名前を入力してください。"
+	}
+	if item.Amount <= 0 && !item.VariableAmount {
+		return "❌ 金額は1円以上を指定してください（毎回変わる場合は variable:True を指定してください）。"
+	}
+
+	categoryID, found := h.findCategoryIDByName(options["category"].StringValue())
+	if !found {
+		return fmt.Sprintf("❌ カテゴリー「%s」が見つかりません。", options["category"].StringValue())
+	}
+	item.CategoryID = categoryID
+	if paymentMethod, ok := h.findPaymentMethodByWord(item.PaymentMethod); ok {
+		item.PaymentMethod = paymentMethod
+	}
+	if option, ok := options["group"]; ok {
+		item.GroupID = h.findGroupByKeyword(option.StringValue())
+		if item.GroupID == nil {
+			return fmt.Sprintf("❌ グループ「%s」が見つかりません。", option.StringValue())
+		}
+	}
+	if option, ok := options["payer"]; ok {
+		userID, found := h.findUserByName(option.StringValue())
+		if !found {
+			return fmt.Sprintf("❌ 支払者「%s」が見つかりません。", option.StringValue())
+		}
+		item.UserID = userID
+	}
+
+	now := nowInTokyo()
+	item.Schedule.StartDate = now.Format(isoDateLayout)
+	if option, ok := options["start"]; ok {
+		startDate, err := normalizeDate(option.StringValue(), now)
+		if err != nil {
+			return describeDateError(option.StringValue(), err)
+		}
+		item.Schedule.StartDate = startDate
+	}
+	start, _ := time.ParseInLocation(isoDateLayout, item.Schedule.StartDate, tokyoLocation)
+	if option, ok := options["day"]; ok {
+		item.Schedule.Day = int(option.IntValue())
+	}
+	if option, ok := options["month"]; ok {
+		item.Schedule.Month = int(option.IntValue())
+	}
+	if option, ok := options["interval_weeks"]; ok {
+		item.Schedule.IntervalWeeks = int(option.IntValue())
+	}
+
+	// 省略された日・月は開始日から決める
+	switch item.Schedule.Kind {
+	case recurringMonthly, recurringYearly:
+		if item.Schedule.Day == 0 {
+			item.Schedule.Day = start.Day()
+		}
+		if item.Schedule.Kind == recurringYearly && item.Schedule.Month == 0 {
+			item.Schedule.Month = int(start.Month())
+		}
+		if item.Schedule.Day < 1 || item.Schedule.Day > 31 || item.Schedule.Month < 0 || item.Schedule.Month > 12 {
+			return "❌ 日は1〜31、月は1〜12で指定してください。"
+		}
+	case recurringWeekly:
+		if item.Schedule.IntervalWeeks == 0 {
+			item.Schedule.IntervalWeeks = 1
+		}
+		if item.Schedule.IntervalWeeks < 1 {
+			return "❌ interval_weeks は1以上を指定してください。"
+		}
+	}
+	nextDue, err := item.Schedule.nextDue(start.AddDate(0, 0, -1))
+	if err != nil {
+		return fmt.Sprintf("❌ %s", describeAnalysisError(err))
+	}
+
+	h.recurringMutex.Lock()
+	defer h.recurringMutex.Unlock()
+
+	if h.recurringSet.NextID == 0 {
+		h.recurringSet.NextID = 1
+	}
+	item.ID = fmt.Sprintf("S%d", h.recurringSet.NextID)
+	h.recurringSet.NextID++
+	h.recurringSet.Items = append(h.recurringSet.Items, item)
+	if err := h.saveRecurringLocked(); err != nil {
+		HandleError(err, nil)
+		h.recurringSet.Items = h.recurringSet.Items[:len(h.recurringSet.Items)-1]
+		return "❌ エラー: 定期支出の保存に失敗しました。"
+	}
+
+	log.Printf("定期支出を追加しました: %s", item.describe(h))
+	return fmt.Sprintf("✅ 定期支出を追加しました。\n%s\n次回: %s", item.describe(h), nextDue.Format(isoDateLayout))
+}
+
+// listRecurring は定期支出の一覧と次回の予定日を表示する
+func (h *Household) listRecurring() string {
+	h.recurringMutex.Lock()
+	defer h.recurringMutex.Unlock()
+
+	if len(h.recurringSet.Items) == 0 {
+		return "登録されている定期支出はありません。`/recurring add` で追加できます。"
+	}
+	var builder strings.Builder
+	builder.WriteString("🔁 定期支出\n")
+	for _, item := range h.recurringSet.Items {
+		builder.WriteString(item.describe(h))
+		last := time.Time{}
+		if parsed, err := time.ParseInLocation(isoDateLayout, item.LastRunDate, tokyoLocation); err == nil {
+			last = parsed
+		}
+		if nextDue, err := item.Schedule.nextDue(last); err == nil {
+			fmt.Fprintf(&builder, "（次回: %s）", nextDue.Format(isoDateLayout))
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// deleteRecurring は定期支出を削除する（登録済みのExpenseはそのまま）
+func (h *Household) deleteRecurring(recurringID string) string {
+	recurringID = strings.ToUpper(strings.TrimSpace(recurringID))
+
+	h.recurringMutex.Lock()
+	defer h.recurringMutex.Unlock()
+
+	for idx, item := range h.recurringSet.Items {
+		if item.ID != recurringID {
+			continue
+		}
+		previous := h.recurringSet.Items
+		h.recurringSet.Items = append(append([]RecurringExpense(nil), previous[:idx]...), previous[idx+1:]...)
+		if err := h.saveRecurringLocked(); err != nil {
+			HandleError(err, nil)
+			h.recurringSet.Items = previous
+			return "❌ エラー: 定期支出の削除に失敗しました。"
+		}
+		log.Printf("定期支出を削除しました: %s", item.describe(h))
+		return fmt.Sprintf("🗑️ 定期支出を削除しました。\n%s", item.describe(h))
+	}
+	return fmt.Sprintf("❌ ID「%s」の定期支出が見つかりません。", recurringID)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRecurringScheduleNextDue(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule RecurringSchedule
+		after    string
+		want     string
+	}{
+		{"毎月・当日は含まない", RecurringSchedule{Kind: recurringMonthly, Day: 15, StartDate: "2025-01-01"}, "2025-01-15", "2025-02-15"},
+		{"毎月・前日", RecurringSchedule{Kind: recurringMonthly, Day: 15, StartDate: "2025-01-01"}, "2025-01-14", "2025-01-15"},
+		{"毎月31日・2月は月末", RecurringSchedule{Kind: recurringMonthly, Day: 31, StartDate: "2025-01-01"}, "2025-01-31", "2025-02-28"},
+		{"毎月31日・うるう年の2月", RecurringSchedule{Kind: recurringMonthly, Day: 31, StartDate: "2024-01-01"}, "2024-01-31", "2024-02-29"},
+		{"毎月31日・月末の翌月", RecurringSchedule{Kind: recurringMonthly, Day: 31, StartDate: "2025-01-01"}, "2025-02-28", "2025-03-31"},
+		{"毎月・年をまたぐ", RecurringSchedule{Kind: recurringMonthly, Day: 10, StartDate: "2025-01-01"}, "2025-12-10", "2026-01-10"},
+		{"毎月・開始日より前", RecurringSchedule{Kind: recurringMonthly, Day: 1, StartDate: "2025-03-10"}, "0001-01-01", "2025-04-01"},
+		{"毎年2月29日・平年は月末", RecurringSchedule{Kind: recurringYearly, Month: 2, Day: 29, StartDate: "2024-01-01"}, "2024-02-29", "2025-02-28"},
+		{"毎年2月29日・うるう年", RecurringSchedule{Kind: recurringYearly, Month: 2, Day: 29, StartDate: "2023-01-01"}, "2023-02-28", "2024-02-29"},
+		{"毎年・翌年", RecurringSchedule{Kind: recurringYearly, Month: 4, Day: 1, StartDate: "2025-01-01"}, "2025-04-01", "2026-04-01"},
+		{"2週間ごと・起点", RecurringSchedule{Kind: recurringWeekly, IntervalWeeks: 2, StartDate: "2025-01-06"}, "0001-01-01", "2025-01-06"},
+		{"2週間ごと・当日は含まない", RecurringSchedule{Kind: recurringWeekly, IntervalWeeks: 2, StartDate: "2025-01-06"}, "2025-01-06", "2025-01-20"},
+		{"2週間ごと・前日", RecurringSchedule{Kind: recurringWeekly, IntervalWeeks: 2, StartDate: "2025-01-06"}, "2025-01-19", "2025-01-20"},
+		{"2週間ごと・月をまたぐ", RecurringSchedule{Kind: recurringWeekly, IntervalWeeks: 2, StartDate: "2025-01-06"}, "2025-01-20", "2025-02-03"},
+		{"間隔の指定なしは毎週", RecurringSchedule{Kind: recurringWeekly, StartDate: "2025-01-06"}, "2025-01-06", "2025-01-13"},
+		{"最終営業日・月末が日曜", RecurringSchedule{Kind: recurringLastBusinessDay, StartDate: "2025-01-01"}, "2025-07-31", "2025-08-29"},
+		{"最終営業日・月末が平日", RecurringSchedule{Kind: recurringLastBusinessDay, StartDate: "2025-01-01"}, "2025-08-29", "2025-09-30"},
+		{"最終営業日・月末の土日は同じ月", RecurringSchedule{Kind: recurringLastBusinessDay, StartDate: "2025-01-01"}, "2025-11-28", "2025-12-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after, err := time.ParseInLocation(isoDateLayout, tt.after, tokyoLocation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.schedule.nextDue(after)
+			if err != nil {
+				t.Fatalf("nextDue(%s) returned error: %v", tt.after, err)
+			}
+			if got.Format(isoDateLayout) != tt.want {
+				t.Errorf("nextDue(%s) = %s, want %s", tt.after, got.Format(isoDateLayout), tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurringScheduleNextDueRejectsInvalid(t *testing.T) {
+	after := time.Date(2025, 1, 1, 0, 0, 0, 0, tokyoLocation)
+	for _, schedule := range []RecurringSchedule{
+		{Kind: recurringMonthly, Day: 1, StartDate: "2025/01/01"},
+		{Kind: "daily", StartDate: "2025-01-01"},
+	} {
+		if _, err := schedule.nextDue(after); err == nil {
+			t.Errorf("nextDue() with %+v returned no error", schedule)
+		}
+	}
+}
+
+// recordingTransport はDiscord APIへのリクエストを記録し、送信に成功したものとして応答する
+type recordingTransport struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (transport *recordingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport.mu.Lock()
+	transport.requests = append(transport.requests, request.Method+" "+request.URL.Path)
+	transport.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id":"1","channel_id":"C1"}`)),
+		Request:    request,
+	}, nil
+}
+
+func (transport *recordingTransport) count() int {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	return len(transport.requests)
+}
+
+// newRecurringTestHousehold はデータディレクトリを一時ディレクトリにした家計と、送信を記録するセッションを作る
+func newRecurringTestHousehold(t *testing.T, dataDir string, items ...RecurringExpense) (*Household, *discordgo.Session, *recordingTransport) {
+	t.Helper()
+	h := &Household{HouseholdConfig: HouseholdConfig{ID: "home", ChannelID: "C1", DataDir: dataDir, QueueDir: dataDir}}
+	if len(items) > 0 {
+		h.recurringSet = RecurringSet{NextID: len(items) + 1, Items: items}
+		h.recurringMutex.Lock()
+		err := h.saveRecurringLocked()
+		h.recurringMutex.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else if err := h.loadRecurring(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &recordingTransport{}
+	s.Client = &http.Client{Transport: transport}
+	return h, s, transport
+}
+
+func TestRunDueRecurringCatchesUpAfterDowntime(t *testing.T) {
+	dataDir := t.TempDir()
+	h, s, _ := newRecurringTestHousehold(t, dataDir, RecurringExpense{
+		ID: "R1", Name: "家賃", Amount: 80000, CategoryID: 1, UserID: 1,
+		Schedule:    RecurringSchedule{Kind: recurringMonthly, Day: 25, StartDate: "2025-01-01"},
+		LastRunDate: "2025-05-25",
+	})
+
+	// 6月〜8月の3回分が停止中に過ぎていた
+	now := time.Date(2025, 8, 26, 9, 0, 0, 0, tokyoLocation)
+	h.runDueRecurring(s, now)
+
+	queue, err := h.loadExpenseQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, expense := range queue {
+		ids = append(ids, expense.ID+"@"+expense.Date)
+	}
+	want := []string{"recurring-R1-20250625@2025-06-25", "recurring-R1-20250725@2025-07-25", "recurring-R1-20250825@2025-08-25"}
+	if strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Fatalf("queue = %v, want %v", ids, want)
+	}
+
+	// 保存した登録済みの予定日は再起動後も引き継がれる
+	restarted, s, _ := newRecurringTestHousehold(t, dataDir)
+	if got := restarted.recurringSet.Items[0].LastRunDate; got != "2025-08-25" {
+		t.Errorf("LastRunDate = %s, want 2025-08-25", got)
+	}
+
+	// 登録してから予定日を記録する前に停止した場合も、同じ予定日は二重に登録しない
+	restarted.recurringSet.Items[0].LastRunDate = "2025-07-25"
+	restarted.runDueRecurring(s, now)
+	if queue, _ := restarted.loadExpenseQueue(); len(queue) != len(want) {
+		t.Errorf("再実行後のキューの件数 = %d, want %d", len(queue), len(want))
+	}
+	if got := restarted.recurringSet.Items[0].LastRunDate; got != "2025-08-25" {
+		t.Errorf("再実行後のLastRunDate = %s, want 2025-08-25", got)
+	}
+}
+
+func TestRunDueRecurringDoesNotRepostVariableAmountAfterRestart(t *testing.T) {
+	dataDir := t.TempDir()
+	item := RecurringExpense{
+		ID: "R2", Name: "電気代", Amount: 8000, VariableAmount: true, CategoryID: 1, UserID: 1,
+		Schedule:    RecurringSchedule{Kind: recurringMonthly, Day: 10, StartDate: "2025-01-01"},
+		LastRunDate: "2025-06-10",
+		CreatedBy:   "U1",
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, date := range []string{"2025-07-10", "2025-08-10"} {
+			delete(confirmationData, recurringEntryID(item.ID, date))
+		}
+	})
+
+	h, s, transport := newRecurringTestHousehold(t, dataDir, item)
+	now := time.Date(2025, 8, 11, 9, 0, 0, 0, tokyoLocation)
+	h.runDueRecurring(s, now)
+
+	// 7月・8月分それぞれ、通知と確認画面の2件
+	if got := transport.count(); got != 4 {
+		t.Fatalf("送信したメッセージ = %d, want 4", got)
+	}
+	if got := getConfirmationData(recurringEntryID(item.ID, "2025-08-10")); got == nil || got.Amount != item.Amount {
+		t.Errorf("確認画面のデータ = %+v", got)
+	}
+	if queue, _ := h.loadExpenseQueue(); len(queue) != 0 {
+		t.Errorf("確認前にキューに追加されています: %v", queue)
+	}
+
+	// 8月分の確認画面を表示してから登録済みの予定日を記録する前に停止した状態で再起動する
+	// （メモリ上の確認画面は失われるが、保存した表示済みの予定日で二重表示を防ぐ）
+	item.LastRunDate = "2025-07-10"
+	item.PostedDate = "2025-08-10"
+	mu.Lock()
+	delete(confirmationData, recurringEntryID(item.ID, "2025-08-10"))
+	mu.Unlock()
+	restarted, s, transport := newRecurringTestHousehold(t, dataDir, item)
+	restarted.runDueRecurring(s, now)
+
+	if got := transport.count(); got != 0 {
+		t.Errorf("再起動後に送信したメッセージ = %d, want 0", got)
+	}
+	if got := restarted.recurringSet.Items[0].LastRunDate; got != "2025-08-10" {
+		t.Errorf("LastRunDate = %s, want 2025-08-10", got)
+	}
+}
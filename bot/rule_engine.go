@@ -0,0 +1,608 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 自動分類ルール
+// =================================================================================
+
+const rulesFile = "rules.json"    // 家計のデータディレクトリ内
+const defaultRulePriority = 100   // 優先度の既定値（小さいほど先に評価する）
+const maxDryRunLines = 15         // ドライランで表示する変更予定の最大件数
+const maxRuleMessageLength = 1900 // Discordのメッセージ上限（2000文字）に収めるための上限
+
+var (
+	ruleClauseSeparator = regexp.MustCompile(`(?i)\s+(?:and|かつ)\s+|\s*&&\s*`)
+	ruleClausePattern   = regexp.MustCompile(`^(\S+?)\s*(contains|含む|>=|<=|==|=|>|<)\s*(.+)$`)
+	// ruleBareClausePattern は演算子を省略した「store 'ドラッグ'」の形（containsとして扱う）
+	ruleBareClausePattern = regexp.MustCompile(`^(\S+)\s+("[^"]*"|'[^']*'|「[^」]*」)$`)
+)
+
+// ruleFieldAliases は条件で使える項目名（日本語の別名を含む）
+var ruleFieldAliases = map[string]string{
+	"detail": "detail", "詳細": "detail",
+	"store": "store", "店舗": "store", "店舗名": "store",
+	"amount": "amount", "金額": "amount",
+	"payment": "payment", "支払い": "payment", "支払い方法": "payment",
+}
+
+// ruleFieldLabels は項目の表示名
+var ruleFieldLabels = map[string]string{
+	"detail": "詳細", "store": "店舗", "amount": "金額", "payment": "支払い方法",
+}
+
+// RuleSet はルールファイルの内容
+type RuleSet struct {
+	NextID int    `json:"next_id"`
+	Rules  []Rule `json:"rules"`
+}
+
+// RuleCondition はルールの条件1つ（すべての条件を満たすとルールが適用される）
+type RuleCondition struct {
+	Field    string `json:"field"`    // detail, store, amount, payment
+	Operator string `json:"operator"` // contains, =, >, >=, <, <=
+	Value    string `json:"value"`
+	Amount   int    `json:"amount,omitempty"` // 金額の条件の場合の比較値
+}
+
+// RuleActions はルールが適用されたときに設定する項目（nil・空は変更しない）
+type RuleActions struct {
+	CategoryID    *int   `json:"category_id,omitempty"`
+	GroupID       *int   `json:"group_id,omitempty"`
+	PaymentMethod string `json:"payment_method,omitempty"`
+}
+
+// Rule は自動分類ルール
+type Rule struct {
+	ID         string          `json:"id"`
+	Priority   int             `json:"priority"`
+	Source     string          `json:"source"` // 登録時に入力された条件式
+	Conditions []RuleCondition `json:"conditions"`
+	Actions    RuleActions     `json:"actions"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// RuleInput はルールの評価対象
+type RuleInput struct {
+	Detail        string
+	StoreName     string // 不明な場合は詳細を店舗の条件にも使う
+	Amount        int
+	PaymentMethod string
+	Fixed         RuleFixedFields
+}
+
+// RuleFixedFields はユーザーが指定済みのため、ルールで上書きしない項目
+type RuleFixedFields struct {
+	Category      bool
+	Group         bool
+	PaymentMethod bool
+}
+
+// RuleResult はルールを評価した結果（項目ごとに最初に適用されたルールの値が入る）
+type RuleResult struct {
+	RuleActions
+	Fired []Rule
+}
+
+// loadRules は起動時にルールファイルを読み込む
+func (h *Household) loadRules() error {
+	h.ruleMutex.Lock()
+	defer h.ruleMutex.Unlock()
+
+	h.ruleSet = RuleSet{NextID: 1}
+	path := h.dataPath(rulesFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return NewBotError(ErrorTypeFileIO, "ルールファイルの読み込みに失敗", err).
+			WithContext("file_path", path)
+	}
+	if err := json.Unmarshal(data, &h.ruleSet); err != nil {
+		h.ruleSet = RuleSet{NextID: 1}
+		return NewBotError(ErrorTypeFileIO, "ルールファイルのJSONパースエラー", err).
+			WithContext("file_path", path)
+	}
+
+	log.Printf("-> %d件の自動分類ルールを読み込みました。", len(h.ruleSet.Rules))
+	return nil
+}
+
+// saveRulesLocked はルールをファイルに保存する（ruleMutexを保持して呼ぶこと）
+func (h *Household) saveRulesLocked() error {
+	data, err := json.MarshalIndent(h.ruleSet, "", "  ")
+	if err != nil {
+		return NewBotError(ErrorTypeFileIO, "ルールJSON生成エラー", err)
+	}
+	path := h.dataPath(rulesFile)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return NewBotError(ErrorTypeFileIO, "ルールファイルの書き込みに失敗", err).
+			WithContext("file_path", path)
+	}
+	return nil
+}
+
+// sortedRulesLocked は優先度順（同じ優先度なら登録順）に並べたルールを返す（ruleMutexを保持して呼ぶこと）
+func (h *Household) sortedRulesLocked() []Rule {
+	rules := append([]Rule(nil), h.ruleSet.Rules...)
+	sort.SliceStable(rules, func(a, b int) bool {
+		return rules[a].Priority < rules[b].Priority
+	})
+	return rules
+}
+
+// parseRuleConditions は「store contains ドラッグ and amount > 3000」のような条件式を解析する
+func parseRuleConditions(source string) ([]RuleCondition, error) {
+	var conditions []RuleCondition
+	for _, clause := range ruleClauseSeparator.Split(strings.TrimSpace(source), -1) {
+		clause = strings.TrimSpace(clause)
+		match := ruleClausePattern.FindStringSubmatch(clause)
+		if match == nil {
+			bare := ruleBareClausePattern.FindStringSubmatch(clause)
+			if bare == nil {
+				return nil, NewBotError(ErrorTypeValidation, fmt.Sprintf("条件「%s」を解釈できません", clause), nil)
+			}
+			match = []string{bare[0], bare[1], "contains", bare[2]}
+		}
+
+		field, ok := ruleFieldAliases[strings.ToLower(match[1])]
+		if !ok {
+			return nil, NewBotError(ErrorTypeValidation, fmt.Sprintf("項目「%s」は使えません（detail, store, amount, payment）", match[1]), nil)
+		}
+		operator := match[2]
+		switch operator {
+		case "含む":
+			operator = "contains"
+		case "==":
+			operator = "="
+		}
+		value := strings.Trim(strings.TrimSpace(match[3]), `"'「」`)
+		if value == "" {
+			return nil, NewBotError(ErrorTypeValidation, fmt.Sprintf("条件「%s」の値が空です", clause), nil)
+		}
+
+		condition := RuleCondition{Field: field, Operator: operator, Value: value}
+		if field == "amount" {
+			if operator == "contains" {
+				return nil, NewBotError(ErrorTypeValidation, "金額には比較演算子（=, >, >=, <, <=）を使ってください", nil)
+			}
+			amount, err := parseAmount(value)
+			if err != nil {
+				return nil, err
+			}
+			condition.Amount = amount
+		} else if operator != "contains" && operator != "=" {
+			return nil, NewBotError(ErrorTypeValidation, fmt.Sprintf("%sには contains または = を使ってください", ruleFieldLabels[field]), nil)
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 0 {
+		return nil, NewBotError(ErrorTypeValidation, "条件が指定されていません", nil)
+	}
+	return conditions, nil
+}
+
+// normalizeRuleText は比較用に全角・空白・大文字小文字の違いをなくす
+func normalizeRuleText(text string) string {
+	return normalizeForDuplicateCheck(toHalfWidth(text))
+}
+
+// matches は条件が評価対象に当てはまるかを判定する
+func (c RuleCondition) matches(input RuleInput) bool {
+	if c.Field == "amount" {
+		switch c.Operator {
+		case "=":
+			return input.Amount == c.Amount
+		case ">":
+			return input.Amount > c.Amount
+		case ">=":
+			return input.Amount >= c.Amount
+		case "<":
+			return input.Amount < c.Amount
+		case "<=":
+			return input.Amount <= c.Amount
+		}
+		return false
+	}
+
+	var target string
+	switch c.Field {
+	case "detail":
+		target = input.Detail
+	case "store":
+		target = input.StoreName
+		if target == "" {
+			target = input.Detail
+		}
+	case "payment":
+		target = input.PaymentMethod
+	}
+	target, value := normalizeRuleText(target), normalizeRuleText(c.Value)
+	if c.Operator == "=" {
+		return target == value
+	}
+	return value != "" && strings.Contains(target, value)
+}
+
+// matches はルールのすべての条件が当てはまるかを判定する
+func (r Rule) matches(input RuleInput) bool {
+	for _, condition := range r.Conditions {
+		if !condition.matches(input) {
+			return false
+		}
+	}
+	return len(r.Conditions) > 0
+}
+
+// describe はルールが設定する項目を表示用の文字列にする
+func (a RuleActions) describe(h *Household) string {
+	var parts []string
+	if a.CategoryID != nil {
+		parts = append(parts, "カテゴリー: "+h.categoryNameByID(*a.CategoryID))
+	}
+	if a.GroupID != nil {
+		parts = append(parts, "グループ: "+h.groupNameByID(a.GroupID))
+	}
+	if a.PaymentMethod != "" {
+		parts = append(parts, "支払い方法: "+a.PaymentMethod)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describe はルールを表示用の1行にする
+func (r Rule) describe(h *Household) string {
+	return fmt.Sprintf("`%s` (優先度%d) %s → %s", r.ID, r.Priority, r.Source, r.Actions.describe(h))
+}
+
+// categoryNameByID はカテゴリーIDから名前を返す
+func (h *Household) categoryNameByID(categoryID int) string {
+	for _, category := range h.masterCategories {
+		if category.ID == categoryID {
+			return category.Name
+		}
+	}
+	return "不明"
+}
+
+// findCategoryIDByName はカテゴリー名（完全一致を優先し、なければ部分一致）からIDを探す
+func (h *Household) findCategoryIDByName(name string) (int, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return 0, false
+	}
+	for _, category := range h.masterCategories {
+		if strings.ToLower(category.Name) == name {
+			return category.ID, true
+		}
+	}
+	for _, category := range h.masterCategories {
+		if strings.Contains(strings.ToLower(category.Name), name) {
+			return category.ID, true
+		}
+	}
+	return 0, false
+}
+
+// groupNameByID はグループIDから名前を返す（nilは「なし」）
+func (h *Household) groupNameByID(groupID *int) string {
+	if groupID == nil {
+		return "なし"
+	}
+	for _, group := range h.masterGroups {
+		if group.ID == *groupID {
+			return group.Name
+		}
+	}
+	return "不明"
+}
+
+// applyRules は優先度順にルールを評価する（先に適用されたルールが設定した項目は後のルールで上書きしない）
+func (h *Household) applyRules(input RuleInput) RuleResult {
+	h.ruleMutex.Lock()
+	rules := h.sortedRulesLocked()
+	h.ruleMutex.Unlock()
+	return evaluateRules(rules, input)
+}
+
+// evaluateRules は並べ替え済みのルールを順に評価し、ユーザーが指定済みの項目以外に設定を適用する
+func evaluateRules(rules []Rule, input RuleInput) RuleResult {
+	var result RuleResult
+	for _, rule := range rules {
+		if !rule.matches(input) {
+			continue
+		}
+		applied := false
+		if rule.Actions.CategoryID != nil && result.CategoryID == nil && !input.Fixed.Category {
+			result.CategoryID = rule.Actions.CategoryID
+			applied = true
+		}
+		if rule.Actions.GroupID != nil && result.GroupID == nil && !input.Fixed.Group {
+			result.GroupID = rule.Actions.GroupID
+			applied = true
+		}
+		if rule.Actions.PaymentMethod != "" && result.PaymentMethod == "" && !input.Fixed.PaymentMethod {
+			result.PaymentMethod = rule.Actions.PaymentMethod
+			applied = true
+		}
+		if applied {
+			result.Fired = append(result.Fired, rule)
+		}
+	}
+	return result
+}
+
+// appliedRulesField は確認画面に適用されたルールを表示するフィールドを返す
+func appliedRulesField(data *ConfirmationData) *discordgo.MessageEmbedField {
+	if len(data.AppliedRules) == 0 {
+		return nil
+	}
+	return &discordgo.MessageEmbedField{
+		Name:   "⚙️ 適用されたルール",
+		Value:  strings.Join(data.AppliedRules, "\n"),
+		Inline: false,
+	}
+}
+
+// handleRule は /rule コマンドの処理（サブコマンドごとに振り分ける）
+func handleRule(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	h := householdForInteraction(i)
+	subcommand := i.ApplicationCommandData().Options[0]
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range subcommand.Options {
+		options[option.Name] = option
+	}
+
+	var content string
+	switch subcommand.Name {
+	case "add":
+		content = h.addRuleFromOptions(options)
+	case "list":
+		content = h.listRules()
+	case "delete":
+		content = h.deleteRule(options["id"].StringValue())
+	case "dryrun":
+		var ruleID string
+		if option, ok := options["id"]; ok {
+			ruleID = option.StringValue()
+		}
+		content = h.dryRunRules(ruleID)
+	}
+
+	if runes := []rune(content); len(runes) > maxRuleMessageLength {
+		content = string(runes[:maxRuleMessageLength]) + "\n…（省略されました）"
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("ルールコマンド応答エラー: %v", err)
+	}
+}
+
+// addRuleFromOptions は /rule add の入力からルールを登録し、応答メッセージを返す
+func (h *Household) addRuleFromOptions(options map[string]*discordgo.ApplicationCommandInteractionDataOption) string {
+	source := options["condition"].StringValue()
+	conditions, err := parseRuleConditions(source)
+	if err != nil {
+		return fmt.Sprintf("❌ %s\n例: `detail contains Suica`、`store contains ドラッグ and amount > 3000`", describeAnalysisError(err))
+	}
+
+	var actions RuleActions
+	if option, ok := options["category"]; ok {
+		// findCategoryByKeywordは見つからない場合に既定のカテゴリーを返すため、名前で厳密に探す
+		categoryID, found := h.findCategoryIDByName(option.StringValue())
+		if !found {
+			return fmt.Sprintf("❌ カテゴリー「%s」が見つかりません。", option.StringValue())
+		}
+		actions.CategoryID = &categoryID
+	}
+	if option, ok := options["group"]; ok {
+		groupID := h.findGroupByKeyword(option.StringValue())
+		if groupID == nil {
+			return fmt.Sprintf("❌ グループ「%s」が見つかりません。", option.StringValue())
+		}
+		actions.GroupID = groupID
+	}
+	if option, ok := options["payment"]; ok {
+		// キューの支出には支払いIDで保存するため、マスターデータにある支払い方法に限る
+		actions.PaymentMethod = strings.TrimSpace(option.StringValue())
+		if h.paymentIDByName(actions.PaymentMethod) == nil {
+			return fmt.Sprintf("❌ 支払い方法「%s」が見つかりません。", actions.PaymentMethod)
+		}
+	}
+	if actions.CategoryID == nil && actions.GroupID == nil && actions.PaymentMethod == "" {
+		return "❌ category・group・payment のいずれかを指定してください。"
+	}
+
+	priority := defaultRulePriority
+	if option, ok := options["priority"]; ok {
+		priority = int(option.IntValue())
+	}
+
+	h.ruleMutex.Lock()
+	defer h.ruleMutex.Unlock()
+
+	if h.ruleSet.NextID == 0 {
+		h.ruleSet.NextID = 1
+	}
+	rule := Rule{
+		ID:         fmt.Sprintf("R%d", h.ruleSet.NextID),
+		Priority:   priority,
+		Source:     strings.TrimSpace(source),
+		Conditions: conditions,
+		Actions:    actions,
+		CreatedAt:  time.Now(),
+	}
+	h.ruleSet.NextID++
+	h.ruleSet.Rules = append(h.ruleSet.Rules, rule)
+	if err := h.saveRulesLocked(); err != nil {
+		HandleError(err, nil)
+		h.ruleSet.Rules = h.ruleSet.Rules[:len(h.ruleSet.Rules)-1]
+		return "❌ エラー: ルールの保存に失敗しました。"
+	}
+
+	log.Printf("ルールを追加しました: %s", rule.describe(h))
+	return fmt.Sprintf("✅ ルールを追加しました。\n%s\n`/rule dryrun id:%s` で既存のキューに対する動作を確認できます。", rule.describe(h), rule.ID)
+}
+
+// listRules は登録済みのルールを優先度順に一覧表示する
+func (h *Household) listRules() string {
+	h.ruleMutex.Lock()
+	rules := h.sortedRulesLocked()
+	h.ruleMutex.Unlock()
+
+	if len(rules) == 0 {
+		return "📭 ルールは登録されていません。`/rule add` で追加できます。"
+	}
+	lines := []string{fmt.Sprintf("⚙️ 自動分類ルール (%d件、上から順に評価):", len(rules))}
+	for _, rule := range rules {
+		lines = append(lines, "・"+rule.describe(h))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// deleteRule は指定したIDのルールを削除する
+func (h *Household) deleteRule(ruleID string) string {
+	ruleID = strings.ToUpper(strings.TrimSpace(ruleID))
+
+	h.ruleMutex.Lock()
+	defer h.ruleMutex.Unlock()
+
+	for idx, rule := range h.ruleSet.Rules {
+		if rule.ID != ruleID {
+			continue
+		}
+		previous := h.ruleSet.Rules
+		h.ruleSet.Rules = append(append([]Rule(nil), previous[:idx]...), previous[idx+1:]...)
+		if err := h.saveRulesLocked(); err != nil {
+			HandleError(err, nil)
+			h.ruleSet.Rules = previous
+			return "❌ エラー: ルールの削除に失敗しました。"
+		}
+		log.Printf("ルールを削除しました: %s", rule.describe(h))
+		return fmt.Sprintf("🗑️ ルールを削除しました。\n%s", rule.describe(h))
+	}
+	return fmt.Sprintf("❌ ID「%s」のルールが見つかりません。", ruleID)
+}
+
+// paymentNameByID は支払いIDから支払い方法名を返す
+func (h *Household) paymentNameByID(paymentID *int) string {
+	if paymentID == nil {
+		return ""
+	}
+	for _, payment := range h.masterPaymentTypes {
+		if payment.PayID == *paymentID {
+			return payment.PayKind
+		}
+	}
+	return ""
+}
+
+// dryRunRules はキュー内の既存データにルールを適用した場合の変更内容を表示する（データは変更しない）
+func (h *Household) dryRunRules(ruleID string) string {
+	ruleID = strings.ToUpper(strings.TrimSpace(ruleID))
+
+	h.expenseQueueMutex.Lock()
+	expenseQueue, err := h.loadExpenseQueue()
+	h.expenseQueueMutex.Unlock()
+	if err != nil {
+		HandleError(err, nil)
+		return "❌ エラー: キューの読み込みに失敗しました。"
+	}
+
+	h.ruleMutex.Lock()
+	rules := h.sortedRulesLocked()
+	h.ruleMutex.Unlock()
+	if ruleID != "" {
+		var filtered []Rule
+		for _, rule := range rules {
+			if rule.ID == ruleID {
+				filtered = append(filtered, rule)
+			}
+		}
+		if len(filtered) == 0 {
+			return fmt.Sprintf("❌ ID「%s」のルールが見つかりません。", ruleID)
+		}
+		rules = filtered
+	}
+	if len(rules) == 0 {
+		return "📭 ルールは登録されていません。"
+	}
+
+	firedCounts := make(map[string]int)
+	var lines []string
+	changed := 0
+	for _, expense := range expenseQueue {
+		// キューには店舗名が保存されていないため、店舗の条件は詳細に対して評価する
+		input := RuleInput{
+			Detail:        expense.Detail,
+			Amount:        expense.Price,
+			PaymentMethod: h.paymentNameByID(expense.PaymentID),
+		}
+
+		for _, rule := range rules {
+			if rule.matches(input) {
+				firedCounts[rule.ID]++
+			}
+		}
+		result := evaluateRules(rules, input)
+		if len(result.Fired) == 0 {
+			continue
+		}
+
+		var diffs []string
+		if result.CategoryID != nil && *result.CategoryID != expense.CategoryID {
+			diffs = append(diffs, fmt.Sprintf("カテゴリー: %s→%s", h.categoryNameByID(expense.CategoryID), h.categoryNameByID(*result.CategoryID)))
+		}
+		if result.GroupID != nil && groupHistoryKey(result.GroupID) != groupHistoryKey(expense.GroupID) {
+			diffs = append(diffs, fmt.Sprintf("グループ: %s→%s", h.groupNameByID(expense.GroupID), h.groupNameByID(result.GroupID)))
+		}
+		if result.PaymentMethod != "" && result.PaymentMethod != input.PaymentMethod {
+			current := input.PaymentMethod
+			if current == "" {
+				current = "不明"
+			}
+			diffs = append(diffs, fmt.Sprintf("支払い方法: %s→%s", current, result.PaymentMethod))
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+		changed++
+		if len(lines) < maxDryRunLines {
+			lines = append(lines, fmt.Sprintf("・`%s` %s ¥%d %s: %s (%s)",
+				expense.ID, expense.Date, expense.Price, expense.Detail, strings.Join(diffs, ", "), result.Fired[0].ID))
+		}
+	}
+
+	summary := []string{fmt.Sprintf("🧪 ドライラン結果（キュー%d件、データは変更していません）:", len(expenseQueue))}
+	for _, rule := range rules {
+		summary = append(summary, fmt.Sprintf("・%s: %d件に一致", rule.ID, firedCounts[rule.ID]))
+	}
+	if changed == 0 {
+		summary = append(summary, "分類が変わるデータはありません。")
+		return strings.Join(summary, "\n")
+	}
+	summary = append(summary, fmt.Sprintf("\n分類が変わるデータ (%d件):", changed))
+	summary = append(summary, lines...)
+	if changed > len(lines) {
+		summary = append(summary, fmt.Sprintf("…ほか%d件", changed-len(lines)))
+	}
+	return strings.Join(summary, "\n")
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRuleConditions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []RuleCondition
+	}{
+		{"store contains ドラッグ", []RuleCondition{{Field: "store", Operator: "contains", Value: "ドラッグ"}}},
+		{"店舗 含む 「ツルハ」", []RuleCondition{{Field: "store", Operator: "contains", Value: "ツルハ"}}},
+		{"detail = ランチ", []RuleCondition{{Field: "detail", Operator: "=", Value: "ランチ"}}},
+		{"payment==PayPay", []RuleCondition{{Field: "payment", Operator: "=", Value: "PayPay"}}},
+		{"金額>=3,000円", []RuleCondition{{Field: "amount", Operator: ">=", Value: "3,000円", Amount: 3000}}},
+		{"store 'ドラッグ'", []RuleCondition{{Field: "store", Operator: "contains", Value: "ドラッグ"}}},
+		{"store 'ドラッグ' and amount > 3000", []RuleCondition{
+			{Field: "store", Operator: "contains", Value: "ドラッグ"},
+			{Field: "amount", Operator: ">", Value: "3000", Amount: 3000},
+		}},
+		{"詳細 「Suica チャージ」", []RuleCondition{{Field: "detail", Operator: "contains", Value: "Suica チャージ"}}},
+		{"store contains ドラッグ and amount > 3000", []RuleCondition{
+			{Field: "store", Operator: "contains", Value: "ドラッグ"},
+			{Field: "amount", Operator: ">", Value: "3000", Amount: 3000},
+		}},
+		{`Store contains "Amazon" かつ 支払い方法 = カード && amount < 500`, []RuleCondition{
+			{Field: "store", Operator: "contains", Value: "Amazon"},
+			{Field: "payment", Operator: "=", Value: "カード"},
+			{Field: "amount", Operator: "<", Value: "500", Amount: 500},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			got, err := parseRuleConditions(tt.source)
+			if err != nil {
+				t.Fatalf("parseRuleConditions(%q) returned error: %v", tt.source, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRuleConditions(%q) = %+v, want %+v", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRuleConditionsRejectsInvalid(t *testing.T) {
+	for _, source := range []string{
+		"",
+		"ドラッグストア",
+		"category = 食費",
+		"store > 100",
+		"amount contains 100",
+		"amount > たくさん",
+		"store contains 「」",
+		"and amount > 3000",
+		"store ドラッグ",
+		"amount '3000'",
+	} {
+		t.Run(source, func(t *testing.T) {
+			if conditions, err := parseRuleConditions(source); err == nil {
+				t.Errorf("parseRuleConditions(%q) = %+v, want error", source, conditions)
+			}
+		})
+	}
+}
+
+func TestRuleConditionMatches(t *testing.T) {
+	input := RuleInput{Detail: "ランチ 弁当", StoreName: "ツルハドラッグ 長野店", Amount: 3000, PaymentMethod: "PayPay"}
+	tests := []struct {
+		name      string
+		condition RuleCondition
+		want      bool
+	}{
+		{"店舗を含む", RuleCondition{Field: "store", Operator: "contains", Value: "ドラッグ"}, true},
+		{"全角・大文字小文字の違いを無視", RuleCondition{Field: "payment", Operator: "contains", Value: "ｐａｙｐａｙ"}, true},
+		{"店舗の完全一致は空白を無視", RuleCondition{Field: "store", Operator: "=", Value: "ツルハドラッグ長野店"}, true},
+		{"店舗の完全一致でない", RuleCondition{Field: "store", Operator: "=", Value: "ツルハ"}, false},
+		{"詳細を含む", RuleCondition{Field: "detail", Operator: "contains", Value: "弁当"}, true},
+		{"詳細を含まない", RuleCondition{Field: "detail", Operator: "contains", Value: "ディナー"}, false},
+		{"金額=", RuleCondition{Field: "amount", Operator: "=", Amount: 3000}, true},
+		{"金額>は境界を含まない", RuleCondition{Field: "amount", Operator: ">", Amount: 3000}, false},
+		{"金額>=は境界を含む", RuleCondition{Field: "amount", Operator: ">=", Amount: 3000}, true},
+		{"金額<", RuleCondition{Field: "amount", Operator: "<", Amount: 3001}, true},
+		{"金額<=", RuleCondition{Field: "amount", Operator: "<=", Amount: 2999}, false},
+		{"金額の不明な演算子", RuleCondition{Field: "amount", Operator: "contains", Amount: 3000}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.condition.matches(input); got != tt.want {
+				t.Errorf("%+v.matches() = %t, want %t", tt.condition, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleConditionMatchesStoreFallsBackToDetail(t *testing.T) {
+	condition := RuleCondition{Field: "store", Operator: "contains", Value: "イオン"}
+	if !condition.matches(RuleInput{Detail: "イオン 食料品"}) {
+		t.Error("店舗名が不明な場合に詳細を店舗の条件に使っていません")
+	}
+	if condition.matches(RuleInput{Detail: "イオン 食料品", StoreName: "西友"}) {
+		t.Error("店舗名がある場合に詳細を店舗の条件に使っています")
+	}
+}
+
+func TestRuleMatchesRequiresAllConditions(t *testing.T) {
+	rule := Rule{Conditions: []RuleCondition{
+		{Field: "store", Operator: "contains", Value: "ドラッグ"},
+		{Field: "amount", Operator: ">", Amount: 3000},
+	}}
+	tests := []struct {
+		name  string
+		input RuleInput
+		want  bool
+	}{
+		{"すべて一致", RuleInput{StoreName: "ツルハドラッグ", Amount: 3500}, true},
+		{"一部のみ一致", RuleInput{StoreName: "ツルハドラッグ", Amount: 2000}, false},
+		{"一致しない", RuleInput{StoreName: "西友", Amount: 500}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rule.matches(tt.input); got != tt.want {
+				t.Errorf("matches(%+v) = %t, want %t", tt.input, got, tt.want)
+			}
+		})
+	}
+	if (Rule{}).matches(RuleInput{StoreName: "ツルハドラッグ"}) {
+		t.Error("条件のないルールが一致しています")
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	food, daily := 1, 2
+	family := 10
+	rules := []Rule{
+		{ID: "R1", Priority: 10, Conditions: []RuleCondition{{Field: "store", Operator: "contains", Value: "ドラッグ"}},
+			Actions: RuleActions{CategoryID: &daily}},
+		{ID: "R2", Priority: 20, Conditions: []RuleCondition{{Field: "amount", Operator: ">=", Amount: 3000}},
+			Actions: RuleActions{CategoryID: &food, GroupID: &family}},
+		{ID: "R3", Priority: 30, Conditions: []RuleCondition{{Field: "store", Operator: "contains", Value: "ドラッグ"}},
+			Actions: RuleActions{PaymentMethod: "クレジットカード"}},
+	}
+	input := RuleInput{StoreName: "ツルハドラッグ", Amount: 3500, PaymentMethod: "現金"}
+
+	tests := []struct {
+		name     string
+		fixed    RuleFixedFields
+		category *int
+		group    *int
+		payment  string
+		fired    []string
+	}{
+		{"先に適用されたルールを優先", RuleFixedFields{}, &daily, &family, "クレジットカード", []string{"R1", "R2", "R3"}},
+		{"指定済みのカテゴリーは上書きしない", RuleFixedFields{Category: true}, nil, &family, "クレジットカード", []string{"R2", "R3"}},
+		{"指定済みの支払い方法は上書きしない", RuleFixedFields{PaymentMethod: true}, &daily, &family, "", []string{"R1", "R2"}},
+		{"すべて指定済み", RuleFixedFields{Category: true, Group: true, PaymentMethod: true}, nil, nil, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := input
+			input.Fixed = tt.fixed
+			result := evaluateRules(rules, input)
+			if !reflect.DeepEqual(result.CategoryID, tt.category) || !reflect.DeepEqual(result.GroupID, tt.group) || result.PaymentMethod != tt.payment {
+				t.Errorf("evaluateRules() actions = %+v, want category=%v group=%v payment=%q", result.RuleActions, tt.category, tt.group, tt.payment)
+			}
+			var fired []string
+			for _, rule := range result.Fired {
+				fired = append(fired, rule.ID)
+			}
+			if !reflect.DeepEqual(fired, tt.fired) {
+				t.Errorf("evaluateRules() fired = %v, want %v", fired, tt.fired)
+			}
+		})
+	}
+}
+
+func TestNewConfirmationExpenseSavesPaymentID(t *testing.T) {
+	suica := 3
+	h := &Household{masterPaymentTypes: []PaymentType{{PayID: suica, PayKind: "Suica"}, {PayID: 5, PayKind: "現金"}}}
+	tests := []struct {
+		payment string
+		want    *int
+	}{
+		{"Suica", &suica},
+		{"楽天ペイ", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payment, func(t *testing.T) {
+			expense := h.newConfirmationExpense(&ConfirmationData{Amount: 500, PaymentMethod: tt.payment}, "2025-08-20")
+			if !reflect.DeepEqual(expense.PaymentID, tt.want) {
+				t.Errorf("PaymentID = %v, want %v", expense.PaymentID, tt.want)
+			}
+		})
+	}
+}
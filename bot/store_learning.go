@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// =================================================================================
+// 店舗ごとのカテゴリー・グループ・支払い方法の学習
+// =================================================================================
+
+const storeHistoryFile = "store_history.json" // 家計のデータディレクトリ内
+const noGroupKey = "none"                     // グループなしを表す集計キー
+
+// StoreChoice はキューに追加した支出で選ばれた分類
+type StoreChoice struct {
+	CategoryID    int    `json:"category_id"`
+	GroupID       *int   `json:"group_id,omitempty"`
+	PaymentMethod string `json:"payment_method,omitempty"`
+}
+
+// StoreHistory は店舗1件分の確定済みの分類の頻度
+type StoreHistory struct {
+	StoreName  string         `json:"store_name"` // 最後に記録した表記
+	Categories map[string]int `json:"categories"` // カテゴリーID -> 回数
+	Groups     map[string]int `json:"groups"`     // グループID（なしは"none"） -> 回数
+	Payments   map[string]int `json:"payments"`   // 支払い方法 -> 回数
+	Last       StoreChoice    `json:"last"`       // 前回の分類
+	Count      int            `json:"count"`      // 記録した回数
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// StoreSuggestion は店舗の履歴から推定した分類（該当がない項目はHas〜がfalse）
+type StoreSuggestion struct {
+	StoreName     string
+	CategoryID    int
+	HasCategory   bool
+	GroupID       *int
+	HasGroup      bool
+	PaymentMethod string
+	Count         int
+	Last          StoreChoice
+}
+
+// storeHistoryKey は店舗名の表記ゆれ（空白・大文字小文字・全角英数字）を吸収したキーを返す
+func storeHistoryKey(storeName string) string {
+	return normalizeForDuplicateCheck(toHalfWidth(storeName))
+}
+
+// groupHistoryKey はグループIDを集計キーにする
+func groupHistoryKey(groupID *int) string {
+	if groupID == nil {
+		return noGroupKey
+	}
+	return strconv.Itoa(*groupID)
+}
+
+// loadStoreHistories は起動時に店舗の学習データを読み込む
+func (h *Household) loadStoreHistories() error {
+	h.storeHistoryMutex.Lock()
+	defer h.storeHistoryMutex.Unlock()
+
+	h.storeHistories = make(map[string]*StoreHistory)
+	path := h.dataPath(storeHistoryFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return NewBotError(ErrorTypeFileIO, "店舗学習データの読み込みに失敗", err).
+			WithContext("file_path", path)
+	}
+	if err := json.Unmarshal(data, &h.storeHistories); err != nil {
+		h.storeHistories = make(map[string]*StoreHistory)
+		return NewBotError(ErrorTypeFileIO, "店舗学習データのJSONパースエラー", err).
+			WithContext("file_path", path)
+	}
+
+	log.Printf("-> %d店舗の学習データを読み込みました。", len(h.storeHistories))
+	return nil
+}
+
+// saveStoreHistoriesLocked は学習データをファイルに保存する（storeHistoryMutexを保持して呼ぶこと）
+func (h *Household) saveStoreHistoriesLocked() error {
+	data, err := json.MarshalIndent(h.storeHistories, "", "  ")
+	if err != nil {
+		return NewBotError(ErrorTypeFileIO, "店舗学習データJSON生成エラー", err)
+	}
+	path := h.dataPath(storeHistoryFile)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return NewBotError(ErrorTypeFileIO, "店舗学習データの書き込みに失敗", err).
+			WithContext("file_path", path)
+	}
+	return nil
+}
+
+// recordStoreChoice はキューに追加した支出の分類を店舗の履歴に記録する
+func (h *Household) recordStoreChoice(storeName string, choice StoreChoice) {
+	key := storeHistoryKey(storeName)
+	if key == "" {
+		return
+	}
+
+	h.storeHistoryMutex.Lock()
+	defer h.storeHistoryMutex.Unlock()
+
+	if h.storeHistories == nil {
+		h.storeHistories = make(map[string]*StoreHistory)
+	}
+	history, exists := h.storeHistories[key]
+	if !exists {
+		history = &StoreHistory{
+			Categories: make(map[string]int),
+			Groups:     make(map[string]int),
+			Payments:   make(map[string]int),
+		}
+		h.storeHistories[key] = history
+	}
+
+	history.StoreName = strings.TrimSpace(storeName)
+	history.Categories[strconv.Itoa(choice.CategoryID)]++
+	history.Groups[groupHistoryKey(choice.GroupID)]++
+	// 読み取れなかった支払い方法は学習しない
+	if choice.PaymentMethod != "" && choice.PaymentMethod != "不明" {
+		history.Payments[choice.PaymentMethod]++
+	}
+	history.Last = choice
+	history.Count++
+	history.UpdatedAt = time.Now()
+
+	if err := h.saveStoreHistoriesLocked(); err != nil {
+		HandleError(err, nil)
+		return
+	}
+	log.Printf("店舗の分類を学習しました: %s (category=%d, group=%s, payment=%s, %d回目)",
+		history.StoreName, choice.CategoryID, groupHistoryKey(choice.GroupID), choice.PaymentMethod, history.Count)
+}
+
+// mostFrequent は回数が最大のキーを返す（同数の場合は前回の値を優先し、それでも決まらなければキー順）
+func mostFrequent(counts map[string]int, last string) (string, bool) {
+	if len(counts) == 0 {
+		return "", false
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	best := ""
+	for _, key := range keys {
+		if best == "" || counts[key] > counts[best] || (counts[key] == counts[best] && key == last) {
+			best = key
+		}
+	}
+	return best, true
+}
+
+// suggestStoreChoice は店舗の履歴から最も多く選ばれた分類を返す
+func (h *Household) suggestStoreChoice(storeName string) (StoreSuggestion, bool) {
+	key := storeHistoryKey(storeName)
+	if key == "" {
+		return StoreSuggestion{}, false
+	}
+
+	h.storeHistoryMutex.Lock()
+	defer h.storeHistoryMutex.Unlock()
+
+	history, exists := h.storeHistories[key]
+	if !exists || history.Count == 0 {
+		return StoreSuggestion{}, false
+	}
+
+	suggestion := StoreSuggestion{
+		StoreName: history.StoreName,
+		Count:     history.Count,
+		Last:      history.Last,
+	}
+	if category, ok := mostFrequent(history.Categories, strconv.Itoa(history.Last.CategoryID)); ok {
+		if categoryID, err := strconv.Atoi(category); err == nil {
+			suggestion.CategoryID = categoryID
+			suggestion.HasCategory = true
+		}
+	}
+	if group, ok := mostFrequent(history.Groups, groupHistoryKey(history.Last.GroupID)); ok {
+		suggestion.HasGroup = true
+		if groupID, err := strconv.Atoi(group); err == nil {
+			suggestion.GroupID = &groupID
+		}
+	}
+	if payment, ok := mostFrequent(history.Payments, history.Last.PaymentMethod); ok {
+		suggestion.PaymentMethod = payment
+	}
+	return suggestion, true
+}
+
+// receiptStoreName は解析結果の店舗名を返す（読み取れていない場合は空）
+func receiptStoreName(aiResult ReceiptAnalysis) string {
+	if aiResult.StoreName == nil {
+		return ""
+	}
+	return *aiResult.StoreName
+}
+
+// storeHistoryField は前回と同じ分類になっている項目を確認画面に表示するフィールドを返す
+func storeHistoryField(data *ConfirmationData) *discordgo.MessageEmbedField {
+	storeName := receiptStoreName(data.AIResult)
+	suggestion, ok := data.Household.suggestStoreChoice(storeName)
+	if !ok {
+		return nil
+	}
+
+	var same []string
+	if data.CategoryID == suggestion.Last.CategoryID {
+		same = append(same, "カテゴリー")
+	}
+	if groupHistoryKey(data.GroupID) == groupHistoryKey(suggestion.Last.GroupID) {
+		same = append(same, "グループ")
+	}
+	if suggestion.Last.PaymentMethod != "" && data.PaymentMethod == suggestion.Last.PaymentMethod {
+		same = append(same, "支払い方法")
+	}
+	if len(same) == 0 {
+		return nil
+	}
+
+	return &discordgo.MessageEmbedField{
+		Name:   "🔁 前回と同じ",
+		Value:  fmt.Sprintf("%s（「%s」の登録%d回分から学習）", strings.Join(same, "・"), suggestion.StoreName, suggestion.Count),
+		Inline: false,
+	}
+}
+
+// suggestTransactionStoreChoice は解析中のレシートの店舗名から分類を推定する（未解析の場合はfalse）
+func (h *Household) suggestTransactionStoreChoice(messageID string) (StoreSuggestion, bool) {
+	mu.Lock()
+	var storeName string
+	if state, exists := transactions[messageID]; exists {
+		storeName = receiptStoreName(state.LastResult)
+	}
+	mu.Unlock()
+	return h.suggestStoreChoice(storeName)
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/generative-ai-go/genai"
+
+	"yarikuri/receipt"
+)
+
+// =================================================================================
+// 1行のテキストからの支出登録（「ランチ 980 PayPay」など）
+// =================================================================================
+
+const textEntryMaxLength = 80    // これより長い投稿は会話とみなして支出として扱わない
+const textEntryMaxTokens = 8     // これより語数の多い投稿は会話とみなして支出として扱わない
+const textEntryMinNameLength = 2 // マスターデータとの部分一致に使う語の最小文字数（1文字では誤って一致しやすい）
+
+// amountMarkers は語を金額と判断できる記号・単位（「980円」「￥1,280」「1.2万」）
+const amountMarkers = "¥￥円万"
+
+// genericPaymentWords はマスターデータの名前と一致しなくても支払い方法として扱う語
+var genericPaymentWords = []string{"現金", "カード", "クレジット", "クレカ"}
+
+// TextEntry は1行のテキストから読み取った支出の項目
+type TextEntry struct {
+	Text          string
+	Date          string // ISO形式（指定がなければ空）
+	Amount        int    // 金額（AmountCountが1の場合のみ有効）
+	AmountCount   int    // 金額として解釈できた語の数（2以上はどれが金額か判断できない）
+	AmountMarked  bool   // 円・￥などの付いた金額があった
+	PaymentMethod string // 支払い方法（マスターデータの名前）
+	CategoryID    int    // カテゴリーID（HasCategoryがtrueの場合のみ有効）
+	HasCategory   bool
+	UserName      string   // 支払ったユーザー
+	GroupKeyword  string   // グループのキーワード
+	Words         []string // どの項目にも当てはまらなかった語（店舗名・用途として扱う）
+}
+
+// handleTextEntry はチャンネルに投稿された1行のテキストを支出として解釈し、確認画面を表示する
+// 金額と判断できる語を含まない投稿は会話とみなして何もしない（AIを呼ばないよう先に判定する）
+func handleTextEntry(s *discordgo.Session, h *Household, m *discordgo.MessageCreate) {
+	text := strings.TrimSpace(m.Content)
+	if !isTextEntryCandidate(text) {
+		return
+	}
+
+	entry := h.parseTextEntry(text, nowInTokyo())
+	if !entry.hasAmountSignal() {
+		return
+	}
+	log.Printf("テキストから支出を登録: messageID=%s, text=%s", m.ID, text)
+	if err := registerTextEntry(s, h, m.ID, m.Author.ID, entry); err != nil {
+		s.ChannelMessageSendReply(m.ChannelID, fmt.Sprintf("❌ %s", describeAnalysisError(err)), m.Reference())
+	}
+}
+
+// registerTextEntry は読み取った項目（足りない項目はAIで補う）から確認画面を表示する
+func registerTextEntry(s *discordgo.Session, h *Household, messageID, posterID string, entry TextEntry) error {
+	var promptVersions []string
+	if h.needsAIForTextEntry(entry) {
+		if aiEntry, promptVersion, err := h.analyzeTextEntry(entry.Text); err != nil {
+			// AIが使えなくても、読み取れた内容で確認画面を開き手で直してもらう
+			botErr := NewBotError(ErrorTypeAIService, "テキストの解析に失敗", err).
+				WithContext("message_id", messageID)
+			LogBotError(botErr)
+		} else {
+			entry = mergeTextEntry(entry, aiEntry)
+			promptVersions = append(promptVersions, promptVersion)
+		}
+	}
+	if entry.AmountCount != 1 {
+		return NewBotError(ErrorTypeValidation, "金額を判断できませんでした。「ランチ 980 PayPay」のように金額を1つだけ含めてください", nil).
+			WithContext("text", entry.Text)
+	}
+
+	aiResult, userInput := textEntryToInput(entry)
+	aiResult.PromptVersions = promptVersions
+	sendConfirmationFromInput(s, h, messageID, posterID, "", userInput, aiResult, false)
+	return nil
+}
+
+// isTextEntryCandidate は投稿が1行の支出メモとして解釈する対象かを判定する
+func isTextEntryCandidate(text string) bool {
+	if text == "" || strings.ContainsAny(text, "\n") || utf8.RuneCountInString(text) > textEntryMaxLength {
+		return false
+	}
+	if len(strings.Fields(text)) > textEntryMaxTokens {
+		return false
+	}
+	return strings.ContainsFunc(text, unicode.IsDigit)
+}
+
+// hasAmountSignal は投稿を支出として扱える金額があるかを返す
+// 数字だけの投稿（「3」など）は会話とみなし、円・￥などが付いているか、用途などの語が並んでいる場合に限る
+func (entry TextEntry) hasAmountSignal() bool {
+	if entry.AmountCount == 0 {
+		return false
+	}
+	return entry.AmountMarked || len(entry.Words) > 0 || entry.HasCategory ||
+		entry.PaymentMethod != "" || entry.UserName != "" || entry.GroupKeyword != ""
+}
+
+// parseTextEntry は空白で区切られた語を日付・金額・支払い方法・カテゴリー・ユーザー・グループに振り分ける
+// 日付は金額より先に判定する（「20250819」を金額として読まないため）
+func (h *Household) parseTextEntry(text string, now time.Time) TextEntry {
+	entry := TextEntry{Text: text}
+	for _, word := range strings.Fields(text) {
+		if entry.Date == "" {
+			if date, err := normalizeDate(word, now); err == nil {
+				entry.Date = date
+				continue
+			}
+		}
+		if strings.ContainsFunc(word, unicode.IsDigit) {
+			if amount, err := parseAmount(word); err == nil {
+				entry.AmountCount++
+				entry.Amount = amount
+				entry.AmountMarked = entry.AmountMarked || strings.ContainsAny(word, amountMarkers)
+				continue
+			}
+		}
+		if entry.PaymentMethod == "" {
+			if payment, ok := h.findPaymentMethodByWord(word); ok {
+				entry.PaymentMethod = payment
+				continue
+			}
+		}
+		if utf8.RuneCountInString(word) >= textEntryMinNameLength {
+			if !entry.HasCategory {
+				if categoryID, ok := h.findCategoryIDByName(word); ok {
+					entry.CategoryID, entry.HasCategory = categoryID, true
+					continue
+				}
+			}
+			if entry.UserName == "" {
+				if _, ok := h.findUserByName(word); ok {
+					entry.UserName = word
+					continue
+				}
+			}
+			if entry.GroupKeyword == "" && h.findGroupByKeyword(word) != nil {
+				entry.GroupKeyword = word
+				continue
+			}
+		}
+		entry.Words = append(entry.Words, word)
+	}
+
+	// カテゴリー名そのものがなければ「ランチ」→食費のような連想で探す
+	if !entry.HasCategory {
+		for _, word := range entry.Words {
+			for _, category := range h.masterCategories {
+				if matchCategoryKeywords(category.Name, word) {
+					entry.CategoryID, entry.HasCategory = category.ID, true
+					break
+				}
+			}
+			if entry.HasCategory {
+				break
+			}
+		}
+	}
+	return entry
+}
+
+// findPaymentMethodByWord は語に一致する支払い方法をマスターデータから探す
+func (h *Household) findPaymentMethodByWord(word string) (string, bool) {
+	normalized := normalizeRuleText(word)
+	if normalized == "" {
+		return "", false
+	}
+	for _, payment := range h.masterPaymentTypes {
+		if normalizeRuleText(payment.PayKind) == normalized {
+			return payment.PayKind, true
+		}
+	}
+	if utf8.RuneCountInString(normalized) >= textEntryMinNameLength {
+		for _, payment := range h.masterPaymentTypes {
+			if strings.Contains(normalizeRuleText(payment.PayKind), normalized) {
+				return payment.PayKind, true
+			}
+		}
+	}
+	for _, generic := range genericPaymentWords {
+		if normalized == normalizeRuleText(generic) {
+			return h.enhancePaymentMethod(word), true
+		}
+	}
+	return "", false
+}
+
+// needsAIForTextEntry はローカルの解析だけでは項目が決まらずAIに任せるかを判定する
+func (h *Household) needsAIForTextEntry(entry TextEntry) bool {
+	if entry.AmountCount != 1 {
+		return true
+	}
+	if entry.HasCategory {
+		return false
+	}
+	// 過去に同じ店舗・用途で選ばれたカテゴリーがあれば確認画面でそれを使う
+	if suggestion, ok := h.suggestStoreChoice(strings.Join(entry.Words, " ")); ok && suggestion.HasCategory {
+		return false
+	}
+	return true
+}
+
+// analyzeTextEntry はテキストをAIで項目に分解する
+func (h *Household) analyzeTextEntry(text string) (TextEntry, string, error) {
+	now := nowInTokyo()
+	categories, groups, users := h.masterNames()
+	var paymentMethods []string
+	for _, payment := range h.masterPaymentTypes {
+		paymentMethods = append(paymentMethods, payment.PayKind)
+	}
+	prompt, promptVersion, err := renderPrompt(receipt.EntryPrompt, receipt.EntryPromptData{
+		Text:           text,
+		Today:          now.Format(isoDateLayout),
+		Weekday:        weekdayLabel(now),
+		Categories:     categories,
+		Groups:         groups,
+		Users:          users,
+		PaymentMethods: paymentMethods,
+	})
+	if err != nil {
+		return TextEntry{}, "", err
+	}
+
+	resp, _, err := geminiClient.GenerateContent(context.Background(), genai.Text(prompt))
+	if err != nil {
+		message := "テキストの解析に失敗しました"
+		if description := describeAIError(err); description != "" {
+			message = description
+		}
+		return TextEntry{}, "", NewBotError(ErrorTypeAIService, message, err).
+			WithContext("text", text)
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return TextEntry{}, "", NewBotError(ErrorTypeAIService, "AIから応答が返されませんでした", nil)
+	}
+	response, _ := resp.Candidates[0].Content.Parts[0].(genai.Text)
+	log.Printf("テキストの解析結果 (%s): %s", promptVersion, string(response))
+	return h.parseTextEntryResponse(string(response), text), promptVersion, nil
+}
+
+// parseTextEntryResponse はAIの応答をTextEntryに変換する（マスターデータにない名前は捨てる）
+func (h *Household) parseTextEntryResponse(response, text string) TextEntry {
+	entry := TextEntry{Text: text}
+	analysis := receipt.ParseResponse(response, h.enhancePaymentMethod)
+	if analysis.Date != nil {
+		if date, err := normalizeDate(*analysis.Date, nowInTokyo()); err == nil {
+			entry.Date = date
+		}
+	}
+	if analysis.TotalAmount != nil {
+		entry.Amount, entry.AmountCount = *analysis.TotalAmount, 1
+	}
+	if analysis.PaymentMethod != nil {
+		if payment, ok := h.findPaymentMethodByWord(*analysis.PaymentMethod); ok {
+			entry.PaymentMethod = payment
+		}
+	}
+	if analysis.StoreName != nil {
+		entry.Words = strings.Fields(*analysis.StoreName)
+	}
+	if categoryName := textEntryField(response, "カテゴリー"); categoryName != "" {
+		entry.CategoryID, entry.HasCategory = h.findCategoryIDByName(categoryName)
+	}
+	if groupName := textEntryField(response, "グループ"); groupName != "" && groupName != "null" {
+		if h.findGroupByKeyword(groupName) != nil {
+			entry.GroupKeyword = groupName
+		}
+	}
+	if userName := textEntryField(response, "ユーザー"); userName != "" {
+		if _, ok := h.findUserByName(userName); ok {
+			entry.UserName = userName
+		}
+	}
+	return entry
+}
+
+// textEntryField はAIの応答から「項目名: 値」の行の値を取り出す（不明なら空）
+func textEntryField(response, label string) string {
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSpace(line)
+		for _, separator := range []string{":", "："} {
+			if value, ok := strings.CutPrefix(line, label+separator); ok {
+				value = strings.TrimSpace(value)
+				if value == "不明" {
+					return ""
+				}
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// mergeTextEntry はローカルで読み取れなかった項目をAIの結果で補う（投稿に明示された語を優先する）
+func mergeTextEntry(local, ai TextEntry) TextEntry {
+	merged := local
+	if local.AmountCount != 1 && ai.AmountCount == 1 {
+		merged.Amount, merged.AmountCount = ai.Amount, 1
+	}
+	if merged.Date == "" {
+		merged.Date = ai.Date
+	}
+	if merged.PaymentMethod == "" {
+		merged.PaymentMethod = ai.PaymentMethod
+	}
+	if !merged.HasCategory && ai.HasCategory {
+		merged.CategoryID, merged.HasCategory = ai.CategoryID, true
+	}
+	if merged.UserName == "" {
+		merged.UserName = ai.UserName
+	}
+	if merged.GroupKeyword == "" {
+		merged.GroupKeyword = ai.GroupKeyword
+	}
+	if len(ai.Words) > 0 {
+		merged.Words = ai.Words
+	}
+	return merged
+}
+
+// textEntryToInput は読み取った項目を確認画面の入力（解析結果と補足情報）に変換する
+func textEntryToInput(entry TextEntry) (ReceiptAnalysis, map[string]string) {
+	date := entry.Date
+	if date == "" {
+		date = todayInTokyo()
+	}
+	amount := entry.Amount
+	aiResult := ReceiptAnalysis{
+		Date:        &date,
+		TotalAmount: &amount,
+	}
+	aiResult.IsReceipt = true
+	if entry.PaymentMethod != "" {
+		payment := entry.PaymentMethod
+		aiResult.PaymentMethod = &payment
+	}
+	if len(entry.Words) > 0 {
+		storeName := strings.Join(entry.Words, " ")
+		aiResult.StoreName = &storeName
+	}
+
+	userInput := map[string]string{
+		"price":     strconv.Itoa(entry.Amount),
+		"user_name": entry.UserName,
+	}
+	if entry.HasCategory {
+		userInput["category_id"] = strconv.Itoa(entry.CategoryID)
+	}
+	if entry.GroupKeyword != "" {
+		userInput["group_keyword"] = entry.GroupKeyword
+	}
+	if entry.PaymentMethod != "" {
+		// 支払い方法は解析結果として渡し、ここでは自動分類ルールで上書きしないための印にする
+		userInput["payment_method"] = entry.PaymentMethod
+	}
+	return aiResult, userInput
+}
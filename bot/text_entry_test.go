@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTextEntry(t *testing.T) {
+	h := &Household{
+		masterCategories:   []Category{{ID: 1, Name: "食費"}, {ID: 2, Name: "交通費"}},
+		masterPaymentTypes: []PaymentType{{PayID: 1, PayKind: "PayPay"}},
+	}
+	now := time.Date(2025, 8, 20, 12, 0, 0, 0, tokyoLocation)
+
+	tests := []struct {
+		text    string
+		date    string
+		amount  int
+		count   int
+		payment string
+		signal  bool
+	}{
+		{"ランチ 980 PayPay", "", 980, 1, "PayPay", true},
+		{"980円", "", 980, 1, "", true},
+		{"￥1,280", "", 1280, 1, "", true},
+		{"20250819 ランチ 980", "2025-08-19", 980, 1, "", true},
+		{"8/19 交通費 1200", "2025-08-19", 1200, 1, "", true},
+		{"昨日 ¥500", "2025-08-19", 500, 1, "", true},
+		{"3", "", 3, 1, "", false},
+		{"20250819", "2025-08-19", 0, 0, "", false},
+		{"10時に集合", "", 0, 0, "", false},
+		{"ランチ 980 1200", "", 1200, 2, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			entry := h.parseTextEntry(tt.text, now)
+			if entry.Date != tt.date || entry.AmountCount != tt.count || (tt.count > 0 && entry.Amount != tt.amount) || entry.PaymentMethod != tt.payment {
+				t.Errorf("parseTextEntry(%q) = date %q, amount %d (%d件), payment %q, want date %q, amount %d (%d件), payment %q",
+					tt.text, entry.Date, entry.Amount, entry.AmountCount, entry.PaymentMethod, tt.date, tt.amount, tt.count, tt.payment)
+			}
+			if got := entry.hasAmountSignal(); got != tt.signal {
+				t.Errorf("hasAmountSignal(%q) = %t, want %t", tt.text, got, tt.signal)
+			}
+		})
+	}
+}